@@ -4,6 +4,7 @@ go 1.26.3
 
 require (
 	github.com/andybalholm/brotli v1.2.1
+	github.com/apache/arrow-go/v18 v18.6.0
 	github.com/dlclark/regexp2 v1.12.0
 	github.com/grafana/grafana-plugin-sdk-go v0.292.1
 	github.com/klauspost/compress v1.18.6
@@ -13,7 +14,6 @@ require (
 
 require (
 	github.com/BurntSushi/toml v1.6.0 // indirect
-	github.com/apache/arrow-go/v18 v18.6.0 // indirect
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cenkalti/backoff/v5 v5.0.3 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Backend annotation mode, it follows src/datasource/sql-series/toAnnotation.ts and
+// ResponseParser.transformAnnotationResponse: time column is mandatory, time_end (or timeEnd) makes
+// a region, title, text and tags are optional, tags are comma separated strings or Array(String).
+
+const (
+	// QueryFormatAnnotations is the value of Query.Format which requests annotations frame
+	QueryFormatAnnotations = "annotations"
+
+	// annotationsRefId is refId which Grafana and the frontend use for annotation queries
+	annotationsRefId = "Anno"
+)
+
+var annotationTagsSeparatorRE = regexp.MustCompile(`\s*,\s*`)
+
+// isAnnotationsQuery reports whether the query should return annotations frame
+func isAnnotationsQuery(query *Query) bool {
+	return query.Format == QueryFormatAnnotations || query.RefId == annotationsRefId
+}
+
+func (r *Response) toFramesAnnotations(query *Query, fetchTZ FetchTZFunc) (data.Frames, error) {
+	if r.metaByName("time") == nil {
+		return nil, errors.New("missing mandatory time column in annotation query")
+	}
+	timeEndField := "time_end"
+	if r.metaByName(timeEndField) == nil {
+		timeEndField = "timeEnd"
+	}
+	timeZonesMap, metaTypes := r.analyzeResponseMeta(fetchTZ)
+
+	timeField := data.NewField("time", nil, []time.Time{})
+	timeEnd := data.NewField("timeEnd", nil, []*time.Time{})
+	title := data.NewField("title", nil, []string{})
+	text := data.NewField("text", nil, []string{})
+	tags := data.NewField("tags", nil, []json.RawMessage{})
+
+	err := r.forEachRow(func(row map[string]interface{}) error {
+		ms, err := traceMilliseconds(row["time"], metaTypes["time"], timeZonesMap["time"])
+		if err != nil {
+			return err
+		}
+		timeField.Append(annotationTime(ms))
+
+		var end *time.Time
+		if endMs, err := traceMilliseconds(row[timeEndField], metaTypes[timeEndField], timeZonesMap[timeEndField]); err == nil && endMs > 0 {
+			t := annotationTime(endMs)
+			end = &t
+		}
+		timeEnd.Append(end)
+		title.Append(traceString(row["title"]))
+		text.Append(traceString(row["text"]))
+		tags.Append(traceJSON(annotationTags(row["tags"])))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	frame := data.NewFrame("", timeField, timeEnd, title, text, tags)
+	frame.RefID = query.RefId
+	return data.Frames{frame}, nil
+}
+
+// annotationTime drops fractions of milliseconds as Math.floor in transformAnnotationResponse does
+func annotationTime(ms float64) time.Time {
+	return time.UnixMilli(int64(ms))
+}
+
+func annotationTags(value interface{}) []string {
+	tags := []string{}
+	switch v := value.(type) {
+	case []interface{}:
+		for _, tag := range v {
+			if tagStr := traceString(tag); tagStr != "" {
+				tags = append(tags, tagStr)
+			}
+		}
+	default:
+		if tagsStr := strings.TrimSpace(traceString(v)); tagsStr != "" {
+			tags = annotationTagsSeparatorRE.Split(tagsStr, -1)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToFramesAnnotations(t *testing.T) {
+	r := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "time", Type: "UInt64"},
+			{Name: "time_end", Type: "Nullable(DateTime('UTC'))"},
+			{Name: "title", Type: "String"},
+			{Name: "text", Type: "String"},
+			{Name: "tags", Type: "String"},
+		},
+		Data: []map[string]interface{}{
+			{"time": json.Number("1705312800123"), "time_end": "2024-01-15 10:05:00", "title": "deploy", "text": "v1.2", "tags": " release , prod"},
+			{"time": json.Number("1705312900000"), "time_end": nil, "title": "restart", "text": "", "tags": ""},
+		},
+	}
+
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatAnnotations}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	frame := frames[0]
+	require.Equal(t, 2, frame.Rows())
+
+	require.Equal(t, time.UnixMilli(1705312800123), frame.Fields[0].At(0))
+	end := time.Date(2024, 1, 15, 10, 5, 0, 0, time.UTC)
+	require.True(t, end.Equal(*frame.Fields[1].At(0).(*time.Time)))
+	require.Nil(t, frame.Fields[1].At(1))
+	require.Equal(t, "deploy", frame.Fields[2].At(0))
+	require.JSONEq(t, `["release","prod"]`, string(frame.Fields[4].At(0).(json.RawMessage)))
+	require.JSONEq(t, `[]`, string(frame.Fields[4].At(1).(json.RawMessage)))
+}
+
+func TestToFramesAnnotationsRequiresTime(t *testing.T) {
+	r := &Response{
+		ctx:  context.Background(),
+		Meta: []*FieldMeta{{Name: "title", Type: "String"}},
+	}
+	_, err := r.toFrames(&Query{RefId: annotationsRefId}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.Error(t, err)
+}
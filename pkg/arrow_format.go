@@ -0,0 +1,559 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ArrowStream response format.
+//
+// ClickHouse writes ArrowStream columns in binary form and Grafana frames are Arrow underneath,
+// so values are copied from record batches into frame fields directly, without the text round
+// trip of the ParseValue family. The Arrow schema doesn't keep ClickHouse types (DateTime is
+// UInt32, Date is UInt16, Enum is Int8), so the client describes the query first and falls back
+// to JSON when some column type is not supported by arrowTypeSupported.
+// See https://clickhouse.com/docs/en/interfaces/formats#data-types-matching-arrow
+
+// arrowResult keeps record batches of ArrowStream response until frames are built.
+type arrowResult struct {
+	records []arrow.RecordBatch
+}
+
+func (a *arrowResult) release() {
+	for _, record := range a.records {
+		record.Release()
+	}
+	a.records = nil
+}
+
+// readArrowStream reads all record batches of the body, meta contains column names and Arrow types.
+func readArrowStream(body io.Reader) (*arrowResult, []*FieldMeta, error) {
+	result := &arrowResult{}
+	reader, err := ipc.NewReader(body)
+	if err != nil {
+		// empty body, nothing to read
+		if errors.Is(err, io.EOF) {
+			return result, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer reader.Release()
+
+	meta := make([]*FieldMeta, 0, reader.Schema().NumFields())
+	for _, field := range reader.Schema().Fields() {
+		meta = append(meta, &FieldMeta{Name: field.Name, Type: field.Type.String()})
+	}
+	for reader.Next() {
+		record := reader.RecordBatch()
+		record.Retain()
+		result.records = append(result.records, record)
+	}
+	if err = reader.Err(); err != nil {
+		result.release()
+		return nil, nil, err
+	}
+	return result, meta, nil
+}
+
+// normalizeArrowType strips LowCardinality and Nullable wrappers.
+func normalizeArrowType(chType string) (string, bool) {
+	isNullable := false
+	for {
+		name, args := unwrapType(chType)
+		switch name {
+		case "LowCardinality":
+			chType = args
+		case "Nullable":
+			chType = args
+			isNullable = true
+		default:
+			return chType, isNullable
+		}
+	}
+}
+
+// arrowTypeSupported reports whether values of the ClickHouse type could be copied from ArrowStream
+// into the field type NewDataFieldByTypeOptimized creates for it. Compound types are rendered
+// as JSON strings by ParseValue, so they're left to the JSON format.
+func arrowTypeSupported(chType string) bool {
+	base, _ := normalizeArrowType(chType)
+	name, _ := unwrapType(base)
+	switch name {
+	case "UInt8", "UInt16", "UInt32", "UInt64", "Int8", "Int16", "Int32", "Int64", "Float32", "Float64",
+		"Decimal", "Decimal32", "Decimal64", "Decimal128", "Decimal256",
+		"String", "FixedString", "IPv4", "IPv6", "Enum8", "Enum16",
+		"Date", "Date32", "DateTime", "DateTime64":
+		return true
+	default:
+		return false
+	}
+}
+
+type arrowValueKind int
+
+const (
+	arrowKindFloat arrowValueKind = iota
+	arrowKindString
+	arrowKindTime
+)
+
+// arrowColumn converts values of one Arrow column into the Go type of its frame field.
+type arrowColumn struct {
+	name       string
+	chType     string
+	typeName   string
+	kind       arrowValueKind
+	isNullable bool
+	tz         *time.Location
+	enumNames  map[int64]string
+}
+
+func newArrowColumn(meta *FieldMeta, tz *time.Location, needsStringPrecision bool) (*arrowColumn, error) {
+	t := parseClickHouseType(meta.Type)
+	column := &arrowColumn{
+		name:       meta.Name,
+		chType:     meta.Type,
+		typeName:   t.name,
+		isNullable: t.isNullable(),
+		tz:         tz,
+	}
+	if column.tz == nil {
+		column.tz = time.UTC
+	}
+	// keep the same decisions as NewDataFieldByTypeOptimized
+	switch t.fieldKind(meta.Name) {
+	case fieldKindTimestamp, fieldKindTime:
+		column.kind = arrowKindTime
+	case fieldKindWideNumber:
+		if needsStringPrecision {
+			column.kind = arrowKindString
+		}
+	case fieldKindString:
+		column.kind = arrowKindString
+		if t.name == "Enum8" || t.name == "Enum16" {
+			if t.enumNames == nil {
+				return nil, fmt.Errorf("unable to parse enum values of %s in column %s", meta.Type, meta.Name)
+			}
+			column.enumNames = t.enumNames
+		}
+	}
+	return column, nil
+}
+
+// newField creates the frame field for the column
+func (c *arrowColumn) newField(fieldName string) *data.Field {
+	return NewDataFieldByTypeOptimized(fieldName, c.chType, c.kind == arrowKindString)
+}
+
+// value returns the value of row i in the Go type of the frame field
+func (c *arrowColumn) value(arr arrow.Array, i int) (interface{}, error) {
+	if arr.IsNull(i) {
+		if c.isNullable {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unexpected NULL in column %s %s", c.name, c.chType)
+	}
+	var v interface{}
+	var err error
+	switch c.kind {
+	case arrowKindFloat:
+		v, err = c.floatValue(arr, i)
+	case arrowKindString:
+		v, err = c.stringValue(arr, i)
+	case arrowKindTime:
+		v, err = c.timeValue(arr, i)
+	}
+	if err != nil || !c.isNullable {
+		return v, err
+	}
+	switch typed := v.(type) {
+	case float64:
+		return &typed, nil
+	case string:
+		return &typed, nil
+	case time.Time:
+		return &typed, nil
+	}
+	return v, nil
+}
+
+func (c *arrowColumn) unsupported(arr arrow.Array) error {
+	return fmt.Errorf("unable to convert arrow %s to %s in column %s", arr.DataType(), c.chType, c.name)
+}
+
+func (c *arrowColumn) floatValue(arr arrow.Array, i int) (float64, error) {
+	switch a := arr.(type) {
+	case *array.Dictionary:
+		return c.floatValue(a.Dictionary(), a.GetValueIndex(i))
+	case *array.Uint8:
+		return float64(a.Value(i)), nil
+	case *array.Uint16:
+		return float64(a.Value(i)), nil
+	case *array.Uint32:
+		return float64(a.Value(i)), nil
+	case *array.Uint64:
+		return float64(a.Value(i)), nil
+	case *array.Int8:
+		return float64(a.Value(i)), nil
+	case *array.Int16:
+		return float64(a.Value(i)), nil
+	case *array.Int32:
+		return float64(a.Value(i)), nil
+	case *array.Int64:
+		return float64(a.Value(i)), nil
+	case *array.Float32:
+		return float64(a.Value(i)), nil
+	case *array.Float64:
+		return a.Value(i), nil
+	case *array.Decimal128:
+		return a.Value(i).ToFloat64(a.DataType().(arrow.DecimalType).GetScale()), nil
+	case *array.Decimal256:
+		return a.Value(i).ToFloat64(a.DataType().(arrow.DecimalType).GetScale()), nil
+	}
+	return 0, c.unsupported(arr)
+}
+
+func (c *arrowColumn) stringValue(arr arrow.Array, i int) (string, error) {
+	switch a := arr.(type) {
+	case *array.Dictionary:
+		return c.stringValue(a.Dictionary(), a.GetValueIndex(i))
+	case *array.String:
+		return a.Value(i), nil
+	case *array.LargeString:
+		return a.Value(i), nil
+	case *array.StringView:
+		return a.Value(i), nil
+	case *array.Binary:
+		return a.ValueString(i), nil
+	case *array.LargeBinary:
+		return a.ValueString(i), nil
+	case *array.FixedSizeBinary:
+		if c.typeName == "IPv6" {
+			return net.IP(a.Value(i)).String(), nil
+		}
+		return string(a.Value(i)), nil
+	case *array.Uint32:
+		if c.typeName == "IPv4" {
+			v := a.Value(i)
+			return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).String(), nil
+		}
+	case *array.Uint64:
+		return strconv.FormatUint(a.Value(i), 10), nil
+	case *array.Int64:
+		return strconv.FormatInt(a.Value(i), 10), nil
+	case *array.Decimal128:
+		return a.Value(i).ToString(a.DataType().(arrow.DecimalType).GetScale()), nil
+	case *array.Decimal256:
+		return a.Value(i).ToString(a.DataType().(arrow.DecimalType).GetScale()), nil
+	case *array.Int8:
+		if c.enumNames != nil {
+			return c.enumNames[int64(a.Value(i))], nil
+		}
+	case *array.Int16:
+		if c.enumNames != nil {
+			return c.enumNames[int64(a.Value(i))], nil
+		}
+	}
+	return "", c.unsupported(arr)
+}
+
+func (c *arrowColumn) timeValue(arr arrow.Array, i int) (time.Time, error) {
+	switch a := arr.(type) {
+	case *array.Dictionary:
+		return c.timeValue(a.Dictionary(), a.GetValueIndex(i))
+	case *array.Timestamp:
+		return a.Value(i).ToTime(a.DataType().(*arrow.TimestampType).Unit), nil
+	case *array.Uint32:
+		return time.Unix(int64(a.Value(i)), 0), nil
+	case *array.Uint16:
+		// Date is the midnight in the column time zone, the same as ParseValue does for "YYYY-MM-DD"
+		return time.Date(1970, 1, 1+int(a.Value(i)), 0, 0, 0, 0, c.tz), nil
+	case *array.Int32:
+		return time.Date(1970, 1, 1+int(a.Value(i)), 0, 0, 0, 0, c.tz), nil
+	case *array.Date32:
+		return time.Date(1970, 1, 1+int(a.Value(i)), 0, 0, 0, 0, c.tz), nil
+	case *array.Uint64:
+		// plugin specific corner case, t is a timestamp in milliseconds
+		return time.Unix(0, int64(a.Value(i))*int64(time.Millisecond)), nil
+	case *array.Int64:
+		return time.Unix(0, a.Value(i)*int64(time.Millisecond)), nil
+	}
+	return time.Time{}, c.unsupported(arr)
+}
+
+// rowValue returns the value of row i as the JSON format decodes it, see arrowResult.forEachRow
+func (c *arrowColumn) rowValue(arr arrow.Array, i int) (interface{}, error) {
+	if arr.IsNull(i) {
+		return nil, nil
+	}
+	if parseClickHouseType(c.chType).fieldKind(c.name) == fieldKindWideNumber {
+		v, err := c.stringValue(arr, i)
+		return json.Number(v), err
+	}
+	switch c.kind {
+	case arrowKindString:
+		return c.stringValue(arr, i)
+	case arrowKindTime:
+		return c.timeValue(arr, i)
+	default:
+		return c.floatValue(arr, i)
+	}
+}
+
+// forEachRow calls fn with rows converted back to maps, for frame builders which don't have an Arrow specific path.
+func (a *arrowResult) forEachRow(meta []*FieldMeta, fn func(row map[string]interface{}) error) error {
+	columns := make([]*arrowColumn, len(meta))
+	for idx := range meta {
+		column, err := newArrowColumn(meta[idx], nil, false)
+		if err != nil {
+			return err
+		}
+		columns[idx] = column
+	}
+	row := make(map[string]interface{}, len(meta))
+	for _, record := range a.records {
+		if int(record.NumCols()) != len(columns) {
+			return fmt.Errorf("arrow record contains %d columns, expected %d", record.NumCols(), len(columns))
+		}
+		for i := 0; i < int(record.NumRows()); i++ {
+			for idx, column := range columns {
+				v, err := column.rowValue(record.Column(idx), i)
+				if err != nil {
+					return err
+				}
+				row[column.name] = v
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// labelValue formats the value as series label
+func (c *arrowColumn) labelValue(arr arrow.Array, i int) (string, error) {
+	if arr.IsNull(i) {
+		return "null", nil
+	}
+	return c.stringValue(arr, i)
+}
+
+// analyzeArrowColumnPrecisionNeeds is analyzeColumnPrecisionNeeds for binary [U]Int64 and Decimal values.
+func (r *Response) analyzeArrowColumnPrecisionNeeds(policy string, streaming bool) map[string]bool {
+	needsStringPrecision := map[string]bool{}
+	metaTypes := map[string]string{}
+	for idx, meta := range r.Meta {
+		metaTypes[meta.Name] = meta.Type
+		if parseClickHouseType(meta.Type).fieldKind(meta.Name) != fieldKindWideNumber {
+			continue
+		}
+		needsStringPrecision[meta.Name] = policy == PrecisionPolicyString
+		if policy == PrecisionPolicyFloat64 || policy == PrecisionPolicyString || streaming {
+			continue
+		}
+		for _, record := range r.arrow.records {
+			if arrowColumnHasUnsafeValues(record.Column(idx)) {
+				needsStringPrecision[meta.Name] = true
+				break
+			}
+		}
+	}
+	if streaming && policy != PrecisionPolicyFloat64 && policy != PrecisionPolicyString {
+		return r.analyzeTypePrecisionNeeds(metaTypes, needsStringPrecision, policy)
+	}
+	if policy == PrecisionPolicySplit {
+		r.precision = newPrecisionTracker(needsStringPrecision, metaTypes, policy)
+		for fieldName, needsString := range needsStringPrecision {
+			r.precision.unsafe[fieldName] = needsString
+		}
+	}
+	return needsStringPrecision
+}
+
+func arrowColumnHasUnsafeValues(arr arrow.Array) bool {
+	switch a := arr.(type) {
+	case *array.Dictionary:
+		return arrowColumnHasUnsafeValues(a.Dictionary())
+	case *array.Uint64:
+		for i := 0; i < a.Len(); i++ {
+			if a.IsValid(i) && a.Value(i) > maxSafeInteger {
+				return true
+			}
+		}
+	case *array.Int64:
+		for i := 0; i < a.Len(); i++ {
+			if !a.IsValid(i) {
+				continue
+			}
+			if v := a.Value(i); (v < 0 && uint64(-v) > minSafeIntegerAbs) || (v >= 0 && uint64(v) > maxSafeInteger) {
+				return true
+			}
+		}
+	case *array.Decimal128:
+		scale := a.DataType().(arrow.DecimalType).GetScale()
+		for i := 0; i < a.Len(); i++ {
+			if a.IsValid(i) && decimalSignificantDigits(a.Value(i).ToString(scale)) > maxSafeDecimalDigits {
+				return true
+			}
+		}
+	case *array.Decimal256:
+		scale := a.DataType().(arrow.DecimalType).GetScale()
+		for i := 0; i < a.Len(); i++ {
+			if a.IsValid(i) && decimalSignificantDigits(a.Value(i).ToString(scale)) > maxSafeDecimalDigits {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toFramesArrow builds the same frames as toFramesWithTimeStamp and toFramesTable from record batches.
+func (r *Response) toFramesArrow(query *Query, fetchTZ FetchTZFunc) (data.Frames, error) {
+	timeZonesMap, _ := r.analyzeResponseMeta(fetchTZ)
+	needsStringPrecision := r.analyzeArrowColumnPrecisionNeeds(query.PrecisionPolicy, query.streaming)
+
+	columns := make([]*arrowColumn, len(r.Meta))
+	for idx, meta := range r.Meta {
+		column, err := newArrowColumn(meta, timeZonesMap[meta.Name], needsStringPrecision[meta.Name])
+		if err != nil {
+			return nil, err
+		}
+		columns[idx] = column
+	}
+	for _, record := range r.arrow.records {
+		if int(record.NumCols()) != len(columns) {
+			return nil, fmt.Errorf("arrow record contains %d columns, expected %d", record.NumCols(), len(columns))
+		}
+	}
+
+	if timeStampFieldIdx, hasTimeStamp := r.getTimestampFieldIdx(); hasTimeStamp {
+		return r.toFramesArrowWithTimeStamp(query, columns, timeStampFieldIdx)
+	}
+
+	frames := data.Frames{}
+	for idx, column := range columns {
+		field := column.newField(column.name)
+		for _, record := range r.arrow.records {
+			arr := record.Column(idx)
+			for i := 0; i < arr.Len(); i++ {
+				v, err := column.value(arr, i)
+				if err != nil {
+					return nil, err
+				}
+				field.Append(v)
+			}
+		}
+		frame := data.NewFrame(column.name, field)
+		frame.RefID = query.RefId
+		frames = append(frames, frame)
+		if r.precision != nil {
+			r.precision.track(column.name, field)
+		}
+	}
+	if r.precision != nil {
+		r.precision.finishFields(frames)
+	}
+	return frames, nil
+}
+
+func (r *Response) toFramesArrowWithTimeStamp(query *Query, columns []*arrowColumn, timeStampFieldIdx int) (data.Frames, error) {
+	labelFieldsMap, hasLabelFields := r.prepareLabelFieldsMap()
+	// 1 value field + 1 timestamp field
+	hasMultipleTimeSeries := (len(r.Meta) - len(labelFieldsMap)) > 2
+
+	// frame name is built from label values ordered by label name
+	labelIdx := make([]int, 0, len(labelFieldsMap))
+	for _, idx := range labelFieldsMap {
+		labelIdx = append(labelIdx, idx)
+	}
+	sort.Slice(labelIdx, func(i, j int) bool { return r.Meta[labelIdx[i]].Name < r.Meta[labelIdx[j]].Name })
+
+	valueIdx := make([]int, 0, len(columns))
+	for idx := range columns {
+		if _, isLabel := labelFieldsMap[r.Meta[idx].Name]; !isLabel && idx != timeStampFieldIdx {
+			valueIdx = append(valueIdx, idx)
+		}
+	}
+
+	timeStampColumn := *columns[timeStampFieldIdx]
+	timeStampColumn.kind = arrowKindTime
+	timeStampColumn.isNullable = false
+
+	type series struct {
+		timeField  *data.Field
+		valueField *data.Field
+	}
+	frames := data.Frames{}
+	seriesMap := map[string]*series{}
+	labelValues := make([]string, len(labelIdx))
+
+	for _, record := range r.arrow.records {
+		for row := 0; row < int(record.NumRows()); row++ {
+			ts, err := timeStampColumn.value(record.Column(timeStampFieldIdx), row)
+			if err != nil {
+				return nil, err
+			}
+			for i, idx := range labelIdx {
+				if labelValues[i], err = columns[idx].labelValue(record.Column(idx), row); err != nil {
+					return nil, err
+				}
+			}
+			framePrefix := strings.Join(labelValues, ", ")
+
+			for _, idx := range valueIdx {
+				column := columns[idx]
+				frameName := column.name
+				if hasLabelFields {
+					frameName = framePrefix
+					if hasMultipleTimeSeries {
+						frameName += ", " + column.name
+					}
+				}
+				s, exists := seriesMap[frameName]
+				if !exists {
+					s = &series{
+						timeField:  data.NewField(r.Meta[timeStampFieldIdx].Name, nil, []time.Time{}),
+						valueField: column.newField(frameName),
+					}
+					if hasLabelFields {
+						labels := data.Labels{}
+						for i, labelColumnIdx := range labelIdx {
+							labels[r.Meta[labelColumnIdx].Name] = labelValues[i]
+						}
+						s.valueField.Labels = labels
+					}
+					frame := data.NewFrame("", s.timeField, s.valueField)
+					frame.RefID = query.RefId
+					frames = append(frames, frame)
+					seriesMap[frameName] = s
+					if r.precision != nil {
+						r.precision.track(column.name, s.valueField)
+					}
+				}
+				v, err := column.value(record.Column(idx), row)
+				if err != nil {
+					return nil, err
+				}
+				s.timeField.Append(ts)
+				s.valueField.Append(v)
+			}
+		}
+	}
+	if r.precision != nil {
+		r.precision.finishFields(frames)
+	}
+	return finishTimeSeriesFrames(frames, query), nil
+}
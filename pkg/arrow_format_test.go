@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/decimal128"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func writeArrowStream(t *testing.T, schema *arrow.Schema, build func(b *array.RecordBuilder)) []byte {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	build(b)
+	record := b.NewRecordBatch()
+	defer record.Release()
+
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(schema))
+	require.NoError(t, w.Write(record))
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestArrowStreamResponseToFrames(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "t", Type: arrow.PrimitiveTypes.Uint32},
+		{Name: "host", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "level", Type: arrow.PrimitiveTypes.Int8},
+		{Name: "requests", Type: arrow.PrimitiveTypes.Uint64},
+	}, nil)
+	body := writeArrowStream(t, schema, func(b *array.RecordBuilder) {
+		b.Field(0).(*array.Uint32Builder).AppendValues([]uint32{1705312800, 1705312800, 1705312860}, nil)
+		b.Field(1).(*array.StringBuilder).AppendValues([]string{"web", "", "web"}, []bool{true, false, true})
+		b.Field(2).(*array.Int8Builder).AppendValues([]int8{1, 2, 1}, nil)
+		b.Field(3).(*array.Uint64Builder).AppendValues([]uint64{150, 7, 160}, nil)
+	})
+
+	result, meta, err := readArrowStream(bytes.NewReader(body))
+	require.NoError(t, err)
+	require.Len(t, meta, 4)
+	resp := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "t", Type: "DateTime('UTC')"},
+			{Name: "host", Type: "LowCardinality(Nullable(String))"},
+			{Name: "level", Type: "Enum8('info' = 1, 'error' = 2)"},
+			{Name: "requests", Type: "UInt64"},
+		},
+		arrow:     result,
+		closeBody: result.release,
+	}
+	defer resp.close()
+
+	frames, err := resp.toFrames(&Query{RefId: "A"}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Len(t, frames, 2)
+
+	require.Equal(t, "t", frames[0].Fields[0].Name)
+	require.Equal(t, "web, info", frames[0].Fields[1].Name)
+	require.Equal(t, "web", frames[0].Fields[1].Labels["host"])
+	require.Equal(t, 2, frames[0].Fields[0].Len())
+	require.Equal(t, time.Unix(1705312860, 0), frames[0].Fields[0].At(1))
+	require.Equal(t, float64(160), frames[0].Fields[1].At(1))
+
+	require.Equal(t, "null, error", frames[1].Fields[1].Name)
+	require.Equal(t, float64(7), frames[1].Fields[1].At(0))
+}
+
+func TestArrowStreamResponseTablePrecision(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "id", Type: arrow.PrimitiveTypes.Uint64},
+		{Name: "day", Type: arrow.PrimitiveTypes.Uint16},
+		{Name: "price", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+	}, nil)
+	body := writeArrowStream(t, schema, func(b *array.RecordBuilder) {
+		b.Field(0).(*array.Uint64Builder).AppendValues([]uint64{1, math.MaxUint64}, nil)
+		b.Field(1).(*array.Uint16Builder).AppendValues([]uint16{19737, 0}, nil)
+		b.Field(2).(*array.Decimal128Builder).Append(decimal128.FromI64(12345))
+		b.Field(2).(*array.Decimal128Builder).Append(decimal128.FromI64(-5))
+	})
+
+	result, _, err := readArrowStream(bytes.NewReader(body))
+	require.NoError(t, err)
+	resp := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "id", Type: "UInt64"},
+			{Name: "day", Type: "Date"},
+			{Name: "price", Type: "Decimal(10, 2)"},
+		},
+		arrow:     result,
+		closeBody: result.release,
+	}
+	defer resp.close()
+
+	frames, err := resp.toFrames(&Query{RefId: "A"}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+	require.Equal(t, "18446744073709551615", frames[0].Fields[0].At(1))
+	require.Equal(t, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), frames[1].Fields[0].At(0))
+	require.Equal(t, 123.45, frames[2].Fields[0].At(0))
+	require.Equal(t, -0.05, frames[2].Fields[0].At(1))
+}
+
+func TestReadArrowStreamEmptyBody(t *testing.T) {
+	result, meta, err := readArrowStream(bytes.NewReader(nil))
+	require.NoError(t, err)
+	require.Nil(t, meta)
+	require.Empty(t, result.records)
+}
+
+func TestArrowTypeSupported(t *testing.T) {
+	require.True(t, arrowTypeSupported("LowCardinality(Nullable(String))"))
+	require.True(t, arrowTypeSupported("DateTime64(3, 'UTC')"))
+	require.True(t, arrowTypeSupported("Nullable(Decimal(18, 4))"))
+	require.False(t, arrowTypeSupported("Array(String)"))
+	require.False(t, arrowTypeSupported("UUID"))
+	require.False(t, arrowTypeSupported("Map(String, UInt8)"))
+}
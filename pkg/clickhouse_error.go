@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// ClickHouseError is the exception returned by ClickHouse in the body of non 200 response, e.g.
+// Code: 60. DB::Exception: Table default.t does not exist. (UNKNOWN_TABLE) (version 24.8.4.13 (official build))
+type ClickHouseError struct {
+	// Code is the ClickHouse error code, 0 when the response isn't a ClickHouse exception, e.g. an error page of a proxy
+	Code int
+	// Name is the error code name like UNKNOWN_TABLE, older ClickHouse versions don't send it
+	Name    string
+	Message string
+	Version string
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	text       string
+}
+
+var (
+	clickHouseErrorCodeRE    = regexp.MustCompile(`(?s)^Code: (\d+)[.,]\s*(?:e\.displayText\(\) = )?(?:DB::Exception: )?(.*)$`)
+	clickHouseErrorVersionRE = regexp.MustCompile(`(?s)^(.*?)\s*\(version (.+)\)\.?$`)
+	clickHouseErrorNameRE    = regexp.MustCompile(`(?s)^(.*?)\s*\(([A-Z][A-Z0-9_]*)\)\.?$`)
+)
+
+// newClickHouseError parses the exception from the response body, the code from X-ClickHouse-Exception-Code header
+// is used when the body is cut by a proxy
+func newClickHouseError(statusCode int, header http.Header, body []byte) *ClickHouseError {
+	text := strings.TrimSpace(string(body))
+	chErr := &ClickHouseError{StatusCode: statusCode, Message: text, text: text}
+	if match := clickHouseErrorCodeRE.FindStringSubmatch(text); match != nil {
+		chErr.Code, _ = strconv.Atoi(match[1])
+		chErr.Message = match[2]
+		if match = clickHouseErrorVersionRE.FindStringSubmatch(chErr.Message); match != nil {
+			chErr.Message, chErr.Version = match[1], match[2]
+		}
+		if match = clickHouseErrorNameRE.FindStringSubmatch(chErr.Message); match != nil {
+			chErr.Message, chErr.Name = match[1], match[2]
+		}
+	}
+	if code, err := strconv.Atoi(header.Get("X-ClickHouse-Exception-Code")); err == nil {
+		chErr.Code = code
+	}
+	if chErr.text == "" {
+		chErr.text = http.StatusText(statusCode)
+	}
+	return chErr
+}
+
+// Error returns the exception text as it's sent by ClickHouse
+func (e *ClickHouseError) Error() string {
+	return e.text
+}
+
+// clickHouseErrorStatuses maps error codes which ClickHouse returns with the generic 500 status or which
+// are better reported with another status, other codes use the HTTP status of the response
+var clickHouseErrorStatuses = map[int]backend.Status{
+	// SYNTAX_ERROR, UNKNOWN_IDENTIFIER, UNKNOWN_FUNCTION, ILLEGAL_TYPE_OF_ARGUMENT, TYPE_MISMATCH, UNKNOWN_SETTING
+	62: backend.StatusBadRequest, 47: backend.StatusBadRequest, 46: backend.StatusBadRequest,
+	43: backend.StatusBadRequest, 53: backend.StatusBadRequest, 115: backend.StatusBadRequest,
+	// UNKNOWN_TABLE, UNKNOWN_DATABASE
+	60: backend.StatusNotFound, 81: backend.StatusNotFound,
+	// UNKNOWN_USER, WRONG_PASSWORD, REQUIRED_PASSWORD, AUTHENTICATION_FAILED
+	192: backend.StatusUnauthorized, 193: backend.StatusUnauthorized, 194: backend.StatusUnauthorized, 516: backend.StatusUnauthorized,
+	// READONLY, ACCESS_DENIED
+	164: backend.StatusForbidden, 497: backend.StatusForbidden,
+	// QUOTA_EXCEEDED, TOO_MANY_SIMULTANEOUS_QUERIES
+	201: backend.StatusTooManyRequests, 202: backend.StatusTooManyRequests,
+	// TIMEOUT_EXCEEDED, SOCKET_TIMEOUT, QUERY_WAS_CANCELLED
+	159: backend.StatusTimeout, 209: backend.StatusTimeout, 394: backend.StatusTimeout,
+}
+
+// Status returns the status of the data response
+func (e *ClickHouseError) Status() backend.Status {
+	if status, ok := clickHouseErrorStatuses[e.Code]; ok {
+		return status
+	}
+	if e.StatusCode >= 400 && e.StatusCode < 600 {
+		return backend.Status(e.StatusCode)
+	}
+	return backend.StatusBadGateway
+}
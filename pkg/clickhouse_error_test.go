@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClickHouseError(t *testing.T) {
+	testCases := []struct {
+		body     string
+		header   http.Header
+		expected ClickHouseError
+	}{
+		{
+			body: "Code: 60. DB::Exception: Table default.t does not exist. (UNKNOWN_TABLE) (version 24.8.4.13 (official build))\n",
+			expected: ClickHouseError{
+				Code: 60, Name: "UNKNOWN_TABLE", Message: "Table default.t does not exist.", Version: "24.8.4.13 (official build)", StatusCode: 404,
+			},
+		},
+		{
+			body:     "Code: 62, e.displayText() = DB::Exception: Syntax error: failed at position 1 (version 20.3.8.53 (official build))",
+			expected: ClickHouseError{Code: 62, Message: "Syntax error: failed at position 1", Version: "20.3.8.53 (official build)", StatusCode: 404},
+		},
+		{
+			body:     "Code: 159. DB::Exception: Timeout exceeded: elapsed 5.1 seconds, maximum: 5. (TIMEOUT_EXCEEDED)",
+			expected: ClickHouseError{Code: 159, Name: "TIMEOUT_EXCEEDED", Message: "Timeout exceeded: elapsed 5.1 seconds, maximum: 5.", StatusCode: 404},
+		},
+		{
+			body:     "<html>502 Bad Gateway</html>",
+			header:   http.Header{"X-Clickhouse-Exception-Code": []string{"210"}},
+			expected: ClickHouseError{Code: 210, Message: "<html>502 Bad Gateway</html>", StatusCode: 404},
+		},
+	}
+	for _, tc := range testCases {
+		chErr := newClickHouseError(404, tc.header, []byte(tc.body))
+		tc.expected.text = chErr.text
+		require.Equal(t, tc.expected, *chErr, tc.body)
+	}
+	require.Equal(t, "Service Unavailable", newClickHouseError(503, nil, nil).Error())
+}
+
+func TestClickHouseErrorStatus(t *testing.T) {
+	require.Equal(t, backend.StatusBadRequest, (&ClickHouseError{Code: 62, StatusCode: 500}).Status())
+	require.Equal(t, backend.StatusNotFound, (&ClickHouseError{Code: 60, StatusCode: 404}).Status())
+	require.Equal(t, backend.StatusUnauthorized, (&ClickHouseError{Code: 516, StatusCode: 403}).Status())
+	require.Equal(t, backend.StatusTimeout, (&ClickHouseError{Code: 159, StatusCode: 500}).Status())
+	require.Equal(t, backend.StatusInternal, (&ClickHouseError{Code: 1000, StatusCode: 500}).Status())
+	require.Equal(t, backend.StatusBadGateway, (&ClickHouseError{StatusCode: 502}).Status())
+}
+
+func TestErrorResponse(t *testing.T) {
+	testCases := []struct {
+		err    error
+		status backend.Status
+		source backend.ErrorSource
+	}{
+		{err: fmt.Errorf("query failed: %w", &ClickHouseError{Code: 81, StatusCode: 404}), status: backend.StatusNotFound, source: backend.ErrorSourceDownstream},
+		{err: context.DeadlineExceeded, status: backend.StatusTimeout, source: backend.ErrorSourceDownstream},
+		{err: fmt.Errorf("Get \"http://clickhouse:8123\": %w", context.Canceled), status: statusClientClosedRequest, source: backend.ErrorSourceDownstream},
+		{err: backend.DownstreamError(errors.New("invalid macro")), status: backend.StatusBadRequest, source: backend.ErrorSourceDownstream},
+		{err: errors.New("unable to convert value"), status: backend.StatusInternal, source: backend.ErrorSourcePlugin},
+	}
+	for _, tc := range testCases {
+		resp := errorResponse(tc.err)
+		require.Equal(t, tc.err, resp.Error)
+		require.Equal(t, tc.status, resp.Status, tc.err.Error())
+		require.Equal(t, tc.source, resp.ErrorSource, tc.err.Error())
+	}
+}
+
+func TestClientReturnsClickHouseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-ClickHouse-Exception-Code", "60")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("Code: 60. DB::Exception: Table default.t does not exist. (UNKNOWN_TABLE) (version 24.8.4.13 (official build))\n"))
+	}))
+	defer server.Close()
+
+	client := &ClickHouseClient{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+	}}
+	_, err := client.Query(context.Background(), "SELECT * FROM t FORMAT JSON", nil, "")
+	var chErr *ClickHouseError
+	require.ErrorAs(t, err, &chErr)
+	require.Equal(t, 60, chErr.Code)
+	require.Equal(t, "UNKNOWN_TABLE", chErr.Name)
+	require.Equal(t, "24.8.4.13 (official build)", chErr.Version)
+	require.Equal(t, "Code: 60. DB::Exception: Table default.t does not exist. (UNKNOWN_TABLE) (version 24.8.4.13 (official build))", err.Error())
+}
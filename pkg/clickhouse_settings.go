@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// ClickHouseSettings are ClickHouse settings like max_execution_time or max_threads which are sent
+// as URL parameters of each HTTP request, see https://clickhouse.com/docs/en/interfaces/http#settings
+type ClickHouseSettings map[string]string
+
+var clickHouseSettingNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedURLParameters are parameters of the HTTP interface which aren't settings,
+// they're set by the client itself and can't be overridden
+var reservedURLParameters = []string{
+	"query", "database", "user", "password", "quota_key", "query_id", "session_id", "session_timeout", "session_check",
+	"default_format", "enable_http_compression", "add_http_cors_header",
+}
+
+// UnmarshalJSON accepts numbers and booleans as values, {"max_threads": 4, "use_query_cache": true}
+func (s *ClickHouseSettings) UnmarshalJSON(b []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*s = make(ClickHouseSettings, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			(*s)[name] = v
+		case float64:
+			(*s)[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			if v {
+				(*s)[name] = "1"
+			} else {
+				(*s)[name] = "0"
+			}
+		case nil:
+			// null removes the setting inherited from the datasource
+			(*s)[name] = ""
+		default:
+			return fmt.Errorf("unsupported value %v of ClickHouse setting %s", value, name)
+		}
+	}
+	return nil
+}
+
+// validate checks that names could be passed as URL parameters
+func (s ClickHouseSettings) validate() error {
+	for name := range s {
+		if !clickHouseSettingNameRE.MatchString(name) {
+			return fmt.Errorf("invalid ClickHouse setting name %q", name)
+		}
+		if slices.Contains(reservedURLParameters, name) {
+			return fmt.Errorf("%s is not a ClickHouse setting and can't be set", name)
+		}
+	}
+	return nil
+}
+
+// mergeClickHouseSettings applies query settings over datasource settings.
+// Only settings from the allow-list could be set by queries, so viewers can't raise limits pinned by admins.
+func mergeClickHouseSettings(datasourceSettings, querySettings ClickHouseSettings, allowed []string) (ClickHouseSettings, error) {
+	if err := querySettings.validate(); err != nil {
+		return nil, err
+	}
+	merged := make(ClickHouseSettings, len(datasourceSettings)+len(querySettings))
+	for name, value := range datasourceSettings {
+		merged[name] = value
+	}
+	for name, value := range querySettings {
+		if !slices.Contains(allowed, name) {
+			return nil, fmt.Errorf("ClickHouse setting %s is not allowed in queries, add it to allowedQuerySettings of the datasource", name)
+		}
+		if value == "" {
+			delete(merged, name)
+			continue
+		}
+		merged[name] = value
+	}
+	return merged, nil
+}
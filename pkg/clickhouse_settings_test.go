@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickHouseSettingsUnmarshalJSON(t *testing.T) {
+	var q Query
+	require.NoError(t, json.Unmarshal([]byte(`{"refId":"A","clickhouseSettings":{"max_threads":4,"use_query_cache":true,"result_overflow_mode":"break","readonly":null}}`), &q))
+	require.Equal(t, ClickHouseSettings{"max_threads": "4", "use_query_cache": "1", "result_overflow_mode": "break", "readonly": ""}, q.ClickHouseSettings)
+
+	require.Error(t, json.Unmarshal([]byte(`{"clickhouseSettings":{"max_threads":[1]}}`), &q))
+}
+
+func TestMergeClickHouseSettings(t *testing.T) {
+	datasourceSettings := ClickHouseSettings{"max_execution_time": "60", "readonly": "1"}
+	allowed := []string{"max_threads", "use_query_cache", "readonly"}
+
+	merged, err := mergeClickHouseSettings(datasourceSettings, ClickHouseSettings{"max_threads": "2", "readonly": ""}, allowed)
+	require.NoError(t, err)
+	require.Equal(t, ClickHouseSettings{"max_execution_time": "60", "max_threads": "2"}, merged)
+	// datasource settings are not changed
+	require.Equal(t, "1", datasourceSettings["readonly"])
+
+	_, err = mergeClickHouseSettings(datasourceSettings, ClickHouseSettings{"max_execution_time": "3600"}, allowed)
+	require.ErrorContains(t, err, "max_execution_time is not allowed")
+
+	_, err = mergeClickHouseSettings(datasourceSettings, ClickHouseSettings{"query": "DROP TABLE t"}, []string{"query"})
+	require.Error(t, err)
+
+	_, err = mergeClickHouseSettings(nil, ClickHouseSettings{"max_threads&user": "1"}, allowed)
+	require.Error(t, err)
+}
+
+func TestNewDatasourceSettingsValidatesClickHouseSettings(t *testing.T) {
+	_, err := NewDatasourceSettings(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"clickhouseSettings":{"user":"default"}}`),
+	})
+	require.Error(t, err)
+}
+
+func TestClientSendsClickHouseSettings(t *testing.T) {
+	var params url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = r.URL.Query()
+		_, _ = w.Write([]byte(`{"meta":[{"name":"1","type":"UInt8"}],"data":[{"1":1}]}`))
+	}))
+	defer server.Close()
+
+	client := &ClickHouseClient{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+	}}
+	resp, err := client.Query(context.Background(), DefaultQuery, ClickHouseSettings{"max_execution_time": "10", "max_threads": ""}, "")
+	require.NoError(t, err)
+	resp.close()
+
+	require.Equal(t, DefaultQuery, params.Get("query"))
+	require.Equal(t, "10", params.Get("max_execution_time"))
+	require.False(t, params.Has("max_threads"))
+}
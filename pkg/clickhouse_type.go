@@ -0,0 +1,221 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// clickHouseType is the parsed signature of a column type returned by ClickHouse in response meta,
+// e.g. "LowCardinality(Nullable(String))", "DateTime64(9, 'Europe/Moscow')" or "Map(String, Array(UInt64))".
+// Nullable and LowCardinality wrappers become flags, SimpleAggregateFunction is replaced by its argument type.
+type clickHouseType struct {
+	// name is the type name without arguments, "DateTime64" for "DateTime64(3, 'UTC')"
+	name string
+	// params are literal arguments: precision, scale, length, timezone, enum values
+	params []string
+	// elements are nested types of Array, Map, Tuple, Nested and Variant
+	elements []*clickHouseType
+	// elementNames are names of Tuple and Nested elements, empty strings for unnamed tuple elements
+	elementNames []string
+
+	nullable       bool
+	lowCardinality bool
+
+	enumNames map[int64]string
+}
+
+// clickHouseFieldKind describes which Grafana field stores values of the ClickHouse type
+type clickHouseFieldKind int
+
+const (
+	// fieldKindString is String, FixedString, UUID, IPv4, IPv6, Enum8, Enum16
+	fieldKindString clickHouseFieldKind = iota
+	// fieldKindFloat is [U]Int8-32, Float32, Float64, Interval*
+	fieldKindFloat
+	// fieldKindWideNumber is [U]Int64, [U]Int128, [U]Int256 and Decimal*, float64 or string
+	// according to the precision policy, see analyzeColumnPrecisionNeeds
+	fieldKindWideNumber
+	// fieldKindTimestamp is UInt64 or Int64 named "t", the plugin uses it for milliseconds timestamps
+	fieldKindTimestamp
+	// fieldKindTime is Date, Date32, DateTime, DateTime64
+	fieldKindTime
+	// fieldKindBool is Bool
+	fieldKindBool
+	// fieldKindNothing is Nothing, the type of NULL literal, always empty
+	fieldKindNothing
+	// fieldKindJSON is everything else: Array, Tuple, Map, Nested, Variant, Dynamic, JSON, Object, geo types,
+	// AggregateFunction and unknown types, values are rendered as JSON strings
+	fieldKindJSON
+)
+
+var clickHouseTypesCache sync.Map
+
+var typeElementNameRE = regexp.MustCompile("^(`(?:[^`]|``)+`|[A-Za-z_][A-Za-z0-9_.]*)\\s+(.+)$")
+
+// parseClickHouseType parses the type signature, results are cached because the same few types
+// are parsed for every value of the response.
+func parseClickHouseType(chType string) *clickHouseType {
+	if cached, ok := clickHouseTypesCache.Load(chType); ok {
+		return cached.(*clickHouseType)
+	}
+	t := parseClickHouseTypeSignature(chType)
+	clickHouseTypesCache.Store(chType, t)
+	return t
+}
+
+func parseClickHouseTypeSignature(chType string) *clickHouseType {
+	name, args := unwrapType(chType)
+	switch name {
+	case "Nullable":
+		t := parseClickHouseTypeSignature(args)
+		t.nullable = true
+		return t
+	case "LowCardinality":
+		t := parseClickHouseTypeSignature(args)
+		t.lowCardinality = true
+		return t
+	case "SimpleAggregateFunction":
+		// SimpleAggregateFunction(any, UInt64) stores plain UInt64 values
+		if items := splitTypeArgs(args); len(items) == 2 {
+			return parseClickHouseTypeSignature(items[1])
+		}
+	}
+
+	t := &clickHouseType{name: name}
+	switch name {
+	case "Array", "Map", "Variant":
+		for _, item := range splitTypeArgs(args) {
+			t.elements = append(t.elements, parseClickHouseTypeSignature(item))
+		}
+	case "Tuple", "Nested":
+		for _, item := range splitTypeArgs(args) {
+			elementName, elementType := splitTypeElementName(item)
+			t.elementNames = append(t.elementNames, elementName)
+			t.elements = append(t.elements, parseClickHouseTypeSignature(elementType))
+		}
+	case "Enum8", "Enum16":
+		t.params = splitTypeArgs(args)
+		// unparsable values keep the enum rendered as it comes
+		t.enumNames, _ = parseEnumValues(name, args)
+	default:
+		t.params = splitTypeArgs(args)
+	}
+	return t
+}
+
+// splitTypeElementName splits "name Type" element of named Tuple or Nested, the name is empty for unnamed elements
+func splitTypeElementName(element string) (string, string) {
+	matches := typeElementNameRE.FindStringSubmatch(element)
+	if matches == nil {
+		return "", element
+	}
+	name := matches[1]
+	if strings.HasPrefix(name, "`") {
+		name = strings.ReplaceAll(name[1:len(name)-1], "``", "`")
+	}
+	return name, matches[2]
+}
+
+// fieldKind returns the kind of Grafana field for the type, the field name matters only for "t" timestamps
+func (t *clickHouseType) fieldKind(fieldName string) clickHouseFieldKind {
+	switch t.name {
+	case "String", "FixedString", "UUID", "IPv4", "IPv6", "Enum8", "Enum16":
+		return fieldKindString
+	case "UInt8", "UInt16", "UInt32", "Int8", "Int16", "Int32", "Float32", "Float64",
+		"IntervalNanosecond", "IntervalMicrosecond", "IntervalMillisecond", "IntervalSecond", "IntervalMinute",
+		"IntervalHour", "IntervalDay", "IntervalWeek", "IntervalMonth", "IntervalQuarter", "IntervalYear":
+		return fieldKindFloat
+	case "UInt64", "Int64":
+		// Plugin specific corner case, "t" is the field name used for timestamp in milliseconds
+		if fieldName == "t" {
+			return fieldKindTimestamp
+		}
+		return fieldKindWideNumber
+	case "UInt128", "UInt256", "Int128", "Int256",
+		"Decimal", "Decimal32", "Decimal64", "Decimal128", "Decimal256":
+		return fieldKindWideNumber
+	case "Date", "Date32", "DateTime", "DateTime32", "DateTime64":
+		return fieldKindTime
+	case "Bool":
+		return fieldKindBool
+	case "Nothing":
+		return fieldKindNothing
+	default:
+		return fieldKindJSON
+	}
+}
+
+// isNullable reports whether values of the type may be NULL, Variant and Dynamic can hold NULL without Nullable wrapper
+func (t *clickHouseType) isNullable() bool {
+	switch t.name {
+	case "Nothing", "Variant", "Dynamic":
+		return true
+	}
+	return t.nullable
+}
+
+// isDynamic reports whether values of the type may be plain strings which shouldn't be quoted once more
+func (t *clickHouseType) isDynamic() bool {
+	switch t.name {
+	case "Variant", "Dynamic", "JSON", "Object":
+		return true
+	}
+	return false
+}
+
+// precision returns the number of fractional second digits of DateTime64, 3 is the ClickHouse default
+func (t *clickHouseType) precision() int {
+	if t.name != "DateTime64" {
+		return 0
+	}
+	if len(t.params) > 0 {
+		if precision, err := strconv.Atoi(t.params[0]); err == nil && precision >= 0 && precision <= 9 {
+			return precision
+		}
+	}
+	return 3
+}
+
+// timeZone returns the timezone argument of DateTime and DateTime64 or of the first nested one which has it,
+// empty string means the server timezone
+func (t *clickHouseType) timeZone() string {
+	switch t.name {
+	case "DateTime", "DateTime32":
+		if len(t.params) > 0 {
+			return unquoteTypeParam(t.params[0])
+		}
+	case "DateTime64":
+		if len(t.params) > 1 {
+			return unquoteTypeParam(t.params[1])
+		}
+	}
+	for _, element := range t.elements {
+		if tz := element.timeZone(); tz != "" {
+			return tz
+		}
+	}
+	return ""
+}
+
+func unquoteTypeParam(param string) string {
+	param = strings.TrimSpace(param)
+	if len(param) >= 2 && param[0] == '\'' && param[len(param)-1] == '\'' {
+		param = strings.ReplaceAll(param[1:len(param)-1], "\\'", "'")
+	}
+	return strings.TrimSpace(param)
+}
+
+// timeLayout returns the layout of Date and DateTime values in ClickHouse text formats
+func (t *clickHouseType) timeLayout() string {
+	switch t.name {
+	case "Date", "Date32":
+		return dateLayout
+	case "DateTime64":
+		if precision := t.precision(); precision > 0 {
+			return dateTimeLayout + "." + strings.Repeat("0", precision)
+		}
+	}
+	return dateTimeLayout
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClickHouseType(t *testing.T) {
+	testCases := []struct {
+		chType       string
+		name         string
+		params       []string
+		elements     []string
+		elementNames []string
+		nullable     bool
+	}{
+		{chType: "String", name: "String"},
+		{chType: "LowCardinality(Nullable(String))", name: "String", nullable: true},
+		{chType: "Nullable(DateTime64(9, 'Asia/Kolkata'))", name: "DateTime64", params: []string{"9", "'Asia/Kolkata'"}, nullable: true},
+		{chType: "Decimal(38, 10)", name: "Decimal", params: []string{"38", "10"}},
+		{chType: "Enum8('a, b' = 1, 'c' = 2)", name: "Enum8", params: []string{"'a, b' = 1", "'c' = 2"}},
+		{chType: "SimpleAggregateFunction(sum, Nullable(UInt64))", name: "UInt64", nullable: true},
+		{chType: "Array(Nullable(String))", name: "Array", elements: []string{"String"}},
+		{chType: "Map(LowCardinality(String), Array(UInt64))", name: "Map", elements: []string{"String", "Array"}},
+		{chType: "Tuple(String, DateTime64(3, 'UTC'))", name: "Tuple", elements: []string{"String", "DateTime64"}, elementNames: []string{"", ""}},
+		{chType: "Tuple(name String, `my value` Nullable(Float64))", name: "Tuple", elements: []string{"String", "Float64"}, elementNames: []string{"name", "my value"}},
+		{chType: "Nested(key String, value UInt32)", name: "Nested", elements: []string{"String", "UInt32"}, elementNames: []string{"key", "value"}},
+		{chType: "Variant(String, UInt64, Array(String))", name: "Variant", elements: []string{"String", "UInt64", "Array"}},
+		{chType: "JSON(max_dynamic_paths=10)", name: "JSON", params: []string{"max_dynamic_paths=10"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.chType, func(t *testing.T) {
+			parsed := parseClickHouseType(tc.chType)
+			require.Equal(t, tc.name, parsed.name)
+			require.Equal(t, tc.params, parsed.params)
+			require.Equal(t, tc.nullable, parsed.nullable)
+			require.Equal(t, tc.elementNames, parsed.elementNames)
+			var elements []string
+			for _, element := range parsed.elements {
+				elements = append(elements, element.name)
+			}
+			require.Equal(t, tc.elements, elements)
+		})
+	}
+}
+
+func TestClickHouseTypeFields(t *testing.T) {
+	tz := time.UTC
+	ts := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		chType    string
+		fieldName string
+		fieldType data.FieldType
+		value     interface{}
+		expected  interface{}
+	}{
+		// strings
+		{chType: "String", fieldType: data.FieldTypeString, value: "a", expected: "a"},
+		{chType: "LowCardinality(Nullable(String))", fieldType: data.FieldTypeNullableString, value: nil, expected: nil},
+		{chType: "FixedString(3)", fieldType: data.FieldTypeString, value: "abc", expected: "abc"},
+		{chType: "UUID", fieldType: data.FieldTypeString, value: "61f0c404-5cb3-11e7-907b-a6006ad3dba0", expected: "61f0c404-5cb3-11e7-907b-a6006ad3dba0"},
+		{chType: "IPv4", fieldType: data.FieldTypeString, value: "127.0.0.1", expected: "127.0.0.1"},
+		{chType: "IPv4", fieldType: data.FieldTypeString, value: json.Number("3232235777"), expected: "192.168.1.1"},
+		{chType: "IPv6", fieldType: data.FieldTypeString, value: "::1", expected: "::1"},
+		{chType: "Enum8('a' = 1, 'b' = 2)", fieldType: data.FieldTypeString, value: "b", expected: "b"},
+		{chType: "Enum16('a' = -1, 'b' = 1000)", fieldType: data.FieldTypeString, value: float64(-1), expected: "a"},
+		// numbers
+		{chType: "UInt8", fieldType: data.FieldTypeFloat64, value: float64(255), expected: float64(255)},
+		{chType: "Nullable(Int32)", fieldType: data.FieldTypeNullableFloat64, value: nil, expected: nil},
+		{chType: "Float32", fieldType: data.FieldTypeFloat64, value: json.Number("1.5"), expected: 1.5},
+		{chType: "Decimal(10, 2)", fieldType: data.FieldTypeString, value: "12.34", expected: "12.34"},
+		{chType: "Decimal256(20)", fieldType: data.FieldTypeString, value: json.Number("1.25"), expected: "1.25"},
+		{chType: "IntervalSecond", fieldType: data.FieldTypeFloat64, value: "30", expected: float64(30)},
+		{chType: "UInt64", fieldType: data.FieldTypeString, value: json.Number("18446744073709551615"), expected: "18446744073709551615"},
+		{chType: "Int64", fieldType: data.FieldTypeString, value: "-5", expected: "-5"},
+		{chType: "Int128", fieldType: data.FieldTypeString, value: "-170141183460469231731687303715884105728", expected: "-170141183460469231731687303715884105728"},
+		{chType: "UInt256", fieldType: data.FieldTypeString, value: json.Number("1"), expected: "1"},
+		{chType: "SimpleAggregateFunction(max, UInt32)", fieldType: data.FieldTypeFloat64, value: float64(7), expected: float64(7)},
+		// times
+		{chType: "UInt64", fieldName: "t", fieldType: data.FieldTypeTime, value: "1705312800000", expected: ts.Local()},
+		{chType: "Nullable(Int64)", fieldName: "t", fieldType: data.FieldTypeNullableTime, value: nil, expected: nil},
+		{chType: "Date", fieldType: data.FieldTypeTime, value: "2024-01-15", expected: time.Date(2024, 1, 15, 0, 0, 0, 0, tz)},
+		{chType: "Date32", fieldType: data.FieldTypeTime, value: "1900-01-01", expected: time.Date(1900, 1, 1, 0, 0, 0, 0, tz)},
+		{chType: "DateTime('UTC')", fieldType: data.FieldTypeTime, value: "2024-01-15 10:00:00", expected: ts},
+		{chType: "DateTime64(1)", fieldType: data.FieldTypeTime, value: "2024-01-15 10:00:00.1", expected: ts.Add(100 * time.Millisecond)},
+		{chType: "DateTime64(3, 'Etc/GMT+3')", fieldType: data.FieldTypeTime, value: "2024-01-15 10:00:00.123", expected: ts.Add(123 * time.Millisecond)},
+		{chType: "DateTime64(9)", fieldType: data.FieldTypeTime, value: "2024-01-15 10:00:00.123456789", expected: ts.Add(123456789)},
+		// bool and nothing
+		{chType: "Bool", fieldType: data.FieldTypeBool, value: true, expected: true},
+		{chType: "Nullable(Bool)", fieldType: data.FieldTypeNullableBool, value: "false", expected: false},
+		{chType: "Nullable(Nothing)", fieldType: data.FieldTypeNullableString, value: nil, expected: nil},
+		// compound
+		{chType: "Array(Nullable(String))", fieldType: data.FieldTypeString, value: []interface{}{"a", nil}, expected: `["a",null]`},
+		{chType: "Map(String, UInt64)", fieldType: data.FieldTypeString, value: map[string]interface{}{"a": "1"}, expected: `{"a":"1"}`},
+		{chType: "Tuple(a String, b Float64)", fieldType: data.FieldTypeString, value: map[string]interface{}{"a": "x", "b": 1.5}, expected: `{"a":"x","b":1.5}`},
+		{chType: "Nested(a String)", fieldType: data.FieldTypeString, value: []interface{}{map[string]interface{}{"a": "x"}}, expected: `[{"a":"x"}]`},
+		{chType: "Point", fieldType: data.FieldTypeString, value: []interface{}{1.5, 2.5}, expected: `[1.5,2.5]`},
+		{chType: "Ring", fieldType: data.FieldTypeString, value: []interface{}{[]interface{}{1.5, 2.5}}, expected: `[[1.5,2.5]]`},
+		{chType: "Variant(String, UInt64)", fieldType: data.FieldTypeNullableString, value: "text", expected: "text"},
+		{chType: "Dynamic", fieldType: data.FieldTypeNullableString, value: nil, expected: nil},
+		{chType: "JSON", fieldType: data.FieldTypeString, value: map[string]interface{}{"a": json.Number("1")}, expected: `{"a":1}`},
+		{chType: "Object('json')", fieldType: data.FieldTypeString, value: "{}", expected: "{}"},
+		{chType: "AggregateFunction(uniq, String)", fieldType: data.FieldTypeString, value: "\x01", expected: `"\u0001"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.chType+" "+tc.fieldName, func(t *testing.T) {
+			fieldName := tc.fieldName
+			if fieldName == "" {
+				fieldName = "value"
+			}
+			field := NewDataFieldByTypeOptimized(fieldName, tc.chType, true)
+			require.Equal(t, tc.fieldType, field.Type())
+
+			value := ParseValueOptimized(fieldName, tc.chType, tz, tc.value, false, true)
+			require.NotPanics(t, func() { field.Append(value) })
+			actual, ok := field.ConcreteAt(0)
+			if tc.expected == nil {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			if expectedTime, isTime := tc.expected.(time.Time); isTime {
+				require.True(t, expectedTime.Equal(actual.(time.Time)), "%v != %v", expectedTime, actual)
+				return
+			}
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestClickHouseTypeWideNumberAsFloat64(t *testing.T) {
+	testCases := []struct {
+		chType   string
+		value    interface{}
+		expected float64
+	}{
+		{chType: "Nullable(UInt64)", value: json.Number("42"), expected: 42},
+		{chType: "Int64", value: "-42", expected: -42},
+		{chType: "UInt128", value: json.Number("340282366920938463463374607431768211455"), expected: 340282366920938463463374607431768211455},
+		{chType: "Decimal(10, 2)", value: "12.34", expected: 12.34},
+		{chType: "Decimal256(20)", value: json.Number("1.25"), expected: 1.25},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.chType, func(t *testing.T) {
+			field := NewDataFieldByTypeOptimized("value", tc.chType, false)
+			field.Append(ParseValueOptimized("value", tc.chType, time.UTC, tc.value, false, false))
+			actual, ok := field.ConcreteAt(0)
+			require.True(t, ok)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestDateTime64Precisions(t *testing.T) {
+	base := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	fraction := "123456789"
+	for precision := 0; precision <= 9; precision++ {
+		chType := fmt.Sprintf("DateTime64(%d, 'UTC')", precision)
+		value := "2024-01-15 10:00:00"
+		if precision > 0 {
+			value += "." + fraction[:precision]
+		}
+		expected := base.Add(time.Duration(123456789 - 123456789%int(math.Pow10(9-precision))))
+		t.Run(chType, func(t *testing.T) {
+			actual := ParseValue("time", chType, time.UTC, value, false)
+			require.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestDateTimeValueFormats(t *testing.T) {
+	testCases := []struct {
+		chType   string
+		value    interface{}
+		expected time.Time
+	}{
+		// fractions which don't match the precision are kept as is
+		{chType: "DateTime64(3)", value: "2024-01-15 10:00:00.123456", expected: time.Date(2024, 1, 15, 10, 0, 0, 123456000, time.UTC)},
+		{chType: "DateTime64(9)", value: "2024-01-15 10:00:00.5", expected: time.Date(2024, 1, 15, 10, 0, 0, 500000000, time.UTC)},
+		// date_time_output_format='unix_timestamp'
+		{chType: "DateTime", value: json.Number("1705312800"), expected: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)},
+		{chType: "DateTime64(9)", value: "1705312800.000000001", expected: time.Date(2024, 1, 15, 10, 0, 0, 1, time.UTC)},
+		{chType: "DateTime64(2)", value: "-1.25", expected: time.Date(1969, 12, 31, 23, 59, 58, 750000000, time.UTC)},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %v", tc.chType, tc.value), func(t *testing.T) {
+			actual := ParseValue("time", tc.chType, time.UTC, tc.value, false)
+			require.True(t, tc.expected.Equal(actual.(time.Time)), "%v != %v", tc.expected, actual)
+		})
+	}
+}
+
+func TestExtractTimeZoneNameFromFieldType(t *testing.T) {
+	testCases := map[string]string{
+		"DateTime":                                   "",
+		"DateTime('Europe/Moscow')":                  "Europe/Moscow",
+		"DateTime64(3)":                              "",
+		"DateTime64(3, 'Etc/GMT+3')":                 "Etc/GMT+3",
+		"Nullable(DateTime64(9, 'Asia/Kolkata'))":    "Asia/Kolkata",
+		"LowCardinality(Nullable(DateTime('UTC')))":  "UTC",
+		"Array(Tuple(String, DateTime64(6, 'UTC')))": "UTC",
+		"Map(String, String)":                        "",
+	}
+	for fieldType, expected := range testCases {
+		require.Equal(t, expected, extractTimeZoneNameFromFieldType(fieldType), fieldType)
+	}
+}
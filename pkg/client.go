@@ -3,13 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
 	"slices"
+	"strings"
 	"time"
 
 	"compress/flate"
@@ -24,18 +28,164 @@ const TimeZoneFieldName = "timezone()"
 
 var TimeZoneQuery = fmt.Sprintf("SELECT %s FORMAT JSON;", TimeZoneFieldName)
 
+// Response formats which could be requested from ClickHouse, see DatasourceSettings.ResponseFormat
+const (
+	ResponseFormatJSON               = "JSON"
+	ResponseFormatNative             = "Native"
+	ResponseFormatJSONCompactEachRow = "JSONCompactEachRowWithNamesAndTypes"
+	ResponseFormatArrowStream        = "ArrowStream"
+)
+
+// errArrowUnsupportedType is returned by describe when some column can't be read from ArrowStream,
+// only such queries fall back to JSON format, other errors of DESCRIBE are errors of the query itself
+var errArrowUnsupportedType = errors.New("type is not supported by ArrowStream format")
+
+// formatJSONSuffixRE matches FORMAT JSON which Query.ApplyTimeRangeToQuery appends to each query
+var formatJSONSuffixRE = regexp.MustCompile(`(?i)\bFORMAT\s+JSON\s*;?\s*$`)
+
 type ClickHouseClient struct {
 	settings *DatasourceSettings
 }
 
-func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Response, error) {
+// responseFormat returns the format which will be requested for the query and the query itself.
+// Only queries which end with FORMAT JSON are rewritten, any other explicit FORMAT is left as is.
+func (client *ClickHouseClient) responseFormat(query string) (string, string) {
+	format := client.settings.ResponseFormat
+	if (format == ResponseFormatNative || format == ResponseFormatJSONCompactEachRow || format == ResponseFormatArrowStream) && formatJSONSuffixRE.MatchString(query) {
+		return format, formatJSONSuffixRE.ReplaceAllString(query, "FORMAT "+format)
+	}
+	return ResponseFormatJSON, query
+}
+
+// Query sends the query with ClickHouse settings, see mergeClickHouseSettings.
+// When queryID is set, it's sent as query_id and the query is killed on the server when ctx is cancelled, see newQueryID
+func (client *ClickHouseClient) Query(ctx context.Context, query string, settings ClickHouseSettings, queryID string) (*Response, error) {
+	format, formattedQuery := client.responseFormat(query)
+	if format != ResponseFormatArrowStream {
+		return client.query(ctx, formattedQuery, format, settings, queryID)
+	}
+
+	// ArrowStream schema doesn't keep ClickHouse types, so they're taken from DESCRIBE of the same query,
+	// it has its own query_id, so cancellation kills the running request and query_log keeps them apart
+	describeQueryID := ""
+	if queryID != "" {
+		describeQueryID = queryID + "-describe"
+	}
+	meta, err := client.describe(ctx, query, settings, describeQueryID)
+	if errors.Is(err, errArrowUnsupportedType) {
+		backend.Logger.Debug(fmt.Sprintf("clickhouse client falls back to JSON format: %v", err))
+		return client.query(ctx, query, ResponseFormatJSON, settings, queryID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.query(ctx, formattedQuery, format, settings, queryID)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Meta != nil {
+		if len(resp.Meta) != len(meta) {
+			resp.close()
+			return nil, fmt.Errorf("ArrowStream response contains %d columns, DESCRIBE returned %d", len(resp.Meta), len(meta))
+		}
+		for i := range meta {
+			if resp.Meta[i].Name != meta[i].Name {
+				resp.close()
+				return nil, fmt.Errorf("ArrowStream column %s doesn't match DESCRIBE column %s", resp.Meta[i].Name, meta[i].Name)
+			}
+		}
+	}
+	resp.Meta = meta
+	return resp, nil
+}
+
+// describe returns names and types of the query columns, it fails when some type can't be read from ArrowStream
+func (client *ClickHouseClient) describe(ctx context.Context, query string, settings ClickHouseSettings, queryID string) ([]*FieldMeta, error) {
+	subQuery := strings.TrimSpace(formatJSONSuffixRE.ReplaceAllString(query, ""))
+	res, err := client.query(ctx, "DESCRIBE (\n"+subQuery+"\n) FORMAT JSON", ResponseFormatJSON, settings, queryID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.close()
+
+	var meta []*FieldMeta
+	err = res.forEachRow(func(row map[string]interface{}) error {
+		name, _ := row["name"].(string)
+		chType, _ := row["type"].(string)
+		if !arrowTypeSupported(chType) {
+			return fmt.Errorf("column %s has type %s: %w", name, chType, errArrowUnsupportedType)
+		}
+		meta = append(meta, &FieldMeta{Name: name, Type: chType})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// endpointPool returns endpoints of the datasource, settings which aren't created by NewDatasourceSettings have only Instance.URL
+func (client *ClickHouseClient) endpointPool() *endpointPool {
+	if client.settings.endpoints != nil {
+		return client.settings.endpoints
+	}
+	return &endpointPool{endpoints: []*endpoint{{url: client.settings.Instance.URL}}, strategy: LoadBalancingFirstHealthy}
+}
+
+// query sends the query which is already formatted for the requested response format.
+// Idempotent queries are sent again to the next endpoint when the endpoint is down or returns one of RetryErrorCodes.
+func (client *ClickHouseClient) query(ctx context.Context, query string, format string, settings ClickHouseSettings, queryID string) (*Response, error) {
+	pool := client.endpointPool()
+	endpoints := pool.order()
+	attempts := 1
+	if isIdempotentQuery(query) {
+		attempts = pool.attempts(client.settings.MaxRetries)
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		e := endpoints[attempt%len(endpoints)]
+		if attempt >= len(endpoints) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(retryBackoff):
+			}
+		}
+		// ClickHouse refuses the query_id while the previous attempt is still running on the same server
+		attemptQueryID := queryID
+		if attempt > 0 && queryID != "" {
+			attemptQueryID = fmt.Sprintf("%s-%d", queryID, attempt)
+		}
+		var resp *Response
+		resp, err = client.queryEndpoint(ctx, e, query, format, settings, attemptQueryID)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if isEndpointDown(err) {
+			e.markFailed()
+		}
+		if !isRetryableError(err, client.settings.RetryErrorCodes) {
+			return nil, err
+		}
+		if attempt+1 < attempts {
+			backend.Logger.Warn(fmt.Sprintf("clickhouse query failed on %s and will be retried: %v", e.url, err))
+		}
+	}
+	return nil, err
+}
+
+// queryEndpoint sends the query to one endpoint
+func (client *ClickHouseClient) queryEndpoint(ctx context.Context, e *endpoint, query string, format string, settings ClickHouseSettings, queryID string) (*Response, error) {
 
 	onErr := func(err error) (*Response, error) {
 		backend.Logger.Error(fmt.Sprintf("clickhouse client query error: %v", err))
 		return nil, err
 	}
 
-	datasourceUrl, err := url.Parse(client.settings.Instance.URL)
+	datasourceUrl, err := url.Parse(e.url)
 	if err != nil {
 		return onErr(fmt.Errorf("unable to parse clickhouse datasource url: %w", err))
 	}
@@ -61,6 +211,20 @@ func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Respo
 		params.Add("enable_http_compression", "1")
 		req.URL.RawQuery = params.Encode()
 	}
+	if len(settings) > 0 {
+		params := req.URL.Query()
+		for name, value := range settings {
+			if value != "" {
+				params.Set(name, value)
+			}
+		}
+		req.URL.RawQuery = params.Encode()
+	}
+	if queryID != "" {
+		params := req.URL.Query()
+		params.Set("query_id", queryID)
+		req.URL.RawQuery = params.Encode()
+	}
 	if client.settings.Instance.BasicAuthEnabled {
 		password := client.settings.Instance.DecryptedSecureJSONData["basicAuthPassword"]
 		req.SetBasicAuth(client.settings.Instance.BasicAuthUser, password)
@@ -87,15 +251,26 @@ func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Respo
 	if client.settings.HTTPClient == nil {
 		return onErr(errors.New("http client is not initialized"))
 	}
+	// Go drops the connection when ctx is cancelled, but ClickHouse keeps running the query until it's killed,
+	// the watch lasts until the response body is closed, because streamed responses are read after query returns
+	stopKillWatch := func() bool { return false }
+	if queryID != "" {
+		stopKillWatch = context.AfterFunc(ctx, func() { client.killQuery(e, queryID) })
+	}
+	start := time.Now()
 	resp, err := client.settings.HTTPClient.Do(req)
 	if err != nil {
-		return onErr(err)
+		stopKillWatch()
+		return onErr(&endpointConnectionError{err: err})
 	}
-	defer func() {
+	e.markResponded(time.Since(start))
+	recordQuerySummary(ctx, resp.Header)
+	closeBody := func() {
+		stopKillWatch()
 		if closeErr := resp.Body.Close(); closeErr != nil {
 			backend.Logger.Warn(fmt.Sprintf("unable to close response body: %v", closeErr))
 		}
-	}()
+	}
 
 	var reader io.Reader
 	closeEncodedReader := func() {}
@@ -105,6 +280,7 @@ func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Respo
 		reader = gzipReader
 		err = gzipErr
 		if err != nil {
+			closeBody()
 			return onErr(fmt.Errorf("error creating GZIP reader: %v", err))
 		}
 		closeEncodedReader = func() {
@@ -125,6 +301,7 @@ func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Respo
 	case "zstd":
 		decoder, zstdErr := zstd.NewReader(resp.Body)
 		if zstdErr != nil {
+			closeBody()
 			return onErr(fmt.Errorf("error creating ZSTD reader: %v", zstdErr))
 		}
 		reader = decoder.IOReadCloser()
@@ -132,7 +309,32 @@ func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Respo
 	default:
 		reader = resp.Body
 	}
-	defer closeEncodedReader()
+	closeReaders := func() {
+		closeEncodedReader()
+		closeBody()
+	}
+
+	// ArrowStream batches are read at once, values are converted by Response.toFramesArrow
+	if resp.StatusCode == 200 && format == ResponseFormatArrowStream {
+		defer closeReaders()
+		result, meta, err := readArrowStream(reader)
+		if err != nil {
+			return onErr(fmt.Errorf("unable to parse %s response: %w", format, err))
+		}
+		return &Response{ctx: ctx, Meta: meta, arrow: result, closeBody: result.release}, nil
+	}
+
+	// Row based formats are decoded incrementally while frames are built,
+	// the body stays open until Response.close is called
+	if resp.StatusCode == 200 && format != ResponseFormatJSON {
+		streamResp, err := newStreamResponse(ctx, format, reader, closeReaders)
+		if err != nil {
+			closeReaders()
+			return onErr(fmt.Errorf("unable to parse %s response: %w", format, err))
+		}
+		return streamResp, nil
+	}
+	defer closeReaders()
 
 	body, err := io.ReadAll(reader)
 	if err != nil {
@@ -140,7 +342,7 @@ func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Respo
 	}
 
 	if resp.StatusCode != 200 {
-		return onErr(errors.New(string(body)))
+		return onErr(newClickHouseError(resp.StatusCode, resp.Header, body))
 	}
 
 	var jsonResp = &Response{ctx: ctx}
@@ -158,11 +360,56 @@ func (client *ClickHouseClient) Query(ctx context.Context, query string) (*Respo
 }
 
 func (client *ClickHouseClient) FetchTimeZone(ctx context.Context) *time.Location {
-	res, err := client.Query(ctx, TimeZoneQuery)
+	res, err := client.query(ctx, TimeZoneQuery, ResponseFormatJSON, nil, "")
+	if err != nil || res == nil {
+		return time.UTC
+	}
+	defer res.close()
 
-	if err == nil && res != nil && len(res.Data) > 0 && res.Data[0] != nil {
-		return ParseTimeZone(fmt.Sprintf("%v", res.Data[0][TimeZoneFieldName]))
+	var tz *time.Location
+	err = res.forEachRow(func(row map[string]interface{}) error {
+		if tz == nil {
+			tz = ParseTimeZone(fmt.Sprintf("%v", row[TimeZoneFieldName]))
+		}
+		return nil
+	})
+	if err != nil || tz == nil {
+		return time.UTC
 	}
+	return tz
+}
+
+var queryIDUnsafeCharsRE = regexp.MustCompile(`[^A-Za-z0-9_]+`)
 
-	return time.UTC
+// newQueryID builds query_id from the alert rule UID and refId, so queries are easy to find in system.query_log,
+// the random suffix keeps ids of concurrent requests unique
+func newQueryID(ruleUID, refID string) string {
+	parts := []string{"grafana"}
+	for _, part := range []string{ruleUID, refID} {
+		if part != "" {
+			parts = append(parts, queryIDUnsafeCharsRE.ReplaceAllString(part, "_"))
+		}
+	}
+	suffix := make([]byte, 8)
+	_, _ = rand.Read(suffix)
+	return strings.Join(append(parts, hex.EncodeToString(suffix)), "-")
+}
+
+// killQueryTimeout limits the side request which kills the cancelled query
+const killQueryTimeout = 10 * time.Second
+
+// killQuery stops the query on the endpoint where it runs, GET requests are readonly, so KILL QUERY is always sent with POST
+func (client *ClickHouseClient) killQuery(e *endpoint, queryID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+	defer cancel()
+	killSettings := *client.settings
+	killSettings.UsePost = true
+	killClient := &ClickHouseClient{settings: &killSettings}
+	res, err := killClient.queryEndpoint(ctx, e, fmt.Sprintf("KILL QUERY WHERE query_id = '%s' ASYNC FORMAT JSON", queryID), ResponseFormatJSON, nil, "")
+	if err != nil {
+		backend.Logger.Warn(fmt.Sprintf("unable to kill query %s: %v", queryID, err))
+		return
+	}
+	res.close()
+	backend.Logger.Debug(fmt.Sprintf("query %s is killed after cancellation", queryID))
 }
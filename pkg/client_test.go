@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQueryID(t *testing.T) {
+	require.Regexp(t, regexp.MustCompile(`^grafana-rule_1-A-[0-9a-f]{16}$`), newQueryID("rule:1", "A"))
+	require.Regexp(t, regexp.MustCompile(`^grafana-B-[0-9a-f]{16}$`), newQueryID("", "B"))
+	require.NotEqual(t, newQueryID("", "A"), newQueryID("", "A"))
+}
+
+func TestClientKillsCancelledQuery(t *testing.T) {
+	queryIDs := make(chan string, 1)
+	killQueries := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			killQueries <- string(body)
+			_, _ = w.Write([]byte(`{"meta":[],"data":[]}`))
+			return
+		}
+		queryIDs <- r.URL.Query().Get("query_id")
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := &ClickHouseClient{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.Query(ctx, DefaultQuery, nil, "grafana-A-0123456789abcdef")
+		errs <- err
+	}()
+
+	require.Equal(t, "grafana-A-0123456789abcdef", <-queryIDs)
+	cancel()
+	require.Error(t, <-errs)
+	select {
+	case query := <-killQueries:
+		require.Equal(t, "KILL QUERY WHERE query_id = 'grafana-A-0123456789abcdef' ASYNC FORMAT JSON", query)
+	case <-time.After(5 * time.Second):
+		t.Fatal("query is not killed")
+	}
+}
+
+func TestClientArrowStreamFallback(t *testing.T) {
+	var queries, queryIDs []string
+	var describeType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		queries = append(queries, query)
+		queryIDs = append(queryIDs, r.URL.Query().Get("query_id"))
+		switch {
+		case describeType == "":
+			w.Header().Set("X-ClickHouse-Exception-Code", "62")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("Code: 62. DB::Exception: Syntax error: failed at position 1. (SYNTAX_ERROR)\n"))
+		case strings.HasPrefix(query, "DESCRIBE"):
+			_, _ = fmt.Fprintf(w, `{"meta":[{"name":"name","type":"String"},{"name":"type","type":"String"}],"data":[{"name":"m","type":"%s"}]}`, describeType)
+		default:
+			_, _ = w.Write([]byte(`{"meta":[{"name":"m","type":"Map(String, UInt8)"}],"data":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	client := &ClickHouseClient{settings: &DatasourceSettings{
+		Instance:       backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient:     server.Client(),
+		ResponseFormat: ResponseFormatArrowStream,
+	}}
+	// the failed DESCRIBE is the error of the query, the query isn't sent again
+	_, err := client.Query(context.Background(), "SELEC m FROM t FORMAT JSON", nil, "")
+	var chErr *ClickHouseError
+	require.ErrorAs(t, err, &chErr)
+	require.Equal(t, 62, chErr.Code)
+	require.Len(t, queries, 1)
+
+	// types which can't be read from ArrowStream are requested in JSON format
+	queries, queryIDs, describeType = nil, nil, "Map(String, UInt8)"
+	resp, err := client.Query(context.Background(), "SELECT m FROM t FORMAT JSON", nil, "grafana-A-1")
+	require.NoError(t, err)
+	resp.close()
+	require.Len(t, queries, 2)
+	require.Equal(t, "SELECT m FROM t FORMAT JSON", queries[1])
+	// DESCRIBE isn't killed or logged as the query itself
+	require.Equal(t, []string{"grafana-A-1-describe", "grafana-A-1"}, queryIDs)
+}
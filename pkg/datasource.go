@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/altinity/clickhouse-grafana/pkg/eval"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -14,7 +17,8 @@ import (
 )
 
 type ClickHouseDatasource struct {
-	im instancemgmt.InstanceManager
+	im      instancemgmt.InstanceManager
+	streams streamSessions
 }
 
 func (ds *ClickHouseDatasource) getClient(ctx context.Context, pluginCtx backend.PluginContext) (*ClickHouseClient, error) {
@@ -32,23 +36,54 @@ func (ds *ClickHouseDatasource) executeQuery(pluginContext backend.PluginContext
 
 	onErr := func(err error) backend.DataResponse {
 		backend.Logger.Error(fmt.Sprintf("Datasource executeQuery error: %s", err))
-		return backend.DataResponse{Error: err}
+		return errorResponse(err)
 	}
 
 	client, err := ds.getClient(ctx, pluginContext)
 	if err != nil {
 		return onErr(err)
 	}
+	query.PrecisionPolicy = resolvePrecisionPolicy(query.PrecisionPolicy, client.settings.PrecisionPolicy)
+	settings, err := mergeClickHouseSettings(client.settings.ClickHouseSettings, query.ClickHouseSettings, client.settings.AllowedQuerySettings)
+	if err != nil {
+		return onErr(backend.DownstreamError(err))
+	}
+	cacheTTL, err := client.resultCacheTTL(query)
+	if err != nil {
+		return onErr(backend.DownstreamError(err))
+	}
+	if cacheTTL > 0 && query.interval > 0 && (query.CacheAlignTimeRange || client.settings.CacheAlignTimeRange) {
+		query.From, query.To = alignTimeRange(query.From, query.To, query.interval)
+	}
 	sql := query.ApplyTimeRangeToQuery()
-	clickhouseResponse, err := client.Query(ctx, sql)
+	var cacheKey string
+	if cacheTTL > 0 {
+		cacheKey = resultCacheKey(sql, settings, query)
+		if cached, ok := client.settings.cache.get(cacheKey); ok {
+			// the key doesn't include refId, so queries of other panels with the same SQL share the entry
+			for _, frame := range cached.frames {
+				frame.RefID = query.RefId
+			}
+			addFramesNotice(cached.frames, fmt.Sprintf("Cache hit: result is %s old, cache TTL is %s", cached.age.Round(time.Second), cacheTTL))
+			return backend.DataResponse{Frames: cached.frames}
+		}
+	}
+	clickhouseResponse, err := client.Query(ctx, sql, settings, newQueryID(query.RuleUid, query.RefId))
 	if err != nil {
 		return onErr(err)
 	}
+	defer clickhouseResponse.close()
 
 	frames, err := clickhouseResponse.toFrames(query, client.FetchTimeZone)
 	if err != nil {
 		return onErr(err)
 	}
+	if cacheTTL > 0 {
+		if err = client.settings.cache.set(cacheKey, frames, cacheTTL); err != nil {
+			backend.Logger.Warn(fmt.Sprintf("unable to cache query result: %v", err))
+		}
+		addFramesNotice(frames, fmt.Sprintf("Cache miss: result is cached for %s", cacheTTL))
+	}
 
 	backend.Logger.Debug(fmt.Sprintf("queryResponse: %s returns %v frames", sql, len(frames)))
 	return backend.DataResponse{
@@ -56,21 +91,83 @@ func (ds *ClickHouseDatasource) executeQuery(pluginContext backend.PluginContext
 	}
 }
 
+// statusClientClosedRequest is the status of queries cancelled by the client, e.g. when the user leaves the dashboard,
+// the SDK doesn't have it, Grafana reports cancelled requests with the same non-standard status
+const statusClientClosedRequest backend.Status = 499
+
+// errorResponse sets the status and the error source of the failed query, errors of ClickHouse, network
+// and invalid queries are downstream errors, so they aren't counted as failures of the plugin
+func errorResponse(err error) backend.DataResponse {
+	var chErr *ClickHouseError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &chErr):
+		return backend.DataResponse{Error: err, Status: chErr.Status(), ErrorSource: backend.ErrorSourceDownstream}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return backend.DataResponse{Error: err, Status: backend.StatusTimeout, ErrorSource: backend.ErrorSourceDownstream}
+	case errors.Is(err, context.Canceled):
+		return backend.DataResponse{Error: err, Status: statusClientClosedRequest, ErrorSource: backend.ErrorSourceDownstream}
+	case backend.IsDownstreamError(err):
+		return backend.DataResponse{Error: err, Status: backend.StatusBadRequest, ErrorSource: backend.ErrorSourceDownstream}
+	case backend.IsDownstreamHTTPError(err):
+		return backend.DataResponse{Error: err, Status: backend.StatusBadGateway, ErrorSource: backend.ErrorSourceDownstream}
+	}
+	return backend.DataResponse{Error: err, Status: backend.StatusInternal, ErrorSource: backend.ErrorSourcePlugin}
+}
+
 func (ds *ClickHouseDatasource) evalQuery(pluginContext backend.PluginContext, ctx context.Context, evalQuery *eval.EvalQuery) backend.DataResponse {
 	onErr := func(err error) backend.DataResponse {
 		backend.Logger.Error(fmt.Sprintf("Datasource evalQuery error: %s", err))
-		return backend.DataResponse{Error: err}
+		return errorResponse(err)
 	}
 
-	sql, err := evalQuery.ApplyMacrosAndTimeRangeToQuery()
+	q := Query{
+		RefId:               evalQuery.RefId,
+		RuleUid:             evalQuery.RuleUid,
+		Format:              evalQuery.Format,
+		Extrapolate:         evalQuery.Extrapolate,
+		WideFormat:          evalQuery.WideFormat,
+		PrecisionPolicy:     evalQuery.PrecisionPolicy,
+		From:                evalQuery.From,
+		To:                  evalQuery.To,
+		CacheTTL:            evalQuery.CacheTTL,
+		CacheAlignTimeRange: evalQuery.CacheAlignTimeRange,
+		streaming:           evalQuery.Streaming,
+	}
+	if len(evalQuery.ClickHouseSettings) > 0 {
+		if err := json.Unmarshal(evalQuery.ClickHouseSettings, &q.ClickHouseSettings); err != nil {
+			return onErr(backend.DownstreamErrorf("unable to parse clickhouseSettings: %w", err))
+		}
+	}
+	// macros use the time range, so it's aligned for the cache before they're applied
+	client, err := ds.getClient(ctx, pluginContext)
 	if err != nil {
 		return onErr(err)
 	}
+	cacheTTL, err := client.resultCacheTTL(&q)
+	if err != nil {
+		return onErr(backend.DownstreamError(err))
+	}
+	// "0" disables the delta cache as well, e.g. stream ticks query narrow windows which must not replace cached buckets
+	if client.settings.cache != nil && evalQuery.CacheTTL != "0" && (evalQuery.DeltaCache || client.settings.DeltaCache) && isDeltaCacheable(evalQuery) {
+		return ds.executeDeltaQuery(pluginContext, ctx, client, evalQuery, &q)
+	}
+	if cacheTTL > 0 && (q.CacheAlignTimeRange || client.settings.CacheAlignTimeRange) {
+		if err = evalQuery.ResolveInterval(); err != nil {
+			return onErr(backend.DownstreamError(err))
+		}
+		q.interval = time.Duration(evalQuery.IntervalSec) * time.Second
+		evalQuery.From, evalQuery.To = alignTimeRange(evalQuery.From, evalQuery.To, q.interval)
+		q.From, q.To = evalQuery.From, evalQuery.To
+	}
 
-	q := Query{
-		From:     evalQuery.From,
-		To:       evalQuery.To,
-		RawQuery: sql,
+	q.RawQuery, err = evalQuery.ApplyMacrosAndTimeRangeToQuery()
+	if err != nil {
+		return onErr(backend.DownstreamError(err))
+	}
+	if evalQuery.TailColumn != "" {
+		// macros like $columns expand to whole queries, so the tail condition wraps the expanded one
+		q.RawQuery = tailQuery(q.RawQuery, evalQuery.TailColumn, evalQuery.TailKey)
 	}
 	return ds.executeQuery(pluginContext, ctx, &q)
 }
@@ -106,9 +203,10 @@ func (ds *ClickHouseDatasource) QueryData(
 		}
 		if evalJsonErr != nil {
 			var q = Query{
-				From:    query.TimeRange.From,
-				To:      query.TimeRange.To,
-				RuleUid: ruleUid,
+				From:     query.TimeRange.From,
+				To:       query.TimeRange.To,
+				RuleUid:  ruleUid,
+				interval: query.Interval,
 			}
 			jsonErr := json.Unmarshal(query.JSON, &q)
 			if jsonErr != nil {
@@ -146,10 +244,11 @@ func (ds *ClickHouseDatasource) CheckHealth(
 	if err != nil {
 		return onErr(err)
 	}
-	_, err = client.Query(ctx, DefaultQuery)
+	res, err := client.Query(ctx, DefaultQuery, client.settings.ClickHouseSettings, "")
 	if err != nil {
 		return onErr(err)
 	}
+	res.close()
 
 	return &backend.CheckHealthResult{
 		Status:  backend.HealthStatusOk,
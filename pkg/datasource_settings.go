@@ -25,9 +25,50 @@ type DatasourceSettings struct {
 	UseCompression                bool   `json:"useCompression,omitempty"`
 	CompressionType               string `json:"compressionType,omitempty"`
 	TLSSkipVerify                 bool   `json:"tlsSkipVerify"`
+	// ResponseFormat is ClickHouse output format used by backend queries: JSON (default), Native, JSONCompactEachRowWithNamesAndTypes or ArrowStream
+	ResponseFormat string `json:"responseFormat,omitempty"`
+	// PrecisionPolicy is the representation of [U]Int64, [U]Int128, [U]Int256 and Decimal columns: auto (default), float64, string or split
+	PrecisionPolicy string `json:"precisionPolicy,omitempty"`
+	// ClickHouseSettings are sent with each query, e.g. {"max_execution_time": 60, "max_result_rows": 1000000}
+	ClickHouseSettings ClickHouseSettings `json:"clickhouseSettings,omitempty"`
+	// AllowedQuerySettings lists settings which queries could set or override with Query.ClickHouseSettings
+	AllowedQuerySettings []string `json:"allowedQuerySettings,omitempty"`
+	// Endpoints are URLs of other replicas, queries are spread over them and Instance.URL by LoadBalancing strategy
+	Endpoints []string `json:"endpoints,omitempty"`
+	// LoadBalancing is the order of endpoints: first_healthy (default), round_robin, random or least_latency
+	LoadBalancing string `json:"loadBalancing,omitempty"`
+	// MaxRetries is how many times failed SELECT queries are sent again, by default each endpoint is tried once, the only endpoint is retried once
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// RetryErrorCodes are ClickHouse error codes which are retried besides connection errors, see defaultRetryErrorCodes
+	RetryErrorCodes []int `json:"retryErrorCodes,omitempty"`
+	// CacheMaxBytes limits the size of the query result cache, 0 disables the cache
+	CacheMaxBytes int64 `json:"cacheMaxBytes,omitempty"`
+	// CacheTTL is how long query results are cached, e.g. "1m", queries could override it with Query.CacheTTL
+	CacheTTL string `json:"cacheTTL,omitempty"`
+	// CacheAlignTimeRange extends time ranges of cached queries to $interval boundaries, so rolling ranges hit the cache
+	CacheAlignTimeRange bool `json:"cacheAlignTimeRange,omitempty"`
+	// DeltaCache keeps complete buckets of time series queries in the result cache, so refreshes query only
+	// new buckets, queries could enable it with "deltaCache", see executeDeltaQuery
+	DeltaCache bool `json:"deltaCache,omitempty"`
+	// MaxStreams limits concurrent streaming loops of the datasource, 0 means no limit
+	MaxStreams int `json:"maxStreams,omitempty"`
+	// MinStreamingInterval is the minimal streamingInterval of streaming queries in milliseconds
+	MinStreamingInterval int `json:"minStreamingInterval,omitempty"`
+	// StreamingLatencyBudget is the fraction of the poll interval which a streaming query could take,
+	// slower queries extend the interval, see streamPacer
+	StreamingLatencyBudget float64 `json:"streamingLatencyBudget,omitempty"`
+	// StreamCheckpointDir is the directory where delta streams save accumulated frames, so they're restored
+	// after restarts and reconnects, it's relative to streamCheckpointRoot, empty disables checkpoints
+	StreamCheckpointDir string `json:"streamCheckpointDir,omitempty"`
+	// StreamCheckpointMaxAge is how long checkpoints are restored, e.g. "1h", older ones force the full reload
+	StreamCheckpointMaxAge string `json:"streamCheckpointMaxAge,omitempty"`
 
 	CustomHeaders map[string]string `json:"-,omitempty"`
 	HTTPClient    *http.Client      `json:"-"`
+
+	endpoints   *endpointPool
+	cache       *resultCache
+	checkpoints *streamCheckpoints
 }
 
 func NewDatasourceSettings(ctx context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
@@ -39,6 +80,28 @@ func NewDatasourceSettings(ctx context.Context, settings backend.DataSourceInsta
 		return nil, fmt.Errorf("unable to parse settings json %s. Error: %w", settings.JSONData, err)
 	}
 
+	if err = dsSettings.ClickHouseSettings.validate(); err != nil {
+		return nil, err
+	}
+	if _, err = parseCacheTTL("", dsSettings.CacheTTL); err != nil {
+		return nil, err
+	}
+	if dsSettings.CacheMaxBytes > 0 {
+		dsSettings.cache = newResultCache(dsSettings.CacheMaxBytes)
+	}
+	if dsSettings.StreamingLatencyBudget < 0 || dsSettings.StreamingLatencyBudget > 1 {
+		return nil, fmt.Errorf("invalid streamingLatencyBudget %v, expected a fraction of the poll interval between 0 and 1", dsSettings.StreamingLatencyBudget)
+	}
+	if dsSettings.StreamCheckpointDir != "" {
+		dir, err := streamCheckpointPath(dsSettings.StreamCheckpointDir)
+		if err != nil {
+			return nil, err
+		}
+		if dsSettings.checkpoints, err = newStreamCheckpoints(dir, dsSettings.StreamCheckpointMaxAge); err != nil {
+			return nil, err
+		}
+	}
+
 	dsSettings.CustomHeaders = make(map[string]string)
 
 	var tmpMap = make(map[string]interface{})
@@ -60,6 +123,10 @@ func NewDatasourceSettings(ctx context.Context, settings backend.DataSourceInsta
 	}
 
 	dsSettings.Instance = settings
+	dsSettings.endpoints, err = newEndpointPool(append([]string{settings.URL}, dsSettings.Endpoints...), dsSettings.LoadBalancing)
+	if err != nil {
+		return nil, err
+	}
 	httpClientOptions, err := settings.HTTPClientOptions(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("unable to build http client options: %w", err)
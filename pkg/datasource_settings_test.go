@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"path/filepath"
 	"testing"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -55,3 +57,27 @@ func TestNewDatasourceSettings(t *testing.T) {
 	require.Equal(t, "value1", dsSettings.CustomHeaders["header1"])
 	require.Equal(t, "value2", dsSettings.CustomHeaders["header2"])
 }
+
+func TestNewDatasourceSettingsStreamCheckpointDir(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	newSettings := func(dir string) (*DatasourceSettings, error) {
+		instance, err := NewDatasourceSettings(context.Background(), backend.DataSourceInstanceSettings{
+			JSONData: []byte(fmt.Sprintf(`{"streamCheckpointDir":%q}`, dir)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return instance.(*DatasourceSettings), nil
+	}
+
+	dsSettings, err := newSettings("dashboards/prod")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(streamCheckpointRoot(), "dashboards", "prod"), dsSettings.checkpoints.dir)
+	require.DirExists(t, dsSettings.checkpoints.dir)
+
+	for _, dir := range []string{"/etc", "../../etc", "dashboards/../../x"} {
+		_, err = newSettings(dir)
+		require.ErrorContains(t, err, "invalid streamCheckpointDir", dir)
+	}
+}
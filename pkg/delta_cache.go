@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/altinity/clickhouse-grafana/pkg/eval"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// deltaCacheIdleTimeout is how long complete buckets of a query are kept after the last refresh
+const deltaCacheIdleTimeout = 30 * time.Minute
+
+// isDeltaCacheable checks that the query result is a time series which could be fetched by parts,
+// extrapolation depends on the edges of the whole range, so it's fetched at once
+func isDeltaCacheable(evalQuery *eval.EvalQuery) bool {
+	switch evalQuery.Format {
+	case QueryFormatLogs, QueryFormatTraces, QueryFormatFlamegraph, QueryFormatAnnotations:
+		return false
+	}
+	return !evalQuery.Extrapolate && queryHasTimeMacro(evalQuery.Query)
+}
+
+// deltaCacheKey identifies the panel query regardless of its time range
+func deltaCacheKey(evalQuery *eval.EvalQuery) string {
+	q := *evalQuery
+	q.From, q.To = time.Time{}, time.Time{}
+	key, _ := json.Marshal(q)
+	sum := sha256.Sum256(key)
+	return "delta:" + hex.EncodeToString(sum[:])
+}
+
+// executeDeltaQuery works like the delta streaming mode for regular refreshes. Complete buckets of the previous
+// refresh are kept in the result cache and only [last complete bucket, To] is queried, the bucket before it
+// is queried too, so $rate and other macros which compare neighbour rows get the previous value.
+// From is aligned to the bucket, so the first bucket is complete as well.
+func (ds *ClickHouseDatasource) executeDeltaQuery(pluginContext backend.PluginContext, ctx context.Context, client *ClickHouseClient, evalQuery *eval.EvalQuery, q *Query) backend.DataResponse {
+	// the interval depends on the length of the range, it's computed once and pinned for the delta query
+	full := *evalQuery
+	if _, err := full.ApplyMacrosAndTimeRangeToQuery(); err != nil {
+		return errorResponse(backend.DownstreamError(err))
+	}
+	step := time.Duration(full.IntervalSec) * time.Second
+	from := roundDownTo(evalQuery.From, int64(full.IntervalSec)).In(evalQuery.From.Location())
+	completeTo := roundDownTo(evalQuery.To, int64(full.IntervalSec)).In(evalQuery.To.Location())
+	pinned := *evalQuery
+	pinned.Interval, pinned.IntervalSec, pinned.IntervalMs = "", full.IntervalSec, full.IntervalMs
+	key := deltaCacheKey(&pinned)
+
+	queryFrom := from
+	cached, ok := client.settings.cache.get(key)
+	if ok && !cached.from.After(from) && !cached.to.Before(from) && !cached.to.After(completeTo) {
+		queryFrom = cached.to.Add(-step)
+		if queryFrom.Before(from) {
+			queryFrom = from
+		}
+	} else {
+		cached = nil
+	}
+
+	response := ds.executeRangeQuery(pluginContext, ctx, &pinned, q, queryFrom, evalQuery.To)
+	if response.Error != nil || !hasTimeFields(response.Frames) {
+		return response
+	}
+	frames := response.Frames
+	if cached != nil {
+		merged, ok := mergeDeltaFrames(cached.frames, frames, cached.to)
+		if !ok {
+			// the schema is changed, e.g. by ALTER TABLE, the whole range is fetched again
+			cached, queryFrom = nil, from
+			response = ds.executeRangeQuery(pluginContext, ctx, &pinned, q, from, evalQuery.To)
+			if response.Error != nil || !hasTimeFields(response.Frames) {
+				return response
+			}
+			merged = response.Frames
+		}
+		frames = merged
+	}
+	frames = sliceFramesByTime(frames, from, time.Time{})
+	for _, frame := range frames {
+		frame.RefID = q.RefId
+	}
+
+	if completeTo.After(from) {
+		if err := client.settings.cache.setRange(key, sliceFramesByTime(frames, from, completeTo), deltaCacheIdleTimeout, from, completeTo); err != nil {
+			backend.Logger.Warn(fmt.Sprintf("unable to cache complete buckets: %v", err))
+		}
+	}
+	if cached != nil {
+		addFramesNotice(frames, fmt.Sprintf("Delta cache: %d buckets are cached, queried from %s", int(cached.to.Sub(from)/step), queryFrom.UTC().Format(time.RFC3339)))
+	} else {
+		addFramesNotice(frames, "Delta cache: whole range is queried")
+	}
+	return backend.DataResponse{Frames: frames}
+}
+
+// executeRangeQuery applies macros for the time range and executes the query bypassing the result cache
+func (ds *ClickHouseDatasource) executeRangeQuery(pluginContext backend.PluginContext, ctx context.Context, evalQuery *eval.EvalQuery, q *Query, from, to time.Time) backend.DataResponse {
+	rangeEvalQuery := *evalQuery
+	rangeEvalQuery.From, rangeEvalQuery.To = from, to
+	sql, err := rangeEvalQuery.ApplyMacrosAndTimeRangeToQuery()
+	if err != nil {
+		return errorResponse(backend.DownstreamError(err))
+	}
+	rangeQuery := *q
+	rangeQuery.From, rangeQuery.To = from, to
+	rangeQuery.RawQuery = sql
+	rangeQuery.CacheTTL = "0"
+	return ds.executeQuery(pluginContext, ctx, &rangeQuery)
+}
+
+// frameTimeFieldIdx returns the index of the first time field
+func frameTimeFieldIdx(frame *data.Frame) int {
+	for i, field := range frame.Fields {
+		if field.Type() == data.FieldTypeTime || field.Type() == data.FieldTypeNullableTime {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasTimeFields(frames data.Frames) bool {
+	for _, frame := range frames {
+		if frameTimeFieldIdx(frame) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sliceFrameByTime returns the copy of the frame with rows from <= t < to, zero to means no upper bound
+func sliceFrameByTime(frame *data.Frame, from, to time.Time) *data.Frame {
+	sliced := data.NewFrame(frame.Name)
+	sliced.RefID = frame.RefID
+	sliced.Meta = frame.Meta
+	for _, field := range frame.Fields {
+		newField := data.NewFieldFromFieldType(field.Type(), 0)
+		newField.Name = field.Name
+		newField.Labels = field.Labels
+		newField.Config = field.Config
+		sliced.Fields = append(sliced.Fields, newField)
+	}
+	timeField := frame.Fields[frameTimeFieldIdx(frame)]
+	for r := 0; r < frame.Rows(); r++ {
+		t, ok := timeField.ConcreteAt(r)
+		if !ok || t.(time.Time).Before(from) || (!to.IsZero() && !t.(time.Time).Before(to)) {
+			continue
+		}
+		for i, field := range frame.Fields {
+			sliced.Fields[i].Append(field.At(r))
+		}
+	}
+	return sliced
+}
+
+func sliceFramesByTime(frames data.Frames, from, to time.Time) data.Frames {
+	sliced := make(data.Frames, 0, len(frames))
+	for _, frame := range frames {
+		sliced = append(sliced, sliceFrameByTime(frame, from, to))
+	}
+	return sliced
+}
+
+// mergeDeltaFrames takes rows before cutoff from cached frames and rows after it from delta frames,
+// series are matched by frameKey, it returns false when the series have different fields
+func mergeDeltaFrames(cached, delta data.Frames, cutoff time.Time) (data.Frames, bool) {
+	deltaByKey := make(map[string]*data.Frame, len(delta))
+	for _, frame := range delta {
+		deltaByKey[frameKey(frame)] = frame
+	}
+	merged := make(data.Frames, 0, len(cached)+len(delta))
+	for _, frame := range cached {
+		head := sliceFrameByTime(frame, time.Time{}, cutoff)
+		key := frameKey(frame)
+		if deltaFrame, ok := deltaByKey[key]; ok {
+			if !sameFrameSchema(frame, deltaFrame) {
+				return nil, false
+			}
+			tail := sliceFrameByTime(deltaFrame, cutoff, time.Time{})
+			for r := 0; r < tail.Rows(); r++ {
+				head.AppendRow(tail.RowCopy(r)...)
+			}
+			delete(deltaByKey, key)
+		}
+		merged = append(merged, head)
+	}
+	for _, frame := range delta {
+		if _, isNew := deltaByKey[frameKey(frame)]; isNew {
+			merged = append(merged, sliceFrameByTime(frame, cutoff, time.Time{}))
+		}
+	}
+	return merged, true
+}
+
+func sameFrameSchema(a, b *data.Frame) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if a.Fields[i].Name != b.Fields[i].Name || a.Fields[i].Type() != b.Fields[i].Type() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/altinity/clickhouse-grafana/pkg/eval"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+var deltaTestRangeRE = regexp.MustCompile(`>= toDateTime\((\d+)\) AND .* <= toDateTime\((\d+)\)`)
+
+// deltaCacheTestServer returns a row per minute of the requested range, the value is the minute number
+func deltaCacheTestServer(t *testing.T, ranges *[][2]time.Time) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if query == TimeZoneQuery {
+			_, _ = w.Write([]byte(`{"meta":[{"name":"timezone()","type":"String"}],"data":[{"timezone()":"UTC"}]}`))
+			return
+		}
+		match := deltaTestRangeRE.FindStringSubmatch(query)
+		require.NotNil(t, match, query)
+		from, _ := strconv.ParseInt(match[1], 10, 64)
+		to, _ := strconv.ParseInt(match[2], 10, 64)
+		*ranges = append(*ranges, [2]time.Time{time.Unix(from, 0).UTC(), time.Unix(to, 0).UTC()})
+		var rows []string
+		for minute := from / 60; minute*60 <= to; minute++ {
+			rows = append(rows, fmt.Sprintf(`{"t":"%d","v":"%d"}`, minute*60000, minute))
+		}
+		_, _ = fmt.Fprintf(w, `{"meta":[{"name":"t","type":"UInt64"},{"name":"v","type":"UInt64"}],"data":[%s]}`, strings.Join(rows, ","))
+	}))
+}
+
+func TestDeltaCache(t *testing.T) {
+	var ranges [][2]time.Time
+	server := deltaCacheTestServer(t, &ranges)
+	defer server.Close()
+
+	ds := &ClickHouseDatasource{im: &testInstanceManager{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+		DeltaCache: true,
+		cache:      newResultCache(1 << 20),
+	}}}
+	query := func(from, to time.Time) backend.DataResponse {
+		return ds.evalQuery(backend.PluginContext{}, context.Background(), &eval.EvalQuery{
+			RefId:       "A",
+			Query:       "SELECT $timeSeries AS t, count() AS v FROM $table WHERE $timeFilter GROUP BY t ORDER BY t",
+			DateTimeCol: "dt",
+			Table:       "events",
+			Interval:    "1m",
+			Format:      "time_series",
+			From:        from,
+			To:          to,
+		})
+	}
+	start := time.Date(2024, 1, 15, 10, 0, 30, 0, time.UTC)
+
+	first := query(start, start.Add(time.Hour))
+	require.NoError(t, first.Error)
+	require.Equal(t, time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC), ranges[0][0])
+	require.Contains(t, first.Frames[0].Meta.Notices[0].Text, "whole range")
+
+	second := query(start.Add(5*time.Minute), start.Add(time.Hour+5*time.Minute))
+	require.NoError(t, second.Error)
+	require.Len(t, ranges, 2)
+	// the last complete bucket of the first refresh is 10:59, it's queried again with the new buckets
+	require.Equal(t, time.Date(2024, 1, 15, 10, 59, 0, 0, time.UTC), ranges[1][0])
+	require.Contains(t, second.Frames[0].Meta.Notices[0].Text, "queried from 2024-01-15T10:59:00Z")
+
+	// the merged result is the same as the result of the whole range
+	require.Len(t, second.Frames, 1)
+	frame := second.Frames[0]
+	require.Equal(t, "A", frame.RefID)
+	require.Equal(t, 61, frame.Rows())
+	for r := 0; r < frame.Rows(); r++ {
+		tm := frame.Fields[0].At(r).(time.Time)
+		require.Equal(t, start.Add(5*time.Minute).Truncate(time.Minute).Add(time.Duration(r)*time.Minute), tm.UTC())
+		require.Equal(t, float64(tm.Unix()/60), frame.Fields[1].At(r))
+	}
+}
+
+func TestStreamTickSkipsDeltaCache(t *testing.T) {
+	var ranges [][2]time.Time
+	server := deltaCacheTestServer(t, &ranges)
+	defer server.Close()
+
+	cache := newResultCache(1 << 20)
+	ds := &ClickHouseDatasource{im: &testInstanceManager{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+		DeltaCache: true,
+		cache:      cache,
+	}}}
+	sq := &streamQuery{
+		RefId:       "A",
+		Query:       "SELECT $timeSeries AS t, count() AS v FROM $table WHERE $timeFilter GROUP BY t ORDER BY t",
+		DateTimeCol: "dt",
+		Table:       "events",
+		Interval:    "1m",
+		Format:      "time_series",
+	}
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	tick := ds.executeStreamEvalQuery(backend.PluginContext{}, context.Background(), sq, start.Add(50*time.Minute), start.Add(time.Hour))
+	require.NoError(t, tick.Error)
+	require.Equal(t, [][2]time.Time{{start.Add(50 * time.Minute), start.Add(time.Hour)}}, ranges)
+	require.Nil(t, tick.Frames[0].Meta)
+	require.Empty(t, cache.entries)
+}
+
+func TestMergeDeltaFrames(t *testing.T) {
+	ts := func(minutes ...int) []time.Time {
+		values := make([]time.Time, len(minutes))
+		for i, m := range minutes {
+			values[i] = time.Date(2024, 1, 15, 10, m, 0, 0, time.UTC)
+		}
+		return values
+	}
+	cutoff := time.Date(2024, 1, 15, 10, 2, 0, 0, time.UTC)
+	cached := data.Frames{
+		data.NewFrame("a", data.NewField("t", nil, ts(0, 1)), data.NewField("a", nil, []float64{1, 2})),
+		data.NewFrame("b", data.NewField("t", nil, ts(0, 1)), data.NewField("b", nil, []float64{10, 20})),
+	}
+	delta := data.Frames{
+		data.NewFrame("a", data.NewField("t", nil, ts(1, 2, 3)), data.NewField("a", nil, []float64{200, 3, 4})),
+		data.NewFrame("c", data.NewField("t", nil, ts(1, 2)), data.NewField("c", nil, []float64{100, 300})),
+	}
+	merged, ok := mergeDeltaFrames(cached, delta, cutoff)
+	require.True(t, ok)
+	require.Len(t, merged, 3)
+	require.Equal(t, []float64{1, 2, 3, 4}, []float64{merged[0].Fields[1].At(0).(float64), merged[0].Fields[1].At(1).(float64), merged[0].Fields[1].At(2).(float64), merged[0].Fields[1].At(3).(float64)})
+	require.Equal(t, 2, merged[1].Rows())
+	require.Equal(t, 1, merged[2].Rows())
+	require.Equal(t, 300.0, merged[2].Fields[1].At(0))
+
+	changed := data.Frames{data.NewFrame("a", data.NewField("t", nil, ts(2)), data.NewField("a", nil, []string{"x"}))}
+	_, ok = mergeDeltaFrames(cached, changed, cutoff)
+	require.False(t, ok)
+}
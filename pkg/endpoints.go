@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load balancing strategies of endpoints, see DatasourceSettings.LoadBalancing
+const (
+	// LoadBalancingFirstHealthy sends queries to the first healthy endpoint in the order of settings
+	LoadBalancingFirstHealthy = "first_healthy"
+	// LoadBalancingRoundRobin sends queries to healthy endpoints in turn
+	LoadBalancingRoundRobin = "round_robin"
+	// LoadBalancingRandom sends queries to a random healthy endpoint
+	LoadBalancingRandom = "random"
+	// LoadBalancingLeastLatency sends queries to the healthy endpoint with the lowest average response time
+	LoadBalancingLeastLatency = "least_latency"
+)
+
+// endpointUnhealthyTimeout is how long an endpoint is tried only after healthy ones once it has failed
+const endpointUnhealthyTimeout = 30 * time.Second
+
+// retryBackoff is the pause before the retry of the query on the same endpoint
+const retryBackoff = 200 * time.Millisecond
+
+// defaultRetryErrorCodes are TOO_MANY_SIMULTANEOUS_QUERIES, SOCKET_TIMEOUT, NETWORK_ERROR and ALL_CONNECTION_TRIES_FAILED
+var defaultRetryErrorCodes = []int{202, 209, 210, 279}
+
+// latencyWeight is the weight of the last response time in the moving average of endpoint latency
+const latencyWeight = 0.3
+
+type endpoint struct {
+	url string
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+	latency        time.Duration
+}
+
+// markFailed moves the endpoint to the end of the order until endpointUnhealthyTimeout passes
+func (e *endpoint) markFailed() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unhealthyUntil = time.Now().Add(endpointUnhealthyTimeout)
+}
+
+// markResponded makes the endpoint healthy and updates its average response time
+func (e *endpoint) markResponded(latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.unhealthyUntil = time.Time{}
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(e.latency))
+	}
+}
+
+func (e *endpoint) state() (healthy bool, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().After(e.unhealthyUntil), e.latency
+}
+
+// endpointPool keeps the health of ClickHouse replicas between requests, it lives as long as DatasourceSettings
+type endpointPool struct {
+	endpoints []*endpoint
+	strategy  string
+	next      atomic.Uint64
+}
+
+func newEndpointPool(urls []string, strategy string) (*endpointPool, error) {
+	pool := &endpointPool{strategy: strategy}
+	switch strategy {
+	case "":
+		pool.strategy = LoadBalancingFirstHealthy
+	case LoadBalancingFirstHealthy, LoadBalancingRoundRobin, LoadBalancingRandom, LoadBalancingLeastLatency:
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %s", strategy)
+	}
+	for _, u := range urls {
+		if u == "" || slices.ContainsFunc(pool.endpoints, func(e *endpoint) bool { return e.url == u }) {
+			continue
+		}
+		if _, err := url.Parse(u); err != nil {
+			return nil, fmt.Errorf("unable to parse clickhouse endpoint url: %w", err)
+		}
+		pool.endpoints = append(pool.endpoints, &endpoint{url: u})
+	}
+	if len(pool.endpoints) == 0 {
+		pool.endpoints = []*endpoint{{}}
+	}
+	return pool, nil
+}
+
+// order returns endpoints in the order they're tried by the query, unhealthy endpoints go last
+func (pool *endpointPool) order() []*endpoint {
+	ordered := slices.Clone(pool.endpoints)
+	switch pool.strategy {
+	case LoadBalancingRoundRobin:
+		start := int(pool.next.Add(1)-1) % len(ordered)
+		ordered = append(ordered[start:], ordered[:start]...)
+	case LoadBalancingRandom:
+		rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	}
+	healthy := make(map[*endpoint]bool, len(ordered))
+	latency := make(map[*endpoint]time.Duration, len(ordered))
+	for _, e := range ordered {
+		healthy[e], latency[e] = e.state()
+	}
+	slices.SortStableFunc(ordered, func(a, b *endpoint) int {
+		if healthy[a] != healthy[b] {
+			if healthy[a] {
+				return -1
+			}
+			return 1
+		}
+		if pool.strategy == LoadBalancingLeastLatency {
+			// endpoints without measured latency go first to be measured
+			return cmp.Compare(latency[a], latency[b])
+		}
+		return 0
+	})
+	return ordered
+}
+
+// attempts returns how many times the idempotent query is sent, by default each endpoint is tried once
+// and the only endpoint is retried once, so it could recover from TOO_MANY_SIMULTANEOUS_QUERIES
+func (pool *endpointPool) attempts(maxRetries int) int {
+	if maxRetries > 0 {
+		return maxRetries + 1
+	}
+	return max(len(pool.endpoints), 2)
+}
+
+// idempotentQueryRE matches read-only queries which are safe to send again, leading comments are skipped
+var idempotentQueryRE = regexp.MustCompile(`(?is)^(?:\s+|--[^\n]*\n|/\*.*?\*/|\()*(SELECT|WITH|DESCRIBE|DESC|SHOW|EXPLAIN|EXISTS)\b`)
+
+func isIdempotentQuery(query string) bool {
+	return idempotentQueryRE.MatchString(query)
+}
+
+// endpointConnectionError is returned when the request didn't get any response from the endpoint
+type endpointConnectionError struct {
+	err error
+}
+
+func (e *endpointConnectionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *endpointConnectionError) Unwrap() error {
+	return e.err
+}
+
+// isEndpointDown checks whether the endpoint should be marked as unhealthy after the error
+func isEndpointDown(err error) bool {
+	var connErr *endpointConnectionError
+	if errors.As(err, &connErr) {
+		return true
+	}
+	var chErr *ClickHouseError
+	if errors.As(err, &chErr) && chErr.Code == 0 {
+		switch chErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryableError checks whether the failed idempotent query could be sent again
+func isRetryableError(err error, retryErrorCodes []int) bool {
+	if isEndpointDown(err) {
+		return true
+	}
+	var chErr *ClickHouseError
+	if !errors.As(err, &chErr) || chErr.Code == 0 {
+		return false
+	}
+	if len(retryErrorCodes) == 0 {
+		retryErrorCodes = defaultRetryErrorCodes
+	}
+	return slices.Contains(retryErrorCodes, chErr.Code)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func endpointURLs(endpoints []*endpoint) []string {
+	urls := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		urls[i] = e.url
+	}
+	return urls
+}
+
+func TestEndpointPoolOrder(t *testing.T) {
+	urls := []string{"http://a:8123", "http://b:8123", "http://c:8123", "http://a:8123"}
+
+	pool, err := newEndpointPool(urls, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a:8123", "http://b:8123", "http://c:8123"}, endpointURLs(pool.order()))
+	pool.endpoints[0].markFailed()
+	require.Equal(t, []string{"http://b:8123", "http://c:8123", "http://a:8123"}, endpointURLs(pool.order()))
+	pool.endpoints[0].markResponded(time.Millisecond)
+	require.Equal(t, "http://a:8123", pool.order()[0].url)
+
+	pool, err = newEndpointPool(urls, LoadBalancingRoundRobin)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a:8123", "http://b:8123", "http://c:8123"}, endpointURLs(pool.order()))
+	require.Equal(t, []string{"http://b:8123", "http://c:8123", "http://a:8123"}, endpointURLs(pool.order()))
+	pool.endpoints[2].markFailed()
+	require.Equal(t, []string{"http://a:8123", "http://b:8123", "http://c:8123"}, endpointURLs(pool.order()))
+
+	pool, err = newEndpointPool(urls, LoadBalancingLeastLatency)
+	require.NoError(t, err)
+	pool.endpoints[0].markResponded(30 * time.Millisecond)
+	pool.endpoints[1].markResponded(10 * time.Millisecond)
+	require.Equal(t, []string{"http://c:8123", "http://b:8123", "http://a:8123"}, endpointURLs(pool.order()))
+
+	pool, err = newEndpointPool(urls, LoadBalancingRandom)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"http://a:8123", "http://b:8123", "http://c:8123"}, endpointURLs(pool.order()))
+
+	_, err = newEndpointPool(urls, "fastest")
+	require.Error(t, err)
+}
+
+func TestIsIdempotentQuery(t *testing.T) {
+	require.True(t, isIdempotentQuery("SELECT 1 FORMAT JSON"))
+	require.True(t, isIdempotentQuery("  with x AS (SELECT 1) SELECT * FROM x"))
+	require.True(t, isIdempotentQuery("/* dashboard */\n-- panel\n(SELECT 1) UNION ALL (SELECT 2)"))
+	require.True(t, isIdempotentQuery("DESCRIBE (SELECT 1) FORMAT JSON"))
+	require.False(t, isIdempotentQuery("INSERT INTO t SELECT 1"))
+	require.False(t, isIdempotentQuery("KILL QUERY WHERE query_id = 'a' ASYNC FORMAT JSON"))
+	require.False(t, isIdempotentQuery("SELECTED"))
+}
+
+func endpointsTestClient(pool *endpointPool, maxRetries int) *ClickHouseClient {
+	return &ClickHouseClient{settings: &DatasourceSettings{
+		HTTPClient: http.DefaultClient,
+		MaxRetries: maxRetries,
+		endpoints:  pool,
+	}}
+}
+
+func TestClientFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"meta":[{"name":"1","type":"UInt8"}],"data":[{"1":1}]}`))
+	}))
+	defer up.Close()
+
+	pool, err := newEndpointPool([]string{down.URL, up.URL}, LoadBalancingFirstHealthy)
+	require.NoError(t, err)
+	client := endpointsTestClient(pool, 0)
+
+	resp, err := client.Query(context.Background(), DefaultQuery, nil, "")
+	require.NoError(t, err)
+	resp.close()
+	require.Equal(t, []string{up.URL, down.URL}, endpointURLs(pool.order()))
+
+	_, err = client.Query(context.Background(), "INSERT INTO t VALUES (1)", nil, "")
+	require.NoError(t, err)
+}
+
+func TestClientRetriesErrorCodes(t *testing.T) {
+	var requests, failures atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures.Load() {
+			w.Header().Set("X-ClickHouse-Exception-Code", "202")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("Code: 202. DB::Exception: Too many simultaneous queries. Maximum: 100. (TOO_MANY_SIMULTANEOUS_QUERIES)"))
+			return
+		}
+		// retries are sent with new query_id
+		if r.URL.Query().Get("query_id") == "grafana-A" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"meta":[{"name":"1","type":"UInt8"}],"data":[{"1":1}]}`))
+	}))
+	defer server.Close()
+
+	pool, err := newEndpointPool([]string{server.URL}, "")
+	require.NoError(t, err)
+
+	// the only endpoint with default settings is retried once
+	failures.Store(1)
+	resp, err := endpointsTestClient(pool, 0).Query(context.Background(), DefaultQuery, nil, "grafana-A")
+	require.NoError(t, err)
+	resp.close()
+	require.Equal(t, int32(2), requests.Load())
+
+	requests.Store(0)
+	failures.Store(2)
+	_, err = endpointsTestClient(pool, 0).Query(context.Background(), DefaultQuery, nil, "grafana-A")
+	var chErr *ClickHouseError
+	require.ErrorAs(t, err, &chErr)
+	require.Equal(t, 202, chErr.Code)
+	require.Equal(t, int32(2), requests.Load())
+	// TOO_MANY_SIMULTANEOUS_QUERIES doesn't mean the endpoint is down
+	healthy, _ := pool.endpoints[0].state()
+	require.True(t, healthy)
+
+	requests.Store(0)
+	resp, err = endpointsTestClient(pool, 2).Query(context.Background(), DefaultQuery, nil, "grafana-A")
+	require.NoError(t, err)
+	resp.close()
+	require.Equal(t, int32(3), requests.Load())
+}
+
+func TestNewDatasourceSettingsEndpoints(t *testing.T) {
+	instance, err := NewDatasourceSettings(context.Background(), backend.DataSourceInstanceSettings{
+		URL:      "http://a:8123",
+		JSONData: []byte(`{"endpoints":["http://b:8123"],"loadBalancing":"round_robin"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a:8123", "http://b:8123"}, endpointURLs(instance.(*DatasourceSettings).endpoints.endpoints))
+
+	_, err = NewDatasourceSettings(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"loadBalancing":"fastest"}`),
+	})
+	require.Error(t, err)
+}
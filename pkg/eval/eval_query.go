@@ -1,6 +1,7 @@
 package eval
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/dlclark/regexp2"
 	"math"
@@ -33,19 +34,25 @@ var intervalMsMacroRegexp = regexp.MustCompile(`\$__interval_ms\b`)
 type EvalQuery struct {
 	RefId                  string `json:"refId"`
 	RuleUid                string
-	RawQuery               bool   `json:"rawQuery"`
-	Query                  string `json:"query"`
-	DateTimeCol            string `json:"dateTimeColDataType"`
-	DateCol                string `json:"dateColDataType"`
-	DateTimeType           string `json:"dateTimeType"`
-	Extrapolate            bool   `json:"extrapolate"`
-	SkipComments           bool   `json:"skip_comments"`
-	AddMetadata            bool   `json:"add_metadata"`
-	UseWindowFuncForMacros bool   `json:"useWindowFuncForMacros"`
-	Format                 string `json:"format"`
-	Round                  string `json:"round"`
-	IntervalFactor         int    `json:"intervalFactor"`
-	Interval               string `json:"interval"`
+	RawQuery               bool            `json:"rawQuery"`
+	Query                  string          `json:"query"`
+	DateTimeCol            string          `json:"dateTimeColDataType"`
+	DateCol                string          `json:"dateColDataType"`
+	DateTimeType           string          `json:"dateTimeType"`
+	Extrapolate            bool            `json:"extrapolate"`
+	SkipComments           bool            `json:"skip_comments"`
+	AddMetadata            bool            `json:"add_metadata"`
+	UseWindowFuncForMacros bool            `json:"useWindowFuncForMacros"`
+	Format                 string          `json:"format"`
+	WideFormat             bool            `json:"wideFormat"`
+	PrecisionPolicy        string          `json:"precisionPolicy"`
+	ClickHouseSettings     json.RawMessage `json:"clickhouseSettings"`
+	CacheTTL               string          `json:"cacheTTL"`
+	CacheAlignTimeRange    bool            `json:"cacheAlignTimeRange"`
+	DeltaCache             bool            `json:"deltaCache"`
+	Round                  string          `json:"round"`
+	IntervalFactor         int             `json:"intervalFactor"`
+	Interval               string          `json:"interval"`
 	IntervalSec            int
 	IntervalMs             int
 	Database               string `json:"database"`
@@ -53,8 +60,14 @@ type EvalQuery struct {
 	MaxDataPoints          int64
 	FrontendDatasource     bool   `json:"frontendDatasource"`
 	MetadataUserLogin      string `json:"metadataUserLogin"`
-	From                   time.Time
-	To                     time.Time
+	// Streaming is set for ticks of streams, so field types don't change between ticks
+	Streaming bool `json:"-"`
+	// TailColumn and TailKey are set for polls of the tail streaming mode, the query with applied macros
+	// returns rows with TailColumn greater than the TailKey literal
+	TailColumn string `json:"-"`
+	TailKey    string `json:"-"`
+	From       time.Time
+	To         time.Time
 }
 
 // Define constants for time units in milliseconds
@@ -171,12 +184,10 @@ func (q *EvalQuery) ApplyMacrosAndTimeRangeToQuery() (string, error) {
 	return query, nil
 }
 
-func (q *EvalQuery) replace(query string) (string, error) {
+// ResolveInterval sets IntervalSec and IntervalMs from Interval or from the time range and MaxDataPoints,
+// the result cache calls it before macros are applied to align the time range to $interval
+func (q *EvalQuery) ResolveInterval() error {
 	var err error
-	query = strings.Trim(query, " \xA0\t\r\n")
-	if q.DateTimeType == "" {
-		q.DateTimeType = "DATETIME"
-	}
 	/* @TODO research other data sources how they calculate MaxDataPoints on unified alerts */
 	if q.IntervalFactor == 0 {
 		q.IntervalFactor = 1
@@ -186,7 +197,7 @@ func (q *EvalQuery) replace(query string) (string, error) {
 	if q.Interval != "" {
 		intervalSeconds, intervalMs, err := parseInterval(q.Interval, q.IntervalFactor)
 		if err != nil {
-			return "", err
+			return err
 		}
 		q.IntervalSec = intervalSeconds
 		q.IntervalMs = intervalMs
@@ -205,15 +216,27 @@ func (q *EvalQuery) replace(query string) (string, error) {
 		}
 		q.IntervalSec, err = q.convertInterval(fmt.Sprintf("%fs", math.Ceil(i.Seconds())), q.IntervalFactor, false)
 		if err != nil {
-			return "", err
+			return err
 		}
 	}
 	if q.IntervalMs <= 0 {
 		q.IntervalMs, err = q.convertInterval(fmt.Sprintf("%dms", ms.Milliseconds()), q.IntervalFactor, true)
 		if err != nil {
-			return "", err
+			return err
 		}
 	}
+	return nil
+}
+
+func (q *EvalQuery) replace(query string) (string, error) {
+	var err error
+	query = strings.Trim(query, " \xA0\t\r\n")
+	if q.DateTimeType == "" {
+		q.DateTimeType = "DATETIME"
+	}
+	if err = q.ResolveInterval(); err != nil {
+		return "", err
+	}
 	scanner := NewScanner(query)
 	ast, err := scanner.ToAST()
 	if err != nil {
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Extrapolation of the first and the last partial buckets, the port of extrapolateDataPoints
+// in src/datasource/sql-series/toTimeSeries.ts, so alerts evaluated by the backend see the same numbers as panels.
+
+const (
+	// extrapolateMinDataPoints is the minimal series length which is extrapolated
+	extrapolateMinDataPoints = 10
+	// extrapolateTillNowThreshold detects time ranges which end at "now", the backend receives
+	// only resolved time range, so the end close enough to the current time is treated as "now"
+	extrapolateTillNowThreshold = time.Minute
+)
+
+// extrapolateFrames extrapolates numeric value fields of time series frames built by toFramesWithTimeStamp
+func extrapolateFrames(frames data.Frames, query *Query) {
+	tillNow := time.Since(query.To) < extrapolateTillNowThreshold
+	for _, frame := range frames {
+		if len(frame.Fields) != 2 || frame.Fields[0].Type() != data.FieldTypeTime {
+			continue
+		}
+		switch frame.Fields[1].Type() {
+		case data.FieldTypeFloat64, data.FieldTypeNullableFloat64:
+			extrapolateDataPoints(frame.Fields[0], frame.Fields[1], query.From, query.To, tillNow)
+		}
+	}
+}
+
+func extrapolatedValue(field *data.Field, i int) (float64, bool) {
+	switch v := field.At(i).(type) {
+	case float64:
+		return v, true
+	case *float64:
+		if v != nil {
+			return *v, true
+		}
+	}
+	return 0, false
+}
+
+func setExtrapolatedValue(field *data.Field, i int, value float64) {
+	if field.Type() == data.FieldTypeNullableFloat64 {
+		field.Set(i, &value)
+		return
+	}
+	field.Set(i, value)
+}
+
+// extrapolateNeighbourValue predicts the value next to the neighbour by 10% of the neighbours difference
+func extrapolateNeighbourValue(neighbour, next float64) (float64, bool) {
+	diff := math.Mod((neighbour-next)/neighbour*0.1, 1)
+	if math.IsNaN(diff) {
+		diff = 0
+	}
+	value := neighbour * (1 + diff)
+	return value, !math.IsNaN(value)
+}
+
+func extrapolateDataPoints(timeField, valueField *data.Field, from, to time.Time, tillNow bool) {
+	l := valueField.Len()
+	if l < extrapolateMinDataPoints {
+		return
+	}
+	first, firstOk := extrapolatedValue(valueField, 0)
+	if !tillNow && (!firstOk || first != 0) {
+		return
+	}
+
+	firstTime := timeField.At(0).(time.Time)
+	lastTime := timeField.At(l - 1).(time.Time)
+	// Duration between first/last samples and boundary of range.
+	durationToStart := firstTime.Sub(from).Seconds()
+	durationToEnd := to.Sub(lastTime).Seconds()
+	// If the first/last samples are close to the boundaries of the range, extrapolate the result.
+	averageDurationBetweenSamples := lastTime.Sub(firstTime).Seconds() / float64(l-1)
+
+	// close to left border and value is 0 because of runningDifference function
+	if durationToStart < averageDurationBetweenSamples && firstOk && first == 0 {
+		neighbour, neighbourOk := extrapolatedValue(valueField, 1)
+		next, nextOk := extrapolatedValue(valueField, 2)
+		if neighbourOk && nextOk {
+			if value, ok := extrapolateNeighbourValue(neighbour, next); ok {
+				setExtrapolatedValue(valueField, 0, value)
+			}
+		}
+	}
+
+	if durationToEnd < averageDurationBetweenSamples {
+		neighbour, neighbourOk := extrapolatedValue(valueField, l-2)
+		next, nextOk := extrapolatedValue(valueField, l-3)
+		if neighbourOk && nextOk {
+			if value, ok := extrapolateNeighbourValue(neighbour, next); ok {
+				setExtrapolatedValue(valueField, l-1, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+// mirrors "should extrapolate data points when required" in src/spec/sql_series_specs.jest.ts
+func TestExtrapolateDataPoints(t *testing.T) {
+	newFields := func(timestamps []int64, values []float64) (*data.Field, *data.Field) {
+		times := make([]time.Time, len(timestamps))
+		for i, ts := range timestamps {
+			times[i] = time.UnixMilli(ts)
+		}
+		return data.NewField("time", nil, times), data.NewField("value", nil, values)
+	}
+
+	timeField, valueField := newFields(
+		[]int64{1736332351828, 1736332336828, 1736332321828, 1736332306828, 1736332291828, 1736332276828, 1736332261828, 1736332246828, 1736332231828, 1736332216828},
+		[]float64{32, 34, 36, 38, 40, 42, 44, 46, 48, 50},
+	)
+	extrapolateDataPoints(timeField, valueField, time.Unix(0, 0), time.Unix(1000, 0), true)
+	require.InDelta(t, 48.2, valueField.At(9), 1e-9)
+	require.Equal(t, float64(48), valueField.At(8))
+	require.Equal(t, float64(32), valueField.At(0))
+
+	timeField, valueField = newFields([]int64{1736332580592, 1736332550592, 1736332520592}, []float64{52, 54, 56})
+	extrapolateDataPoints(timeField, valueField, time.Unix(0, 0), time.Unix(1000, 0), true)
+	require.Equal(t, float64(56), valueField.At(2))
+}
+
+func TestToFramesWithTimeStampExtrapolate(t *testing.T) {
+	from := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	to := from.Add(9*time.Minute + 30*time.Second)
+	r := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "event_time", Type: "DateTime('UTC')"},
+			{Name: "rate", Type: "Float64"},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		value := float64(100 + i*10)
+		// runningDifference returns 0 for the first row
+		if i == 0 {
+			value = 0
+		}
+		r.Data = append(r.Data, map[string]interface{}{
+			"event_time": from.Add(time.Duration(i) * time.Minute).Format("2006-01-02 15:04:05"),
+			"rate":       value,
+		})
+	}
+	fetchTZ := func(ctx context.Context) *time.Location { return time.UTC }
+
+	frames, err := r.toFrames(&Query{RefId: "A", From: from, To: to}, fetchTZ)
+	require.NoError(t, err)
+	require.Equal(t, float64(0), frames[0].Fields[1].At(0))
+
+	frames, err = r.toFrames(&Query{RefId: "A", From: from, To: to, Extrapolate: true}, fetchTZ)
+	require.NoError(t, err)
+	require.InDelta(t, 110*(1+(110.0-120.0)/110.0*0.1), frames[0].Fields[1].At(0), 1e-9)
+	require.InDelta(t, 180*(1+(180.0-170.0)/180.0*0.1), frames[0].Fields[1].At(9), 1e-9)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Backend implementation of the "flamegraph" query format, see src/datasource/sql-series/toFlamegraph.ts
+// and https://grafana.com/docs/grafana/latest/panels-visualizations/visualizations/flame-graph/#data-api
+//
+// Two kinds of result sets are supported:
+//   - rows already in nested set form with label, level, value and self columns, ordered by stack trace and level,
+//     they're converted exactly as the frontend does;
+//   - stack arrays, an Array(String) column in system.trace_log order (the innermost frame first) plus optional
+//     numeric count column (value or the first numeric one), the stacks are merged into a tree here.
+
+// QueryFormatFlamegraph is the value of Query.Format which requests flamegraph frames
+const QueryFormatFlamegraph = "flamegraph"
+
+const flamegraphRootLabel = "all"
+
+type flamegraphFields struct {
+	label *data.Field
+	level *data.Field
+	value *data.Field
+	self  *data.Field
+}
+
+func newFlamegraphFields() *flamegraphFields {
+	return &flamegraphFields{
+		label: data.NewField("label", nil, []string{}),
+		level: data.NewField("level", nil, []float64{}),
+		value: data.NewField("value", nil, []float64{}),
+		self:  data.NewField("self", nil, []float64{}),
+	}
+}
+
+func (f *flamegraphFields) append(label string, level, value, self float64) {
+	f.label.Append(label)
+	f.level.Append(level)
+	f.value.Append(value)
+	f.self.Append(self)
+}
+
+func (f *flamegraphFields) frames(refID string) data.Frames {
+	frame := data.NewFrame("", f.label, f.level, f.value, f.self)
+	frame.RefID = refID
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeFlameGraph}
+	return data.Frames{frame}
+}
+
+func (r *Response) toFramesFlamegraph(query *Query) (data.Frames, error) {
+	for _, field := range r.Meta {
+		if isFlamegraphStackType(field.Type) {
+			return r.toFramesFlamegraphStacks(query, field.Name)
+		}
+	}
+
+	// nested set rows, level 0 rows are replaced by the root frame which value is the sum of level 1 values
+	fields := newFlamegraphFields()
+	fields.append(flamegraphRootLabel, 0, 0, 0)
+	rootValue := 0.0
+	err := r.forEachRow(func(row map[string]interface{}) error {
+		level := flamegraphNumber(row["level"])
+		if level == 0 {
+			return nil
+		}
+		value := flamegraphNumber(row["value"])
+		if level == 1 {
+			rootValue += value
+		}
+		fields.append(traceString(row["label"]), level, value, flamegraphNumber(row["self"]))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fields.value.Set(0, rootValue)
+	return fields.frames(query.RefId), nil
+}
+
+func isFlamegraphStackType(fieldType string) bool {
+	fieldType, _ = normalizeArrowType(fieldType)
+	name, args := unwrapType(fieldType)
+	if name != "Array" {
+		return false
+	}
+	elementType, _ := normalizeArrowType(args)
+	return elementType == "String"
+}
+
+type flamegraphNode struct {
+	label    string
+	value    float64
+	self     float64
+	children map[string]*flamegraphNode
+}
+
+func (n *flamegraphNode) child(label string) *flamegraphNode {
+	if n.children == nil {
+		n.children = map[string]*flamegraphNode{}
+	}
+	child, exists := n.children[label]
+	if !exists {
+		child = &flamegraphNode{label: label}
+		n.children[label] = child
+	}
+	return child
+}
+
+// appendTo writes the node and its children in depth first order, children are ordered by label
+func (n *flamegraphNode) appendTo(fields *flamegraphFields, level int) {
+	fields.append(n.label, float64(level), n.value, n.self)
+	labels := make([]string, 0, len(n.children))
+	for label := range n.children {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		n.children[label].appendTo(fields, level+1)
+	}
+}
+
+func (r *Response) toFramesFlamegraphStacks(query *Query, stackField string) (data.Frames, error) {
+	countField := ""
+	if meta := r.metaByName("value"); meta != nil {
+		countField = meta.Name
+	} else {
+		for _, meta := range r.Meta {
+			if logsFieldKindByType(meta.Type, -1) == logsKindNumber {
+				countField = meta.Name
+				break
+			}
+		}
+	}
+
+	root := &flamegraphNode{label: flamegraphRootLabel}
+	err := r.forEachRow(func(row map[string]interface{}) error {
+		stack, _ := row[stackField].([]interface{})
+		if len(stack) == 0 {
+			return nil
+		}
+		count := 1.0
+		if countField != "" {
+			count = flamegraphNumber(row[countField])
+		}
+		node := root
+		node.value += count
+		for i := len(stack) - 1; i >= 0; i-- {
+			node = node.child(traceString(stack[i]))
+			node.value += count
+		}
+		node.self += count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fields := newFlamegraphFields()
+	root.appendTo(fields, 0)
+	return fields.frames(query.RefId), nil
+}
+
+// flamegraphNumber follows javascript Number(): null is zero and invalid values are NaN
+func flamegraphNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return math.NaN()
+	default:
+		str := strings.TrimSpace(traceString(v))
+		if str == "" {
+			return 0
+		}
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			return f
+		}
+		return math.NaN()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func flamegraphFrameValues(t *testing.T, frame *data.Frame) ([]string, []float64, []float64, []float64) {
+	require.Equal(t, data.VisTypeFlameGraph, string(frame.Meta.PreferredVisualization))
+	labels := make([]string, frame.Rows())
+	levels := make([]float64, frame.Rows())
+	values := make([]float64, frame.Rows())
+	selfs := make([]float64, frame.Rows())
+	for i := 0; i < frame.Rows(); i++ {
+		labels[i] = frame.Fields[0].At(i).(string)
+		levels[i] = frame.Fields[1].At(i).(float64)
+		values[i] = frame.Fields[2].At(i).(float64)
+		selfs[i] = frame.Fields[3].At(i).(float64)
+	}
+	return labels, levels, values, selfs
+}
+
+var flamegraphNestedSetMeta = []*FieldMeta{
+	{Name: "label", Type: "String"},
+	{Name: "level", Type: "UInt64"},
+	{Name: "value", Type: "UInt64"},
+	{Name: "self", Type: "UInt64"},
+}
+
+// mirrors src/spec/sql_series_specs.jest.ts
+func TestToFramesFlamegraphNestedSet(t *testing.T) {
+	r := &Response{
+		ctx:  context.Background(),
+		Meta: flamegraphNestedSetMeta,
+		Data: []map[string]interface{}{
+			{"label": "A", "level": 1.0, "value": "10", "self": 5.0},
+			{"label": "B", "level": 2.0, "value": "20", "self": 15.0},
+			{"label": "C", "level": 1.0, "value": "30", "self": 10.0},
+			// filtered out
+			{"label": "D", "level": 0.0, "value": "40", "self": 20.0},
+		},
+	}
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatFlamegraph}, nil)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	labels, levels, values, selfs := flamegraphFrameValues(t, frames[0])
+	require.Equal(t, []string{"all", "A", "B", "C"}, labels)
+	require.Equal(t, []float64{0, 1, 2, 1}, levels)
+	require.Equal(t, []float64{40, 10, 20, 30}, values)
+	require.Equal(t, []float64{0, 5, 15, 10}, selfs)
+}
+
+func TestToFramesFlamegraphEmpty(t *testing.T) {
+	r := &Response{ctx: context.Background(), Meta: flamegraphNestedSetMeta}
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatFlamegraph}, nil)
+	require.NoError(t, err)
+	labels, levels, values, selfs := flamegraphFrameValues(t, frames[0])
+	require.Equal(t, []string{"all"}, labels)
+	require.Equal(t, []float64{0}, levels)
+	require.Equal(t, []float64{0}, values)
+	require.Equal(t, []float64{0}, selfs)
+}
+
+func TestToFramesFlamegraphInvalidLevel(t *testing.T) {
+	r := &Response{
+		ctx:  context.Background(),
+		Meta: flamegraphNestedSetMeta,
+		Data: []map[string]interface{}{
+			{"label": "A", "level": "invalid", "value": "10", "self": 5.0},
+		},
+	}
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatFlamegraph}, nil)
+	require.NoError(t, err)
+	labels, levels, values, selfs := flamegraphFrameValues(t, frames[0])
+	require.Equal(t, []string{"all", "A"}, labels)
+	require.True(t, math.IsNaN(levels[1]))
+	require.Equal(t, []float64{0, 10}, values)
+	require.Equal(t, []float64{0, 5}, selfs)
+}
+
+func TestToFramesFlamegraphStacks(t *testing.T) {
+	r := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "stack", Type: "Array(LowCardinality(String))"},
+			{Name: "samples", Type: "UInt64"},
+		},
+		Data: []map[string]interface{}{
+			// the innermost frame goes first, as in system.trace_log
+			{"stack": []interface{}{"read", "query", "main"}, "samples": json.Number("3")},
+			{"stack": []interface{}{"write", "query", "main"}, "samples": json.Number("2")},
+			{"stack": []interface{}{"query", "main"}, "samples": json.Number("1")},
+			{"stack": []interface{}{"idle"}, "samples": json.Number("4")},
+			{"stack": []interface{}{}, "samples": json.Number("100")},
+		},
+	}
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatFlamegraph}, nil)
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	labels, levels, values, selfs := flamegraphFrameValues(t, frames[0])
+	require.Equal(t, []string{"all", "idle", "main", "query", "read", "write"}, labels)
+	require.Equal(t, []float64{0, 1, 1, 2, 3, 3}, levels)
+	require.Equal(t, []float64{10, 4, 6, 6, 3, 2}, values)
+	require.Equal(t, []float64{0, 4, 0, 1, 3, 2}, selfs)
+}
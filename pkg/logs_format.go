@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Backend implementation of the "logs" query format, it follows src/datasource/sql-series/toLogs.ts,
+// so alerting, public dashboards and Explore get the same log frame as the frontend builds.
+
+const (
+	// QueryFormatLogs is the value of Query.Format which requests log frames
+	QueryFormatLogs = "logs"
+
+	logsMessageField = "content"
+)
+
+// logsReservedFields are never used as labels, they become severity and id fields
+var logsReservedFields = []string{"severity", "level", "id"}
+
+type logsFieldKind int
+
+const (
+	logsKindString logsFieldKind = iota
+	logsKindNumber
+	logsKindTime
+	logsKindOther
+)
+
+// logsFieldKindByType mirrors _toFieldType of toLogs.ts, the first UInt column is treated as timestamp
+func logsFieldKindByType(fieldType string, idx int) logsFieldKind {
+	fieldType, _ = normalizeArrowType(fieldType)
+	switch {
+	case strings.HasPrefix(fieldType, "Date"):
+		return logsKindTime
+	case idx == 0 && strings.HasPrefix(fieldType, "UInt"):
+		return logsKindTime
+	case strings.HasPrefix(fieldType, "UInt"), strings.HasPrefix(fieldType, "Int"),
+		strings.HasPrefix(fieldType, "Float"), strings.HasPrefix(fieldType, "Decimal"):
+		return logsKindNumber
+	case strings.HasPrefix(fieldType, "IPv"):
+		return logsKindOther
+	default:
+		return logsKindString
+	}
+}
+
+// logsMessageFieldIdx returns "content" column or the first string column
+func (r *Response) logsMessageFieldIdx() (int, bool) {
+	for idx, field := range r.Meta {
+		if field.Name == logsMessageField {
+			return idx, true
+		}
+	}
+	for idx, field := range r.Meta {
+		if logsFieldKindByType(field.Type, -1) == logsKindString {
+			return idx, true
+		}
+	}
+	return -1, false
+}
+
+func (r *Response) metaByName(fieldName string) *FieldMeta {
+	for _, field := range r.Meta {
+		if field.Name == fieldName {
+			return field
+		}
+	}
+	return nil
+}
+
+// toFramesLogs builds a single log frame with timestamp, severity, body, labels and id fields
+func (r *Response) toFramesLogs(query *Query, fetchTZ FetchTZFunc) (data.Frames, error) {
+	messageIdx, hasMessage := r.logsMessageFieldIdx()
+	// Grafana requires at least one text field to show logs
+	if !hasMessage {
+		return data.Frames{}, nil
+	}
+	timeZonesMap, _ := r.analyzeResponseMeta(fetchTZ)
+
+	timestampIdx := -1
+	var labelFields []string
+	for idx, field := range r.Meta {
+		kind := logsFieldKindByType(field.Type, idx)
+		if kind == logsKindTime && timestampIdx < 0 {
+			timestampIdx = idx
+		}
+		if (kind == logsKindNumber || kind == logsKindString) && idx != messageIdx && !slices.Contains(logsReservedFields, field.Name) {
+			labelFields = append(labelFields, field.Name)
+		}
+	}
+
+	var fields []*data.Field
+	var appenders []func(row map[string]interface{})
+
+	if timestampIdx >= 0 {
+		timestampMeta := r.Meta[timestampIdx]
+		if strings.Contains(timestampMeta.Type, "Date") {
+			field := NewDataFieldByType("timestamp", timestampMeta.Type)
+			fields = append(fields, field)
+			appenders = append(appenders, func(row map[string]interface{}) {
+				field.Append(ParseValue(timestampMeta.Name, timestampMeta.Type, timeZonesMap[timestampMeta.Name], row[timestampMeta.Name], false))
+			})
+		} else {
+			field := newTimeField("timestamp", false)
+			fields = append(fields, field)
+			appenders = append(appenders, func(row map[string]interface{}) {
+				field.Append(parseTimestampValue(row[timestampMeta.Name], false))
+			})
+		}
+	}
+
+	addField := func(name string, meta *FieldMeta) *data.Field {
+		field := NewDataFieldByType(name, meta.Type)
+		fields = append(fields, field)
+		appenders = append(appenders, func(row map[string]interface{}) {
+			field.Append(ParseValue(meta.Name, meta.Type, timeZonesMap[meta.Name], row[meta.Name], false))
+		})
+		return field
+	}
+
+	severityMeta := r.metaByName("level")
+	if severityMeta == nil {
+		severityMeta = r.metaByName("severity")
+	}
+	if severityMeta != nil {
+		addField("severity", severityMeta)
+	}
+
+	body := addField("body", r.Meta[messageIdx])
+	body.Config = (&data.FieldConfig{}).SetFilterable(false)
+
+	if len(labelFields) > 0 {
+		field := data.NewField("labels", nil, []json.RawMessage{})
+		fields = append(fields, field)
+		appenders = append(appenders, func(row map[string]interface{}) {
+			field.Append(logsLabelsValue(row, labelFields))
+		})
+	}
+
+	if idMeta := r.metaByName("id"); idMeta != nil {
+		addField("id", idMeta)
+	}
+
+	rowsCount := 0
+	err := r.forEachRow(func(row map[string]interface{}) error {
+		rowsCount++
+		for _, appendValue := range appenders {
+			appendValue(row)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rowsCount == 0 {
+		return data.Frames{}, nil
+	}
+
+	frame := data.NewFrame("", fields...)
+	frame.RefID = query.RefId
+	frame.Meta = &data.FrameMeta{
+		Type:                   data.FrameTypeLogLines,
+		PreferredVisualization: data.VisTypeLogs,
+	}
+	return data.Frames{frame}, nil
+}
+
+// logsLabelsValue follows transformObject of toLogs.ts: objects are flattened
+// into key['nested'] labels, arrays and deeper objects are kept as JSON strings
+func logsLabelsValue(row map[string]interface{}, labelFields []string) json.RawMessage {
+	labels := map[string]interface{}{}
+	for _, fieldName := range labelFields {
+		switch value := row[fieldName].(type) {
+		case []interface{}:
+			labels[fieldName] = logsLabelJSONString(value)
+		case map[string]interface{}:
+			for nestedKey, nestedValue := range value {
+				key := fmt.Sprintf("%s['%s']", fieldName, nestedKey)
+				switch nestedValue.(type) {
+				case []interface{}, map[string]interface{}:
+					labels[key] = logsLabelJSONString(nestedValue)
+				default:
+					labels[key] = nestedValue
+				}
+			}
+		default:
+			labels[fieldName] = value
+		}
+	}
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return json.RawMessage("{}")
+	}
+	return labelsJSON
+}
+
+func logsLabelJSONString(value interface{}) string {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(valueJSON)
+}
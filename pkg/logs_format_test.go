@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToFramesLogs(t *testing.T) {
+	r := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "event_time", Type: "DateTime('UTC')"},
+			{Name: "level", Type: "LowCardinality(String)"},
+			{Name: "content", Type: "String"},
+			{Name: "host", Type: "String"},
+			{Name: "status", Type: "UInt16"},
+			{Name: "attrs", Type: "Map(String, String)"},
+			{Name: "ip", Type: "IPv4"},
+		},
+		Data: []map[string]interface{}{
+			{"event_time": "2024-01-15 10:00:00", "level": "info", "content": "started", "host": "web", "status": 200.0, "attrs": map[string]interface{}{"k": "v"}, "ip": "127.0.0.1"},
+			{"event_time": "2024-01-15 10:00:01", "level": "error", "content": "failed", "host": "api", "status": 500.0, "attrs": map[string]interface{}{}, "ip": "127.0.0.1"},
+		},
+	}
+
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatLogs}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+
+	frame := frames[0]
+	require.Equal(t, "A", frame.RefID)
+	require.Equal(t, data.FrameTypeLogLines, frame.Meta.Type)
+	require.Equal(t, data.VisTypeLogs, string(frame.Meta.PreferredVisualization))
+
+	names := make([]string, len(frame.Fields))
+	for i, field := range frame.Fields {
+		names[i] = field.Name
+	}
+	require.Equal(t, []string{"timestamp", "severity", "body", "labels"}, names)
+
+	require.Equal(t, time.Date(2024, 1, 15, 10, 0, 1, 0, time.UTC), frame.Fields[0].At(1))
+	require.Equal(t, "error", frame.Fields[1].At(1))
+	require.Equal(t, "started", frame.Fields[2].At(0))
+	require.False(t, *frame.Fields[2].Config.Filterable)
+	require.JSONEq(t, `{"host":"web","status":200,"attrs['k']":"v"}`, string(frame.Fields[3].At(0).(json.RawMessage)))
+	require.JSONEq(t, `{"host":"api","status":500}`, string(frame.Fields[3].At(1).(json.RawMessage)))
+}
+
+func TestToFramesLogsWithoutStringColumns(t *testing.T) {
+	r := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "t", Type: "UInt64"},
+			{Name: "value", Type: "Float64"},
+		},
+		Data: []map[string]interface{}{
+			{"t": "1705312800000", "value": 1.0},
+		},
+	}
+
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatLogs}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Empty(t, frames)
+}
+
+func TestLogsFieldKindByType(t *testing.T) {
+	require.Equal(t, logsKindTime, logsFieldKindByType("Nullable(DateTime64(3, 'UTC'))", 2))
+	require.Equal(t, logsKindTime, logsFieldKindByType("UInt64", 0))
+	require.Equal(t, logsKindNumber, logsFieldKindByType("UInt64", 1))
+	require.Equal(t, logsKindOther, logsFieldKindByType("IPv6", 1))
+	require.Equal(t, logsKindString, logsFieldKindByType("Array(String)", 1))
+}
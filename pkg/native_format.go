@@ -0,0 +1,785 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Native format decoder.
+//
+// ClickHouse "FORMAT Native" is the columnar block format used by the native TCP protocol.
+// We request it over the regular HTTP interface, so authentication, TLS, compression and
+// Grafana's secure socks proxy keep working, while numbers are transferred in binary form
+// instead of being printed as JSON text and parsed back.
+//
+// The decoder produces the same Response rows as the JSON format, so toFrames works
+// unchanged. Values are converted to the representation produced by
+// json.Decoder with UseNumber(): 64-bit and Decimal numbers become json.Number, small
+// numbers become float64, Date becomes "YYYY-MM-DD" string. DateTime and DateTime64 are
+// returned as time.Time, because the absolute instant is already known.
+//
+// Block layout (without client_protocol_version): varuint columns, varuint rows, then for
+// each column: name, type, serialization state prefix, column data.
+// See https://clickhouse.com/docs/en/interfaces/formats#native
+
+// nativeColumn decodes one column of a Native block.
+type nativeColumn interface {
+	// readPrefix reads the serialization state prefix written before column data
+	readPrefix(r *nativeReader) error
+	// read reads values of rows
+	read(r *nativeReader, rows int) ([]interface{}, error)
+}
+
+type nativeReader struct {
+	r   *bufio.Reader
+	buf [32]byte
+}
+
+func newNativeReader(r io.Reader) *nativeReader {
+	return &nativeReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+func (r *nativeReader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(r.r)
+}
+
+func (r *nativeReader) bytes(n int) ([]byte, error) {
+	if n <= len(r.buf) {
+		if _, err := io.ReadFull(r.r, r.buf[:n]); err != nil {
+			return nil, err
+		}
+		return r.buf[:n], nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (r *nativeReader) string() (string, error) {
+	n, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > math.MaxInt32 {
+		return "", fmt.Errorf("string length %d is too large", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *nativeReader) uint64() (uint64, error) {
+	b, err := r.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// nativeRowReader decodes Native blocks one by one and returns their rows.
+type nativeRowReader struct {
+	r       *nativeReader
+	meta    []*FieldMeta
+	columns [][]interface{}
+	pos     int
+}
+
+// newNativeRowReader reads the first block to get columns meta.
+func newNativeRowReader(body io.Reader) (*nativeRowReader, []*FieldMeta, error) {
+	reader := &nativeRowReader{r: newNativeReader(body)}
+	if _, err := reader.nextBlock(); err != nil {
+		return nil, nil, err
+	}
+	return reader, reader.meta, nil
+}
+
+// nextBlock reads the next block, returns false when body is over.
+func (reader *nativeRowReader) nextBlock() (bool, error) {
+	if _, err := reader.r.r.Peek(1); err == io.EOF {
+		reader.columns = nil
+		return false, nil
+	}
+	meta, columns, err := readNativeBlock(reader.r)
+	if err != nil {
+		return false, err
+	}
+	if reader.meta == nil {
+		reader.meta = meta
+	} else if len(meta) != len(reader.meta) {
+		return false, fmt.Errorf("native block contains %d columns, expected %d", len(meta), len(reader.meta))
+	}
+	reader.columns = columns
+	reader.pos = 0
+	return true, nil
+}
+
+func (reader *nativeRowReader) next(row map[string]interface{}) (bool, error) {
+	for len(reader.columns) == 0 || reader.pos >= len(reader.columns[0]) {
+		if hasBlock, err := reader.nextBlock(); err != nil || !hasBlock {
+			return false, err
+		}
+	}
+	for c, field := range reader.meta {
+		row[field.Name] = reader.columns[c][reader.pos]
+	}
+	reader.pos++
+	return true, nil
+}
+
+// readNativeBlock reads a single Native block and returns its columns meta and values.
+func readNativeBlock(r *nativeReader) ([]*FieldMeta, [][]interface{}, error) {
+	numColumns, err := r.uvarint()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read native block header: %w", err)
+	}
+	numRows, err := r.uvarint()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read native block header: %w", err)
+	}
+	if numRows > math.MaxInt32 || numColumns > math.MaxInt16 {
+		return nil, nil, fmt.Errorf("unexpected native block size columns=%d rows=%d, the response is probably not in Native format", numColumns, numRows)
+	}
+	meta := make([]*FieldMeta, 0, numColumns)
+	columns := make([][]interface{}, 0, numColumns)
+	for i := uint64(0); i < numColumns; i++ {
+		name, err := r.string()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read native column name: %w", err)
+		}
+		chType, err := r.string()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read native column %s type: %w", name, err)
+		}
+		column, err := newNativeColumn(chType)
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		if err = column.readPrefix(r); err != nil {
+			return nil, nil, fmt.Errorf("unable to read column %s %s: %w", name, chType, err)
+		}
+		values, err := column.read(r, int(numRows))
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read column %s %s: %w", name, chType, err)
+		}
+		meta = append(meta, &FieldMeta{Name: name, Type: chType})
+		columns = append(columns, values)
+	}
+	return meta, columns, nil
+}
+
+// splitTypeArgs splits "A, B(C, D), 'e,f'" into top level arguments, respecting brackets and quotes.
+func splitTypeArgs(args string) []string {
+	var result []string
+	depth := 0
+	inQuote := false
+	start := 0
+	for i := 0; i < len(args); i++ {
+		switch c := args[i]; {
+		case c == '\\' && inQuote:
+			i++
+		case c == '\'':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			result = append(result, strings.TrimSpace(args[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(args[start:]); last != "" {
+		result = append(result, last)
+	}
+	return result
+}
+
+// unwrapType returns "Name" and the arguments string of "Name(arguments)".
+func unwrapType(chType string) (string, string) {
+	chType = strings.TrimSpace(chType)
+	idx := strings.Index(chType, "(")
+	if idx < 0 || !strings.HasSuffix(chType, ")") {
+		return chType, ""
+	}
+	return chType[:idx], chType[idx+1 : len(chType)-1]
+}
+
+func newNativeColumn(chType string) (nativeColumn, error) {
+	name, args := unwrapType(chType)
+	switch name {
+	case "Bool":
+		return &nativeFixedColumn{size: 1, convert: func(b []byte) interface{} { return b[0] != 0 }}, nil
+	case "UInt8":
+		return &nativeFixedColumn{size: 1, convert: func(b []byte) interface{} { return float64(b[0]) }}, nil
+	case "UInt16":
+		return &nativeFixedColumn{size: 2, convert: func(b []byte) interface{} { return float64(binary.LittleEndian.Uint16(b)) }}, nil
+	case "UInt32":
+		return &nativeFixedColumn{size: 4, convert: func(b []byte) interface{} { return float64(binary.LittleEndian.Uint32(b)) }}, nil
+	case "UInt64":
+		return &nativeFixedColumn{size: 8, convert: func(b []byte) interface{} {
+			return json.Number(strconv.FormatUint(binary.LittleEndian.Uint64(b), 10))
+		}}, nil
+	case "Int8":
+		return &nativeFixedColumn{size: 1, convert: func(b []byte) interface{} { return float64(int8(b[0])) }}, nil
+	case "Int16":
+		return &nativeFixedColumn{size: 2, convert: func(b []byte) interface{} { return float64(int16(binary.LittleEndian.Uint16(b))) }}, nil
+	case "Int32":
+		return &nativeFixedColumn{size: 4, convert: func(b []byte) interface{} { return float64(int32(binary.LittleEndian.Uint32(b))) }}, nil
+	case "Int64", "IntervalNanosecond", "IntervalMicrosecond", "IntervalMillisecond", "IntervalSecond", "IntervalMinute",
+		"IntervalHour", "IntervalDay", "IntervalWeek", "IntervalMonth", "IntervalQuarter", "IntervalYear":
+		return &nativeFixedColumn{size: 8, convert: func(b []byte) interface{} {
+			return json.Number(strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10))
+		}}, nil
+	case "UInt128", "UInt256", "Int128", "Int256":
+		size := 16
+		if strings.HasSuffix(name, "256") {
+			size = 32
+		}
+		signed := strings.HasPrefix(name, "Int")
+		// JSON format quotes wide integers, keep the same representation
+		return &nativeFixedColumn{size: size, convert: func(b []byte) interface{} { return leBytesToBigInt(b, signed).String() }}, nil
+	case "Float32":
+		return &nativeFixedColumn{size: 4, convert: func(b []byte) interface{} {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}}, nil
+	case "Float64":
+		return &nativeFixedColumn{size: 8, convert: func(b []byte) interface{} { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }}, nil
+	case "Decimal", "Decimal32", "Decimal64", "Decimal128", "Decimal256":
+		return newNativeDecimalColumn(name, splitTypeArgs(args))
+	case "Date":
+		return &nativeFixedColumn{size: 2, convert: func(b []byte) interface{} {
+			return time.Unix(int64(binary.LittleEndian.Uint16(b))*86400, 0).UTC().Format(dateLayout)
+		}}, nil
+	case "Date32":
+		return &nativeFixedColumn{size: 4, convert: func(b []byte) interface{} {
+			return time.Unix(int64(int32(binary.LittleEndian.Uint32(b)))*86400, 0).UTC().Format(dateLayout)
+		}}, nil
+	case "DateTime":
+		return &nativeFixedColumn{size: 4, convert: func(b []byte) interface{} {
+			return time.Unix(int64(binary.LittleEndian.Uint32(b)), 0).UTC()
+		}}, nil
+	case "DateTime64":
+		typeArgs := splitTypeArgs(args)
+		if len(typeArgs) == 0 {
+			return nil, fmt.Errorf("unable to parse precision of %s", chType)
+		}
+		precision, err := strconv.Atoi(typeArgs[0])
+		if err != nil || precision < 0 || precision > 9 {
+			return nil, fmt.Errorf("unable to parse precision of %s", chType)
+		}
+		// seconds and the remainder are split before scaling, ticks in nanoseconds overflow int64 after 2262
+		scale := int64(math.Pow10(precision))
+		multiplier := int64(math.Pow10(9 - precision))
+		return &nativeFixedColumn{size: 8, convert: func(b []byte) interface{} {
+			ticks := int64(binary.LittleEndian.Uint64(b))
+			return time.Unix(ticks/scale, ticks%scale*multiplier).UTC()
+		}}, nil
+	case "UUID":
+		return &nativeFixedColumn{size: 16, convert: formatNativeUUID}, nil
+	case "IPv4":
+		return &nativeFixedColumn{size: 4, convert: func(b []byte) interface{} {
+			v := binary.LittleEndian.Uint32(b)
+			return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).String()
+		}}, nil
+	case "IPv6":
+		return &nativeFixedColumn{size: 16, convert: func(b []byte) interface{} {
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, b)
+			return ip.String()
+		}}, nil
+	case "String":
+		return &nativeStringColumn{}, nil
+	case "FixedString":
+		size, err := strconv.Atoi(strings.TrimSpace(args))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("unable to parse size of %s", chType)
+		}
+		return &nativeFixedColumn{size: size, convert: func(b []byte) interface{} { return string(b) }}, nil
+	case "Enum8", "Enum16":
+		return newNativeEnumColumn(name, args)
+	case "Nothing":
+		return &nativeFixedColumn{size: 1, convert: func([]byte) interface{} { return nil }}, nil
+	case "Nullable":
+		nested, err := newNativeColumn(args)
+		if err != nil {
+			return nil, err
+		}
+		return &nativeNullableColumn{nested: nested}, nil
+	case "LowCardinality":
+		dictionaryType := args
+		nullable := false
+		if inner, innerArgs := unwrapType(args); inner == "Nullable" {
+			dictionaryType = innerArgs
+			nullable = true
+		}
+		dictionary, err := newNativeColumn(dictionaryType)
+		if err != nil {
+			return nil, err
+		}
+		return &nativeLowCardinalityColumn{dictionary: dictionary, nullable: nullable}, nil
+	case "Array":
+		nested, err := newNativeColumn(args)
+		if err != nil {
+			return nil, err
+		}
+		return &nativeArrayColumn{nested: nested}, nil
+	case "Tuple":
+		return newNativeTupleColumn(splitTypeArgs(args))
+	case "Map":
+		typeArgs := splitTypeArgs(args)
+		if len(typeArgs) != 2 {
+			return nil, fmt.Errorf("unable to parse key and value types of %s", chType)
+		}
+		keys, err := newNativeColumn(typeArgs[0])
+		if err != nil {
+			return nil, err
+		}
+		values, err := newNativeColumn(typeArgs[1])
+		if err != nil {
+			return nil, err
+		}
+		return &nativeMapColumn{keys: keys, values: values}, nil
+	case "Point":
+		return newNativeColumn("Tuple(Float64, Float64)")
+	case "Ring", "LineString":
+		return newNativeColumn("Array(Point)")
+	case "Polygon", "MultiLineString":
+		return newNativeColumn("Array(Ring)")
+	case "MultiPolygon":
+		return newNativeColumn("Array(Polygon)")
+	case "SimpleAggregateFunction":
+		typeArgs := splitTypeArgs(args)
+		if len(typeArgs) != 2 {
+			return nil, fmt.Errorf("unable to parse %s", chType)
+		}
+		return newNativeColumn(typeArgs[1])
+	default:
+		return nil, fmt.Errorf("type %s is not supported by Native response format, switch datasource response format to JSON or cast the column in the query", chType)
+	}
+}
+
+// nativeFixedColumn decodes fixed width values.
+type nativeFixedColumn struct {
+	size    int
+	convert func(b []byte) interface{}
+}
+
+func (c *nativeFixedColumn) readPrefix(*nativeReader) error { return nil }
+
+func (c *nativeFixedColumn) read(r *nativeReader, rows int) ([]interface{}, error) {
+	values := make([]interface{}, rows)
+	for i := 0; i < rows; i++ {
+		b, err := r.bytes(c.size)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = c.convert(b)
+	}
+	return values, nil
+}
+
+type nativeStringColumn struct{}
+
+func (c *nativeStringColumn) readPrefix(*nativeReader) error { return nil }
+
+func (c *nativeStringColumn) read(r *nativeReader, rows int) ([]interface{}, error) {
+	values := make([]interface{}, rows)
+	for i := 0; i < rows; i++ {
+		s, err := r.string()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = s
+	}
+	return values, nil
+}
+
+func newNativeDecimalColumn(name string, args []string) (nativeColumn, error) {
+	precision, scale := 0, 0
+	var err error
+	switch name {
+	case "Decimal":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("unable to parse precision of %s", name)
+		}
+		if precision, err = strconv.Atoi(args[0]); err != nil {
+			return nil, fmt.Errorf("unable to parse precision of %s: %w", name, err)
+		}
+		if len(args) > 1 {
+			if scale, err = strconv.Atoi(args[1]); err != nil {
+				return nil, fmt.Errorf("unable to parse scale of %s: %w", name, err)
+			}
+		}
+	default:
+		precision = map[string]int{"Decimal32": 9, "Decimal64": 18, "Decimal128": 38, "Decimal256": 76}[name]
+		if len(args) > 0 {
+			if scale, err = strconv.Atoi(args[0]); err != nil {
+				return nil, fmt.Errorf("unable to parse scale of %s: %w", name, err)
+			}
+		}
+	}
+	size := 32
+	switch {
+	case precision <= 9:
+		size = 4
+	case precision <= 18:
+		size = 8
+	case precision <= 38:
+		size = 16
+	}
+	return &nativeFixedColumn{size: size, convert: func(b []byte) interface{} {
+		return json.Number(formatDecimal(leBytesToBigInt(b, true), scale))
+	}}, nil
+}
+
+// leBytesToBigInt converts little endian two's complement (or unsigned) integer to big.Int.
+func leBytesToBigInt(b []byte, signed bool) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	v := new(big.Int).SetBytes(be)
+	if signed && len(be) > 0 && be[0]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(be)*8)))
+	}
+	return v
+}
+
+// formatDecimal formats unscaled decimal value with scale digits after the point.
+func formatDecimal(v *big.Int, scale int) string {
+	s := new(big.Int).Abs(v).String()
+	if scale > 0 {
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if v.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// formatNativeUUID formats UUID stored as two little endian UInt64 halves.
+func formatNativeUUID(b []byte) interface{} {
+	var u [16]byte
+	for i := 0; i < 8; i++ {
+		u[i] = b[7-i]
+		u[8+i] = b[15-i]
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// parseEnumValues parses "'a' = 1, 'b' = 2" arguments of Enum8/Enum16 type into code -> name map.
+func parseEnumValues(name, args string) (map[int64]string, error) {
+	names := map[int64]string{}
+	for _, item := range splitTypeArgs(args) {
+		idx := strings.LastIndex(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("unable to parse %s value %s", name, item)
+		}
+		code, err := strconv.ParseInt(strings.TrimSpace(item[idx+1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s value %s: %w", name, item, err)
+		}
+		label := strings.TrimSpace(item[:idx])
+		label = strings.TrimSuffix(strings.TrimPrefix(label, "'"), "'")
+		names[code] = strings.ReplaceAll(label, "\\'", "'")
+	}
+	return names, nil
+}
+
+func newNativeEnumColumn(name, args string) (nativeColumn, error) {
+	names, err := parseEnumValues(name, args)
+	if err != nil {
+		return nil, err
+	}
+	if name == "Enum8" {
+		return &nativeFixedColumn{size: 1, convert: func(b []byte) interface{} { return names[int64(int8(b[0]))] }}, nil
+	}
+	return &nativeFixedColumn{size: 2, convert: func(b []byte) interface{} {
+		return names[int64(int16(binary.LittleEndian.Uint16(b)))]
+	}}, nil
+}
+
+// nativeNullableColumn reads null map (UInt8 per row) followed by nested column values.
+type nativeNullableColumn struct {
+	nested nativeColumn
+}
+
+func (c *nativeNullableColumn) readPrefix(r *nativeReader) error { return c.nested.readPrefix(r) }
+
+func (c *nativeNullableColumn) read(r *nativeReader, rows int) ([]interface{}, error) {
+	nulls := make([]byte, rows)
+	if _, err := io.ReadFull(r.r, nulls); err != nil {
+		return nil, err
+	}
+	values, err := c.nested.read(r, rows)
+	if err != nil {
+		return nil, err
+	}
+	for i, isNull := range nulls {
+		if isNull != 0 {
+			values[i] = nil
+		}
+	}
+	return values, nil
+}
+
+// nativeArrayColumn reads UInt64 cumulative offsets followed by all nested values.
+type nativeArrayColumn struct {
+	nested nativeColumn
+}
+
+func (c *nativeArrayColumn) readPrefix(r *nativeReader) error { return c.nested.readPrefix(r) }
+
+func (c *nativeArrayColumn) readOffsets(r *nativeReader, rows int) ([]uint64, error) {
+	offsets := make([]uint64, rows)
+	prev := uint64(0)
+	for i := 0; i < rows; i++ {
+		offset, err := r.uint64()
+		if err != nil {
+			return nil, err
+		}
+		if offset < prev || offset > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid array offset %d", offset)
+		}
+		offsets[i] = offset
+		prev = offset
+	}
+	return offsets, nil
+}
+
+func (c *nativeArrayColumn) read(r *nativeReader, rows int) ([]interface{}, error) {
+	offsets, err := c.readOffsets(r, rows)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	if rows > 0 {
+		total = int(offsets[rows-1])
+	}
+	nested, err := c.nested.read(r, total)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, rows)
+	start := uint64(0)
+	for i, end := range offsets {
+		values[i] = nested[start:end:end]
+		start = end
+	}
+	return values, nil
+}
+
+// nativeTupleColumn reads each element column one after another.
+type nativeTupleColumn struct {
+	names    []string
+	elements []nativeColumn
+}
+
+func newNativeTupleColumn(args []string) (nativeColumn, error) {
+	tuple := &nativeTupleColumn{}
+	named := true
+	for _, arg := range args {
+		elementType := arg
+		elementName := ""
+		// named tuple element "name Type", type names never contain spaces before the first bracket
+		if idx := strings.IndexAny(arg, " ("); idx > 0 && arg[idx] == ' ' {
+			elementName = strings.Trim(arg[:idx], "`\"")
+			elementType = strings.TrimSpace(arg[idx+1:])
+			if _, err := newNativeColumn(elementType); err != nil {
+				elementName, elementType = "", arg
+			}
+		}
+		if elementName == "" {
+			named = false
+		}
+		element, err := newNativeColumn(elementType)
+		if err != nil {
+			return nil, err
+		}
+		tuple.names = append(tuple.names, elementName)
+		tuple.elements = append(tuple.elements, element)
+	}
+	if !named {
+		tuple.names = nil
+	}
+	return tuple, nil
+}
+
+func (c *nativeTupleColumn) readPrefix(r *nativeReader) error {
+	for _, element := range c.elements {
+		if err := element.readPrefix(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *nativeTupleColumn) read(r *nativeReader, rows int) ([]interface{}, error) {
+	columns := make([][]interface{}, len(c.elements))
+	for i, element := range c.elements {
+		values, err := element.read(r, rows)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = values
+	}
+	values := make([]interface{}, rows)
+	for row := 0; row < rows; row++ {
+		// named tuples are returned as objects, the same as output_format_json_named_tuples_as_objects does
+		if c.names != nil {
+			obj := make(map[string]interface{}, len(c.names))
+			for i, name := range c.names {
+				obj[name] = columns[i][row]
+			}
+			values[row] = obj
+			continue
+		}
+		tuple := make([]interface{}, len(columns))
+		for i := range columns {
+			tuple[i] = columns[i][row]
+		}
+		values[row] = tuple
+	}
+	return values, nil
+}
+
+// nativeMapColumn is serialized as Array(Tuple(K, V)).
+type nativeMapColumn struct {
+	keys   nativeColumn
+	values nativeColumn
+}
+
+func (c *nativeMapColumn) readPrefix(r *nativeReader) error {
+	if err := c.keys.readPrefix(r); err != nil {
+		return err
+	}
+	return c.values.readPrefix(r)
+}
+
+func (c *nativeMapColumn) read(r *nativeReader, rows int) ([]interface{}, error) {
+	offsets, err := (&nativeArrayColumn{}).readOffsets(r, rows)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	if rows > 0 {
+		total = int(offsets[rows-1])
+	}
+	keys, err := c.keys.read(r, total)
+	if err != nil {
+		return nil, err
+	}
+	values, err := c.values.read(r, total)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, rows)
+	start := uint64(0)
+	for i, end := range offsets {
+		m := make(map[string]interface{}, end-start)
+		for j := start; j < end; j++ {
+			m[fmt.Sprintf("%v", keys[j])] = values[j]
+		}
+		result[i] = m
+		start = end
+	}
+	return result, nil
+}
+
+const (
+	lowCardinalitySharedDictionariesWithAdditionalKeys = 1
+	lowCardinalityKeyTypeMask                          = 0xff
+	lowCardinalityHasAdditionalKeysBit                 = 1 << 9
+	lowCardinalityNeedGlobalDictionaryBit              = 1 << 8
+)
+
+// nativeLowCardinalityColumn reads dictionary encoded column:
+// UInt64 version prefix, then UInt64 flags, UInt64 dictionary size, dictionary values, UInt64 rows and keys.
+// For LowCardinality(Nullable(T)) dictionary has type T and key 0 means NULL.
+type nativeLowCardinalityColumn struct {
+	dictionary nativeColumn
+	nullable   bool
+}
+
+func (c *nativeLowCardinalityColumn) readPrefix(r *nativeReader) error {
+	version, err := r.uint64()
+	if err != nil {
+		return err
+	}
+	if version != lowCardinalitySharedDictionariesWithAdditionalKeys {
+		return fmt.Errorf("unsupported LowCardinality serialization version %d", version)
+	}
+	return c.dictionary.readPrefix(r)
+}
+
+func (c *nativeLowCardinalityColumn) read(r *nativeReader, rows int) ([]interface{}, error) {
+	if rows == 0 {
+		return []interface{}{}, nil
+	}
+	flags, err := r.uint64()
+	if err != nil {
+		return nil, err
+	}
+	if flags&lowCardinalityNeedGlobalDictionaryBit != 0 || flags&lowCardinalityHasAdditionalKeysBit == 0 {
+		return nil, fmt.Errorf("unsupported LowCardinality flags %x", flags)
+	}
+	dictionarySize, err := r.uint64()
+	if err != nil {
+		return nil, err
+	}
+	if dictionarySize > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid LowCardinality dictionary size %d", dictionarySize)
+	}
+	dictionary, err := c.dictionary.read(r, int(dictionarySize))
+	if err != nil {
+		return nil, err
+	}
+	keysCount, err := r.uint64()
+	if err != nil {
+		return nil, err
+	}
+	if keysCount != uint64(rows) {
+		return nil, fmt.Errorf("LowCardinality keys count %d doesn't match rows %d", keysCount, rows)
+	}
+	keySize := 1 << (flags & lowCardinalityKeyTypeMask)
+	if keySize > 8 {
+		return nil, fmt.Errorf("unsupported LowCardinality key type %d", flags&lowCardinalityKeyTypeMask)
+	}
+	values := make([]interface{}, rows)
+	for i := 0; i < rows; i++ {
+		b, err := r.bytes(keySize)
+		if err != nil {
+			return nil, err
+		}
+		var key uint64
+		for j := keySize - 1; j >= 0; j-- {
+			key = key<<8 | uint64(b[j])
+		}
+		if key >= dictionarySize {
+			return nil, errors.New("LowCardinality key is out of dictionary range")
+		}
+		if c.nullable && key == 0 {
+			continue
+		}
+		values[i] = dictionary[key]
+	}
+	return values, nil
+}
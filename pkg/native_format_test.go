@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type nativeBlockWriter struct {
+	bytes.Buffer
+}
+
+func (w *nativeBlockWriter) uvarint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func (w *nativeBlockWriter) string(s string) {
+	w.uvarint(uint64(len(s)))
+	w.WriteString(s)
+}
+
+func (w *nativeBlockWriter) le(v interface{}) {
+	_ = binary.Write(w, binary.LittleEndian, v)
+}
+
+func TestReadNativeResponse(t *testing.T) {
+	w := &nativeBlockWriter{}
+	w.uvarint(6) // columns
+	w.uvarint(2) // rows
+
+	w.string("t")
+	w.string("DateTime64(3, 'UTC')")
+	w.le([]int64{1705312800123, 1705312801456})
+
+	w.string("host")
+	w.string("LowCardinality(Nullable(String))")
+	w.le(uint64(1))      // LowCardinality version
+	w.le(uint64(1 << 9)) // flags: has additional keys, UInt8 keys
+	w.le(uint64(2))      // dictionary size
+	w.string("")         // null placeholder
+	w.string("web")      // dictionary value
+	w.le(uint64(2))      // keys count
+	w.le([]uint8{1, 0})
+
+	w.string("requests")
+	w.string("UInt64")
+	w.le([]uint64{150, math.MaxUint64})
+
+	w.string("ratio")
+	w.string("Nullable(Float64)")
+	w.le([]uint8{0, 1})
+	w.le([]float64{0.5, 0})
+
+	w.string("price")
+	w.string("Decimal(10, 2)")
+	w.le([]int64{12345, -5})
+
+	w.string("tags")
+	w.string("Array(String)")
+	w.le([]uint64{2, 2})
+	w.string("a")
+	w.string("b")
+
+	body := w.Bytes()
+	resp, err := newStreamResponse(context.Background(), ResponseFormatNative, bytes.NewReader(body), nil)
+	require.NoError(t, err)
+	require.Len(t, resp.Meta, 6)
+	require.Equal(t, "LowCardinality(Nullable(String))", resp.Meta[1].Type)
+
+	var rows []map[string]interface{}
+	require.NoError(t, resp.forEachRow(func(row map[string]interface{}) error {
+		rowCopy := map[string]interface{}{}
+		for k, v := range row {
+			rowCopy[k] = v
+		}
+		rows = append(rows, rowCopy)
+		return nil
+	}))
+	require.Len(t, rows, 2)
+
+	require.Equal(t, time.UnixMilli(1705312800123).UTC(), rows[0]["t"])
+	require.Equal(t, "web", rows[0]["host"])
+	require.Nil(t, rows[1]["host"])
+	require.Equal(t, json.Number("150"), rows[0]["requests"])
+	require.Equal(t, json.Number("18446744073709551615"), rows[1]["requests"])
+	require.Equal(t, 0.5, rows[0]["ratio"])
+	require.Nil(t, rows[1]["ratio"])
+	require.Equal(t, json.Number("123.45"), rows[0]["price"])
+	require.Equal(t, json.Number("-0.05"), rows[1]["price"])
+	require.Equal(t, []interface{}{"a", "b"}, rows[0]["tags"])
+	require.Equal(t, []interface{}{}, rows[1]["tags"])
+
+	// decoded values are accepted by frames builder as is
+	resp, err = newStreamResponse(context.Background(), ResponseFormatNative, bytes.NewReader(body), nil)
+	require.NoError(t, err)
+	frames, err := resp.toFrames(&Query{RefId: "A"}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.NotEmpty(t, frames)
+}
+
+func TestNewNativeColumnTypes(t *testing.T) {
+	testCases := []struct {
+		chType   string
+		data     []byte
+		expected interface{}
+	}{
+		{"Int8", []byte{0xff}, float64(-1)},
+		{"Bool", []byte{1}, true},
+		{"Date", []byte{0x19, 0x4d}, "2024-01-15"},
+		{"IPv4", []byte{1, 0, 0, 127}, "127.0.0.1"},
+		{"Enum8('a' = 1, 'b = c' = 2)", []byte{2}, "b = c"},
+		{"FixedString(3)", []byte("abc"), "abc"},
+		{"Int128", bytes.Repeat([]byte{0xff}, 16), "-1"},
+		{"UUID", []byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, 0x10, 0x0f, 0x0e, 0x0d, 0x0c, 0x0b, 0x0a, 0x09}, "01020304-0506-0708-090a-0b0c0d0e0f10"},
+		{"Tuple(String, UInt8)", []byte{1, 'x', 7}, []interface{}{"x", float64(7)}},
+		{"Tuple(name String, id UInt8)", []byte{1, 'x', 7}, map[string]interface{}{"name": "x", "id": float64(7)}},
+		{"Map(String, UInt8)", []byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 'k', 9}, map[string]interface{}{"k": float64(9)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.chType, func(t *testing.T) {
+			column, err := newNativeColumn(tc.chType)
+			require.NoError(t, err)
+			r := newNativeReader(bytes.NewReader(tc.data))
+			require.NoError(t, column.readPrefix(r))
+			values, err := column.read(r, 1)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, values[0])
+		})
+	}
+
+	_, err := newNativeColumn("Dynamic")
+	require.Error(t, err)
+}
+
+func TestClickHouseClientResponseFormat(t *testing.T) {
+	client := &ClickHouseClient{settings: &DatasourceSettings{ResponseFormat: ResponseFormatNative}}
+
+	format, query := client.responseFormat("SELECT 1 FORMAT JSON")
+	require.Equal(t, ResponseFormatNative, format)
+	require.Equal(t, "SELECT 1 FORMAT Native", query)
+
+	format, query = client.responseFormat("SELECT 1 FORMAT TSV")
+	require.Equal(t, ResponseFormatJSON, format)
+	require.Equal(t, "SELECT 1 FORMAT TSV", query)
+
+	client.settings.ResponseFormat = ""
+	format, query = client.responseFormat(TimeZoneQuery)
+	require.Equal(t, ResponseFormatJSON, format)
+	require.Equal(t, TimeZoneQuery, query)
+}
+
+func TestReadNativeDateTime64Precision(t *testing.T) {
+	w := &nativeBlockWriter{}
+	w.uvarint(2) // columns
+	w.uvarint(1) // rows
+
+	w.string("nanos")
+	w.string("DateTime64(9, 'UTC')")
+	w.le([]int64{1705312800123456789})
+
+	// beyond 2262 ticks in nanoseconds overflow int64
+	w.string("far")
+	w.string("DateTime64(3)")
+	w.le([]int64{10413792000001})
+
+	resp, err := newStreamResponse(context.Background(), ResponseFormatNative, bytes.NewReader(w.Bytes()), nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.forEachRow(func(row map[string]interface{}) error {
+		require.Equal(t, time.Date(2024, 1, 15, 10, 0, 0, 123456789, time.UTC), row["nanos"])
+		require.Equal(t, time.Date(2300, 1, 1, 0, 0, 0, 1000000, time.UTC), row["far"])
+		return nil
+	}))
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
+	"math/big"
+	"net"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -14,19 +16,8 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
-var datePrefix = "Date"
-var dateTimePrefix = "DateTime"
-var dateTime64Prefix = "DateTime64"
-var timeZonePrefix = "('"
-var timeZone64Separator = ","
-var dateTZPrefix = datePrefix + timeZonePrefix
-var dateTimeTZPrefix = dateTimePrefix + timeZonePrefix
-var dateTime64TZPrefix = dateTime64Prefix + timeZonePrefix
-
 var dateLayout = "2006-01-02"
 var dateTimeLayout = dateLayout + " 15:04:05"
-var dateTime64Layout3 = dateTimeLayout + ".000"
-var dateTime64Layout6 = dateTimeLayout + ".000000"
 
 type FetchTZFunc = func(ctx context.Context) *time.Location
 type Value interface{}
@@ -41,22 +32,10 @@ func ParseTimeZone(tz string) *time.Location {
 	}
 }
 
-var dateTimeTypeRE = regexp.MustCompile(`(Date\([^)]+\)|DateTime\([^)]+\)|DateTime64\([^)]+\))`)
-
+// extractTimeZoneNameFromFieldType returns the timezone argument of DateTime or DateTime64,
+// compound types like Array(DateTime64(3, 'UTC')) use the timezone of the first nested DateTime type
 func extractTimeZoneNameFromFieldType(fieldType string) string {
-	tz := ""
-	if strings.HasPrefix(fieldType, dateTZPrefix) {
-		tz = fieldType[len(dateTZPrefix)+1 : len(fieldType)-2]
-	} else if strings.HasPrefix(fieldType, dateTimeTZPrefix) {
-		tz = fieldType[len(dateTimeTZPrefix)+1 : len(fieldType)-2]
-	} else if strings.HasPrefix(fieldType, dateTime64TZPrefix) && strings.Contains(fieldType, timeZone64Separator) {
-		tz = fieldType[strings.Index(fieldType, timeZone64Separator)+3 : len(fieldType)-2]
-	} else if complexTypeRE.MatchString(fieldType) {
-		if matches := dateTimeTypeRE.FindAllStringSubmatch(fieldType, 1); len(matches) > 0 {
-			return extractTimeZoneNameFromFieldType(matches[0][1])
-		}
-	}
-	return strings.Trim(tz, " \t\v\n\r")
+	return parseClickHouseType(fieldType).timeZone()
 }
 
 func fetchTimeZoneFromFieldType(fieldType string, tzFromServer *time.Location) *time.Location {
@@ -75,12 +54,20 @@ const maxSafeInteger uint64 = 9007199254740991
 // minSafeIntegerAbs is the absolute value of JavaScript's Number.MIN_SAFE_INTEGER
 const minSafeIntegerAbs uint64 = 9007199254740991
 
+// maxSafeDecimalDigits is the number of significant decimal digits which survive the round trip through float64
+const maxSafeDecimalDigits = 15
+
 // IsValueSafeForFloat64 checks if a value can be safely represented as float64 without precision loss.
-// Returns true if the value is within JavaScript's safe integer range.
+// Returns true if the integer value is within JavaScript's safe integer range,
+// or if the Decimal value has no more significant digits than float64 keeps.
 func IsValueSafeForFloat64(value interface{}, fieldType string) bool {
 	if value == nil {
 		return true
 	}
+	// the value has been converted already, there is nothing to preserve
+	if _, isFloat := value.(float64); isFloat {
+		return true
+	}
 
 	// Get the string representation
 	var strVal string
@@ -93,16 +80,7 @@ func IsValueSafeForFloat64(value interface{}, fieldType string) bool {
 		strVal = fmt.Sprintf("%v", value)
 	}
 
-	// Normalize the type
-	normalizedType := fieldType
-	if strings.HasPrefix(normalizedType, "LowCardinality(") {
-		normalizedType = strings.TrimSuffix(strings.TrimPrefix(normalizedType, "LowCardinality("), ")")
-	}
-	if strings.HasPrefix(normalizedType, "Nullable(") {
-		normalizedType = strings.TrimSuffix(strings.TrimPrefix(normalizedType, "Nullable("), ")")
-	}
-
-	switch normalizedType {
+	switch parseClickHouseType(fieldType).name {
 	case "UInt64":
 		ui64, err := strconv.ParseUint(strVal, 10, 64)
 		if err != nil {
@@ -118,11 +96,30 @@ func IsValueSafeForFloat64(value interface{}, fieldType string) bool {
 			return uint64(-i64) <= minSafeIntegerAbs
 		}
 		return uint64(i64) <= maxSafeInteger
+	case "UInt128", "UInt256", "Int128", "Int256":
+		bigInt, ok := new(big.Int).SetString(strVal, 10)
+		if !ok {
+			return true // Can't parse, assume safe
+		}
+		return bigInt.CmpAbs(new(big.Int).SetUint64(maxSafeInteger)) <= 0
+	case "Decimal", "Decimal32", "Decimal64", "Decimal128", "Decimal256":
+		return decimalSignificantDigits(strVal) <= maxSafeDecimalDigits
 	default:
 		return true
 	}
 }
 
+// decimalSignificantDigits counts digits of the decimal number text without leading and trailing zeros
+func decimalSignificantDigits(str string) int {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, str)
+	return len(strings.Trim(digits, "0"))
+}
+
 // NewDataFieldByType creates a data field with appropriate type.
 // For UInt64/Int64, defaults to string to preserve precision.
 // Use NewDataFieldByTypeOptimized for dynamic type selection based on actual values.
@@ -131,53 +128,38 @@ func NewDataFieldByType(fieldName, fieldType string) *data.Field {
 }
 
 // NewDataFieldByTypeOptimized creates a data field with appropriate type.
-// For UInt64/Int64, uses needsStringPrecision to decide between string (true) or float64 (false).
-// This allows for optimal type selection: use float64 when all values are safe (for alerts),
-// or string when precision must be preserved (for large integers).
+// The field type follows the kind of the parsed ClickHouse type, see clickHouseFieldKind:
+//   - String, FixedString, UUID, IPv4, IPv6, Enum8, Enum16 are strings
+//   - [U]Int8-32, Float32, Float64, Interval* are float64
+//   - [U]Int64, [U]Int128, [U]Int256, Decimal* use needsStringPrecision to decide between string (true)
+//     or float64 (false). This allows for optimal type selection: use float64 when all values are safe
+//     (for alerts), or string when precision must be preserved (for large numbers), see PrecisionPolicy
+//   - UInt64, Int64 named "t" and Date, Date32, DateTime, DateTime64 are time
+//   - Bool is bool
+//   - Nothing, Variant and Dynamic are always nullable
+//   - Array, Tuple, Map, Nested, Variant, Dynamic, JSON, geo and unknown types are JSON strings
+//
+// Nullable wrapper makes the field nullable, LowCardinality doesn't matter.
 func NewDataFieldByTypeOptimized(fieldName, fieldType string, needsStringPrecision bool) *data.Field {
+	t := parseClickHouseType(fieldType)
+	isNullable := t.isNullable()
 
-	if strings.HasPrefix(fieldType, "LowCardinality") {
-		fieldType = strings.TrimSuffix(strings.TrimPrefix(fieldType, "LowCardinality("), ")")
-	}
-
-	isNullable := strings.Contains(fieldType, "Nullable")
-	fieldType = strings.TrimSuffix(strings.TrimPrefix(fieldType, "Nullable("), ")")
-
-	switch fieldType {
-	case "String", "UUID", "IPv6", "IPv4":
-		return newStringField(fieldName, isNullable)
-	case "UInt8", "UInt16", "UInt32", "Int8", "Int16", "Int32", "Float32", "Float64":
+	switch t.fieldKind(fieldName) {
+	case fieldKindFloat:
 		return newFloat64Field(fieldName, isNullable)
-	case "UInt64":
-		// This can be a time or uint64 value
-		// Assume that t is the field name used for timestamp
-		if fieldName == "t" && !isNullable {
-			return data.NewField(fieldName, nil, []time.Time{})
-		}
-
-		// Use string for precision preservation, or float64 for Grafana alert compatibility
-		// See: https://github.com/Altinity/clickhouse-grafana/issues/832
-		if needsStringPrecision {
-			return newStringField(fieldName, isNullable)
-		}
-		return newFloat64Field(fieldName, isNullable)
-	case "Int64":
+	case fieldKindWideNumber:
 		// Use string for precision preservation, or float64 for Grafana alert compatibility
 		// See: https://github.com/Altinity/clickhouse-grafana/issues/832
 		if needsStringPrecision {
 			return newStringField(fieldName, isNullable)
 		}
 		return newFloat64Field(fieldName, isNullable)
+	case fieldKindTimestamp, fieldKindTime:
+		return newTimeField(fieldName, isNullable)
+	case fieldKindBool:
+		return newBoolField(fieldName, isNullable)
 	default:
-		if strings.HasPrefix(fieldType, "Decimal") {
-			return newFloat64Field(fieldName, isNullable)
-		} else if strings.HasPrefix(fieldType, "FixedString") || strings.HasPrefix(fieldType, "Enum") {
-			return newStringField(fieldName, isNullable)
-		} else if strings.HasPrefix(fieldType, dateTime64Prefix) || strings.HasPrefix(fieldType, dateTimePrefix) || strings.HasPrefix(fieldType, datePrefix) {
-			return newTimeField(fieldName, isNullable)
-		} else {
-			return newStringField(fieldName, isNullable)
-		}
+		return newStringField(fieldName, isNullable)
 	}
 }
 
@@ -205,6 +187,14 @@ func newStringField(fieldName string, isNullable bool) *data.Field {
 	}
 }
 
+func newBoolField(fieldName string, isNullable bool) *data.Field {
+	if isNullable {
+		return data.NewField(fieldName, nil, []*bool{})
+	} else {
+		return data.NewField(fieldName, nil, []bool{})
+	}
+}
+
 func parseFloatValue(value interface{}, isNullable bool) Value {
 	if value != nil {
 		var fv float64
@@ -218,8 +208,29 @@ func parseFloatValue(value interface{}, isNullable bool) Value {
 				}
 				return 0.0
 			}
+		case string:
+			// Decimal and Interval may come quoted
+			var err error
+			fv, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				if isNullable {
+					return nil
+				}
+				return 0.0
+			}
+		case bool:
+			if v {
+				fv = 1
+			}
 		default:
-			fv = reflect.ValueOf(value).Float()
+			rv := reflect.ValueOf(value)
+			if !rv.CanConvert(reflect.TypeOf(fv)) {
+				if isNullable {
+					return nil
+				}
+				return 0.0
+			}
+			fv = rv.Convert(reflect.TypeOf(fv)).Float()
 		}
 		if isNullable {
 			return &fv
@@ -237,7 +248,15 @@ func parseFloatValue(value interface{}, isNullable bool) Value {
 
 func parseStringValue(value interface{}, isNullable bool) Value {
 	if value != nil {
-		str := reflect.ValueOf(value).String()
+		var str string
+		switch v := value.(type) {
+		case string:
+			str = v
+		case json.Number:
+			str = string(v)
+		default:
+			str = fmt.Sprintf("%v", value)
+		}
 		if isNullable {
 			return &str
 		} else {
@@ -252,24 +271,82 @@ func parseStringValue(value interface{}, isNullable bool) Value {
 	}
 }
 
-// parseMapValue parses a map value to JSON.
-func parseMapValue(value interface{}, isNullable bool) Value {
-	// Check if the value is a map
-	switch m := value.(type) {
-	case map[string]interface{}: // Check if it's a map with string keys and any type of value
-		jsonValue, err := json.Marshal(m)
+// parseTypedStringValue parses values of string kind, IPv4 and Enum may come as numbers
+// from formats which don't convert them, e.g. IPv4 as UInt32 or Enum as its code.
+func parseTypedStringValue(t *clickHouseType, value interface{}, isNullable bool) Value {
+	var code int64
+	switch v := value.(type) {
+	case json.Number:
+		i64, err := v.Int64()
 		if err != nil {
-			return nil
+			return parseStringValue(value, isNullable)
 		}
-		// Return the JSON bytes
-		return string(jsonValue)
+		code = i64
+	case float64:
+		code = int64(v)
 	default:
-		if isNullable {
-			return nil
-		} else {
-			return ""
+		return parseStringValue(value, isNullable)
+	}
+	switch t.name {
+	case "IPv4":
+		return parseStringValue(net.IPv4(byte(code>>24), byte(code>>16), byte(code>>8), byte(code)).String(), isNullable)
+	case "Enum8", "Enum16":
+		if name, ok := t.enumNames[code]; ok {
+			return parseStringValue(name, isNullable)
+		}
+	}
+	return parseStringValue(value, isNullable)
+}
+
+func parseBoolValue(value interface{}, isNullable bool) Value {
+	if value != nil {
+		var bv bool
+		var err error
+		switch v := value.(type) {
+		case bool:
+			bv = v
+		case string:
+			bv, err = strconv.ParseBool(v)
+		case json.Number:
+			var fv float64
+			fv, err = v.Float64()
+			bv = fv != 0
+		case float64:
+			bv = v != 0
+		default:
+			err = fmt.Errorf("unexpected Bool value %v", value)
 		}
+		if err == nil {
+			if isNullable {
+				return &bv
+			}
+			return bv
+		}
+	}
+	if isNullable {
+		return nil
 	}
+	return false
+}
+
+// parseCompoundValue renders values of compound and unknown types as JSON strings.
+// Variant, Dynamic and JSON may hold plain strings, they're returned as is instead of being quoted.
+func parseCompoundValue(t *clickHouseType, value interface{}, isNullable bool) Value {
+	if value == nil {
+		return parseStringValue(nil, isNullable)
+	}
+	if str, ok := value.(string); ok && t.isDynamic() {
+		return parseStringValue(str, isNullable)
+	}
+	byteValue, err := json.Marshal(value)
+	if err != nil {
+		backend.Logger.Warn(fmt.Sprintf(
+			"Unable to append value of unknown type %v because of json encoding problem: %s",
+			reflect.TypeOf(value), err,
+		))
+		return parseStringValue(nil, isNullable)
+	}
+	return parseStringValue(string(byteValue), isNullable)
 }
 
 func parseUInt64Value(value interface{}, isNullable bool) Value {
@@ -369,6 +446,21 @@ func parseInt64AsStringValue(value interface{}, isNullable bool) Value {
 	}
 }
 
+// parseNumberAsStringValue returns [U]Int128, [U]Int256 and Decimal values as strings with all digits
+func parseNumberAsStringValue(value interface{}, isNullable bool) Value {
+	switch v := value.(type) {
+	case nil:
+		if isNullable {
+			return nil
+		}
+		return "0"
+	case float64:
+		return parseStringValue(strconv.FormatFloat(v, 'f', -1, 64), isNullable)
+	default:
+		return parseStringValue(value, isNullable)
+	}
+}
+
 // parseUInt64AsFloat64Value returns UInt64 values as float64 for Grafana alert compatibility.
 // Note: precision may be lost for values > 2^53-1.
 func parseUInt64AsFloat64Value(value interface{}, isNullable bool) Value {
@@ -459,9 +551,24 @@ func parseTimestampValue(value interface{}, isNullable bool) Value {
 }
 
 func parseDateTimeValue(value interface{}, layout string, timezone *time.Location, isNullable bool) Value {
+	// binary response formats already decode DateTime values
+	if t, ok := value.(time.Time); ok {
+		if isNullable {
+			return &t
+		}
+		return t
+	}
 	if value != nil {
 		strValue := fmt.Sprintf("%v", value)
 		t, err := time.ParseInLocation(layout, strValue, timezone)
+		if err != nil && layout != dateLayout {
+			// fractional seconds of any length, time.Parse accepts them after seconds even when layout has none
+			t, err = time.ParseInLocation(dateTimeLayout, strValue, timezone)
+		}
+		if err != nil {
+			// date_time_output_format='unix_timestamp'
+			t, err = parseUnixTimestamp(value)
+		}
 
 		if err == nil {
 			if isNullable {
@@ -478,79 +585,84 @@ func parseDateTimeValue(value interface{}, layout string, timezone *time.Locatio
 	}
 }
 
+// parseUnixTimestamp parses seconds since epoch with optional fractional part up to nanoseconds,
+// digits are parsed as integers, because float64 can't keep nanoseconds of current timestamps
+func parseUnixTimestamp(value interface{}) (time.Time, error) {
+	var str string
+	switch v := value.(type) {
+	case json.Number:
+		str = string(v)
+	case string:
+		str = v
+	case float64:
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return time.Time{}, fmt.Errorf("unexpected timestamp %v", value)
+	}
+	secondsStr, fractionStr, _ := strings.Cut(str, ".")
+	seconds, err := strconv.ParseInt(secondsStr, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nanoseconds int64
+	if fractionStr != "" {
+		if len(fractionStr) > 9 {
+			fractionStr = fractionStr[:9]
+		}
+		if nanoseconds, err = strconv.ParseInt(fractionStr, 10, 64); err != nil || nanoseconds < 0 {
+			return time.Time{}, fmt.Errorf("unable to parse timestamp %s", str)
+		}
+		nanoseconds *= int64(math.Pow10(9 - len(fractionStr)))
+		if strings.HasPrefix(secondsStr, "-") {
+			nanoseconds = -nanoseconds
+		}
+	}
+	return time.Unix(seconds, nanoseconds), nil
+}
+
 // ParseValue parses a value with default behavior (string for UInt64/Int64 to preserve precision).
 func ParseValue(fieldName string, fieldType string, tz *time.Location, value interface{}, isNullable bool) Value {
 	return ParseValueOptimized(fieldName, fieldType, tz, value, isNullable, true)
 }
 
-// ParseValueOptimized parses a value with configurable precision handling for UInt64/Int64.
-// When needsStringPrecision is true, UInt64/Int64 values are returned as strings.
+// ParseValueOptimized parses a value into the Go type of the field created by NewDataFieldByTypeOptimized
+// for the same type. When needsStringPrecision is true, UInt64/Int64 values are returned as strings.
 // When needsStringPrecision is false, UInt64/Int64 values are returned as float64 (for alert compatibility).
 func ParseValueOptimized(fieldName string, fieldType string, tz *time.Location, value interface{}, isNullable bool, needsStringPrecision bool) Value {
-	if strings.HasPrefix(fieldType, "Nullable") {
-		return ParseValueOptimized(fieldName, strings.TrimSuffix(strings.TrimPrefix(fieldType, "Nullable("), ")"), tz, value, true, needsStringPrecision)
-	} else if strings.HasPrefix(fieldType, "LowCardinality") {
-		return ParseValueOptimized(fieldName, strings.TrimSuffix(strings.TrimPrefix(fieldType, "LowCardinality("), ")"), tz, value, isNullable, needsStringPrecision)
-	} else if strings.HasPrefix(fieldType, "Map(") && strings.HasSuffix(fieldType, ")") {
-		return parseMapValue(value, isNullable)
-	} else {
-		switch fieldType {
-		case "String", "UUID", "IPv4", "IPv6":
-			return parseStringValue(value, isNullable)
-		case "UInt8", "UInt16", "UInt32", "Int8", "Int16", "Int32", "Float32", "Float64":
-			return parseFloatValue(value, isNullable)
-		case "UInt64":
-			// Plugin specific corner case
-			// This can be a time or uint64 value Assume that t is the field name used for timestamp in milliseconds
-			if fieldName == "t" {
-				return parseTimestampValue(value, isNullable)
-			}
-
-			// Return as string to preserve precision, or float64 for alert compatibility
-			// See: https://github.com/Altinity/clickhouse-grafana/issues/832
-			if needsStringPrecision {
-				return parseUInt64AsStringValue(value, isNullable)
-			}
+	t := parseClickHouseType(fieldType)
+	isNullable = isNullable || t.isNullable()
+
+	switch t.fieldKind(fieldName) {
+	case fieldKindString:
+		return parseTypedStringValue(t, value, isNullable)
+	case fieldKindFloat:
+		return parseFloatValue(value, isNullable)
+	case fieldKindWideNumber:
+		// Return as string to preserve precision, or float64 for alert compatibility
+		// See: https://github.com/Altinity/clickhouse-grafana/issues/832
+		switch {
+		case t.name == "UInt64" && needsStringPrecision:
+			return parseUInt64AsStringValue(value, isNullable)
+		case t.name == "UInt64":
 			return parseUInt64AsFloat64Value(value, isNullable)
-		case "Int64":
-			if fieldName == "t" {
-				return parseTimestampValue(value, isNullable)
-			}
-			// Return as string to preserve precision, or float64 for alert compatibility
-			// See: https://github.com/Altinity/clickhouse-grafana/issues/832
-			if needsStringPrecision {
-				return parseInt64AsStringValue(value, isNullable)
-			}
+		case t.name == "Int64" && needsStringPrecision:
+			return parseInt64AsStringValue(value, isNullable)
+		case t.name == "Int64":
 			return parseInt64AsFloat64Value(value, isNullable)
+		case needsStringPrecision:
+			return parseNumberAsStringValue(value, isNullable)
 		default:
-			if strings.HasPrefix(fieldType, "Decimal") {
-				return parseFloatValue(value, isNullable)
-			} else if strings.HasPrefix(fieldType, "FixedString") || strings.HasPrefix(fieldType, "Enum") {
-				return parseStringValue(value, isNullable)
-			} else if strings.HasPrefix(fieldType, dateTime64Prefix) && strings.Contains(fieldType, "3") {
-				return parseDateTimeValue(value, dateTime64Layout3, tz, isNullable)
-			} else if strings.HasPrefix(fieldType, dateTime64Prefix) && strings.Contains(fieldType, "6") {
-				return parseDateTimeValue(value, dateTime64Layout6, tz, isNullable)
-			} else if strings.HasPrefix(fieldType, dateTimePrefix) {
-				return parseDateTimeValue(value, dateTimeLayout, tz, isNullable)
-			} else if strings.HasPrefix(fieldType, datePrefix) {
-				return parseDateTimeValue(value, dateLayout, tz, isNullable)
-			} else {
-				backend.Logger.Warn(fmt.Sprintf(
-					"Value [%v] has compound type [%v] and will be returned as string", value, fieldType,
-				))
-
-				byteValue, err := json.Marshal(value)
-				if err != nil {
-					backend.Logger.Warn(fmt.Sprintf(
-						"Unable to append value of unknown type %v because of json encoding problem: %s",
-						reflect.TypeOf(value), err,
-					))
-					return nil
-				}
-
-				return parseStringValue(string(byteValue), isNullable)
-			}
+			return parseFloatValue(value, isNullable)
 		}
+	case fieldKindTimestamp:
+		return parseTimestampValue(value, isNullable)
+	case fieldKindTime:
+		return parseDateTimeValue(value, t.timeLayout(), tz, isNullable)
+	case fieldKindBool:
+		return parseBoolValue(value, isNullable)
+	case fieldKindNothing:
+		return nil
+	default:
+		return parseCompoundValue(t, value, isNullable)
 	}
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Precision policies for wide numeric columns: [U]Int64, [U]Int128, [U]Int256 and Decimal*,
+// see DatasourceSettings.PrecisionPolicy and Query.PrecisionPolicy
+const (
+	// PrecisionPolicyAuto keeps columns as float64 when all values are safe for float64 and as strings otherwise
+	PrecisionPolicyAuto = "auto"
+	// PrecisionPolicyFloat64 always returns float64, precision of large values is lost, but alerts and math work
+	PrecisionPolicyFloat64 = "float64"
+	// PrecisionPolicyString always returns strings with all digits
+	PrecisionPolicyString = "string"
+	// PrecisionPolicySplit works as auto, but columns with unsafe values are returned as two float64 fields
+	// "<name>_high" and "<name>_low", high is the nearest float64 and low is the exact remainder,
+	// so value = high + low with 30 significant digits, see splitPreciseField
+	PrecisionPolicySplit = "split"
+)
+
+// resolvePrecisionPolicy returns the query policy when it's set and the datasource policy otherwise
+func resolvePrecisionPolicy(queryPolicy, datasourcePolicy string) string {
+	for _, policy := range []string{queryPolicy, datasourcePolicy} {
+		switch policy {
+		case PrecisionPolicyAuto, PrecisionPolicyFloat64, PrecisionPolicyString, PrecisionPolicySplit:
+			return policy
+		}
+	}
+	return PrecisionPolicyAuto
+}
+
+// typeNeedsStringPrecision chooses the field type of the wide numeric column by its ClickHouse type instead of its values.
+// Streams use it for auto and split policies, since field types of accumulated frames mustn't change between ticks:
+// [U]Int128, [U]Int256 and Decimal with more than 15 digits are strings, [U]Int64 and narrower Decimal are float64.
+func typeNeedsStringPrecision(chType string) bool {
+	t := parseClickHouseType(chType)
+	switch t.name {
+	case "UInt128", "UInt256", "Int128", "Int256", "Decimal64", "Decimal128", "Decimal256":
+		return true
+	case "Decimal":
+		if len(t.params) == 0 {
+			return true
+		}
+		precision, err := strconv.Atoi(strings.TrimSpace(t.params[0]))
+		return err != nil || precision > maxSafeDecimalDigits
+	}
+	return false
+}
+
+// analyzeTypePrecisionNeeds sets needsStringPrecision of columns by typeNeedsStringPrecision,
+// with PrecisionPolicySplit only string columns are tracked, so values never change the decision.
+func (r *Response) analyzeTypePrecisionNeeds(metaTypes map[string]string, needsStringPrecision map[string]bool, policy string) map[string]bool {
+	stringColumns := map[string]bool{}
+	for fieldName := range needsStringPrecision {
+		needsStringPrecision[fieldName] = typeNeedsStringPrecision(metaTypes[fieldName])
+		if needsStringPrecision[fieldName] {
+			stringColumns[fieldName] = true
+		}
+	}
+	if policy == PrecisionPolicySplit {
+		r.precision = newPrecisionTracker(stringColumns, metaTypes, policy)
+		for fieldName := range stringColumns {
+			r.precision.unsafe[fieldName] = true
+		}
+	}
+	return needsStringPrecision
+}
+
+// splitPreciseFields replaces string fields of unsafe columns in frames by high and low float64 fields
+func splitPreciseFields(frames data.Frames, fields []*data.Field) {
+	splitFields := make(map[*data.Field]bool, len(fields))
+	for _, field := range fields {
+		splitFields[field] = true
+	}
+	for _, frame := range frames {
+		var frameFields []*data.Field
+		for _, field := range frame.Fields {
+			if !splitFields[field] {
+				frameFields = append(frameFields, field)
+				continue
+			}
+			high, low := splitPreciseField(field)
+			frameFields = append(frameFields, high, low)
+		}
+		frame.Fields = frameFields
+	}
+}
+
+// splitPreciseField splits the string field with numbers into "<name>_high" and "<name>_low" float64 fields
+func splitPreciseField(field *data.Field) (*data.Field, *data.Field) {
+	isNullable := field.Type() == data.FieldTypeNullableString
+	high := newFloat64Field(field.Name+"_high", isNullable)
+	low := newFloat64Field(field.Name+"_low", isNullable)
+	for i := 0; i < field.Len(); i++ {
+		str, ok := field.ConcreteAt(i)
+		if !ok {
+			high.Append(nil)
+			low.Append(nil)
+			continue
+		}
+		highValue, lowValue := splitPreciseValue(str.(string))
+		high.Append(parseFloatValue(highValue, isNullable))
+		low.Append(parseFloatValue(lowValue, isNullable))
+	}
+	for _, f := range []*data.Field{high, low} {
+		f.Labels = field.Labels
+		f.Config = field.Config
+	}
+	return high, low
+}
+
+// splitPreciseValue returns the nearest float64 of the number and the float64 of the exact remainder
+func splitPreciseValue(str string) (float64, float64) {
+	high, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, 0
+	}
+	exact, ok := new(big.Rat).SetString(str)
+	if !ok {
+		return high, 0
+	}
+	low, _ := exact.Sub(exact, new(big.Rat).SetFloat64(high)).Float64()
+	return high, low
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func precisionPolicyResponse() *Response {
+	return &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "amount", Type: "Decimal(38, 10)"},
+			{Name: "price", Type: "Nullable(Decimal(10, 2))"},
+			{Name: "counter", Type: "UInt128"},
+		},
+		Data: []map[string]interface{}{
+			{"amount": json.Number("12345678901234567.0123456789"), "price": json.Number("10.5"), "counter": "1"},
+			{"amount": json.Number("1.5"), "price": nil, "counter": "340282366920938463463374607431768211455"},
+		},
+	}
+}
+
+func TestPrecisionPolicy(t *testing.T) {
+	testCases := []struct {
+		policy string
+		types  []data.FieldType
+	}{
+		{policy: "", types: []data.FieldType{data.FieldTypeString, data.FieldTypeNullableFloat64, data.FieldTypeString}},
+		{policy: PrecisionPolicyAuto, types: []data.FieldType{data.FieldTypeString, data.FieldTypeNullableFloat64, data.FieldTypeString}},
+		{policy: PrecisionPolicyFloat64, types: []data.FieldType{data.FieldTypeFloat64, data.FieldTypeNullableFloat64, data.FieldTypeFloat64}},
+		{policy: PrecisionPolicyString, types: []data.FieldType{data.FieldTypeString, data.FieldTypeNullableString, data.FieldTypeString}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.policy, func(t *testing.T) {
+			frames, err := precisionPolicyResponse().toFrames(&Query{RefId: "A", PrecisionPolicy: tc.policy}, func(ctx context.Context) *time.Location { return time.UTC })
+			require.NoError(t, err)
+			require.Len(t, frames, len(tc.types))
+			for i, fieldType := range tc.types {
+				require.Equal(t, fieldType, frames[i].Fields[0].Type(), frames[i].Name)
+			}
+		})
+	}
+}
+
+func TestPrecisionPolicyString(t *testing.T) {
+	frames, err := precisionPolicyResponse().toFrames(&Query{RefId: "A", PrecisionPolicy: PrecisionPolicyString}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Equal(t, "12345678901234567.0123456789", frames[0].Fields[0].At(0))
+	require.Equal(t, "10.5", *frames[1].Fields[0].At(0).(*string))
+	require.Equal(t, "340282366920938463463374607431768211455", frames[2].Fields[0].At(1))
+}
+
+func TestPrecisionPolicySplit(t *testing.T) {
+	frames, err := precisionPolicyResponse().toFrames(&Query{RefId: "A", PrecisionPolicy: PrecisionPolicySplit}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Len(t, frames, 3)
+
+	amount := frames[0]
+	require.Len(t, amount.Fields, 2)
+	require.Equal(t, "amount_high", amount.Fields[0].Name)
+	require.Equal(t, "amount_low", amount.Fields[1].Name)
+	high, low := amount.Fields[0].At(0).(float64), amount.Fields[1].At(0).(float64)
+	sum := new(big.Rat).Add(new(big.Rat).SetFloat64(high), new(big.Rat).SetFloat64(low))
+	require.Equal(t, "12345678901234567.01234568", sum.FloatString(8))
+	require.Equal(t, 1.5, amount.Fields[0].At(1))
+	require.Equal(t, 0.0, amount.Fields[1].At(1))
+
+	// safe columns are not split
+	require.Len(t, frames[1].Fields, 1)
+	require.Equal(t, data.FieldTypeNullableFloat64, frames[1].Fields[0].Type())
+	require.Len(t, frames[2].Fields, 2)
+}
+
+func TestPrecisionPolicySplitTimeSeries(t *testing.T) {
+	r := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "t", Type: "UInt64"},
+			{Name: "total", Type: "Int256"},
+		},
+		Data: []map[string]interface{}{
+			{"t": "1705312800000", "total": "100000000000000000000000000001"},
+			{"t": "1705312860000", "total": "-1"},
+		},
+	}
+	frames, err := r.toFrames(&Query{RefId: "A", PrecisionPolicy: PrecisionPolicySplit}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	require.Len(t, frames[0].Fields, 3)
+	require.Equal(t, 1e29, frames[0].Fields[1].At(0))
+	require.Equal(t, -1.0, frames[0].Fields[1].At(1))
+	require.Equal(t, 0.0, frames[0].Fields[2].At(1))
+}
+
+func TestPrecisionPolicyStreaming(t *testing.T) {
+	tick := func(amount, hits string) *Response {
+		return &Response{
+			ctx: context.Background(),
+			Meta: []*FieldMeta{
+				{Name: "amount", Type: "Decimal(38, 10)"},
+				{Name: "price", Type: "Nullable(Decimal(10, 2))"},
+				{Name: "hits", Type: "UInt64"},
+			},
+			Data: []map[string]interface{}{{"amount": json.Number(amount), "price": json.Number("10.5"), "hits": hits}},
+		}
+	}
+	// field types of ticks depend on ClickHouse types only, so accumulated frames keep their types
+	for _, policy := range []string{PrecisionPolicyAuto, PrecisionPolicySplit} {
+		for _, r := range []*Response{tick("1234567.1234567891", "9007199254740993"), tick("12.5", "1")} {
+			frames, err := r.toFrames(&Query{RefId: "A", PrecisionPolicy: policy, streaming: true}, func(ctx context.Context) *time.Location { return time.UTC })
+			require.NoError(t, err)
+			require.Len(t, frames, 3)
+			if policy == PrecisionPolicySplit {
+				require.Equal(t, "amount_high", frames[0].Fields[0].Name)
+				require.Equal(t, data.FieldTypeFloat64, frames[0].Fields[0].Type())
+			} else {
+				require.Equal(t, data.FieldTypeString, frames[0].Fields[0].Type())
+			}
+			require.Equal(t, data.FieldTypeNullableFloat64, frames[1].Fields[0].Type())
+			require.Len(t, frames[2].Fields, 1)
+			require.Equal(t, data.FieldTypeFloat64, frames[2].Fields[0].Type())
+		}
+	}
+}
+
+func TestIsValueSafeForFloat64WideNumbers(t *testing.T) {
+	testCases := []struct {
+		value     interface{}
+		fieldType string
+		safe      bool
+	}{
+		{value: json.Number("9007199254740991"), fieldType: "UInt64", safe: true},
+		{value: "9007199254740992", fieldType: "Nullable(Int64)", safe: false},
+		{value: "-9007199254740991", fieldType: "Int128", safe: true},
+		{value: "-9007199254740992", fieldType: "Int256", safe: false},
+		{value: json.Number("123456789012.345"), fieldType: "Decimal(18, 3)", safe: true},
+		{value: json.Number("0.000000000000001"), fieldType: "Decimal(38, 20)", safe: true},
+		{value: json.Number("1234567890123.4567"), fieldType: "LowCardinality(Nullable(Decimal128(4)))", safe: false},
+		{value: 1.5, fieldType: "Decimal(38, 10)", safe: true},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.safe, IsValueSafeForFloat64(tc.value, tc.fieldType), "%v %s", tc.value, tc.fieldType)
+	}
+}
+
+func TestResolvePrecisionPolicy(t *testing.T) {
+	require.Equal(t, PrecisionPolicyAuto, resolvePrecisionPolicy("", ""))
+	require.Equal(t, PrecisionPolicySplit, resolvePrecisionPolicy("", PrecisionPolicySplit))
+	require.Equal(t, PrecisionPolicyString, resolvePrecisionPolicy(PrecisionPolicyString, PrecisionPolicyFloat64))
+	require.Equal(t, PrecisionPolicyFloat64, resolvePrecisionPolicy("unknown", PrecisionPolicyFloat64))
+}
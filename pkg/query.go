@@ -27,9 +27,24 @@ type Query struct {
 	DateTimeCol  string `json:"dateTimeColDataType"`
 	DateCol      string `json:"dateColDataType"`
 	DateTimeType string `json:"dateTimeType"`
-	RuleUid      string
-	From         time.Time
-	To           time.Time
+	Format       string `json:"format"`
+	Extrapolate  bool   `json:"extrapolate"`
+	WideFormat   bool   `json:"wideFormat"`
+	// PrecisionPolicy overrides DatasourceSettings.PrecisionPolicy for the query
+	PrecisionPolicy string `json:"precisionPolicy"`
+	// ClickHouseSettings are merged with DatasourceSettings.ClickHouseSettings, see mergeClickHouseSettings
+	ClickHouseSettings ClickHouseSettings `json:"clickhouseSettings"`
+	// CacheTTL overrides DatasourceSettings.CacheTTL for the query, e.g. "30s", "0" disables the cache
+	CacheTTL string `json:"cacheTTL"`
+	// CacheAlignTimeRange extends From and To to boundaries of the query interval, see alignTimeRange
+	CacheAlignTimeRange bool `json:"cacheAlignTimeRange"`
+	RuleUid             string
+	From                time.Time
+	To                  time.Time
+	// interval is $interval of the query, time ranges of cached queries are aligned to it
+	interval time.Duration
+	// streaming is set for ticks of streams, field types of wide numeric columns don't depend on values then
+	streaming bool
 }
 
 func (q *Query) ApplyTimeRangeToQuery() string {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +21,60 @@ type Response struct {
 	Meta []*FieldMeta             `json:"meta"`
 	Data []map[string]interface{} `json:"data"`
 	ctx  context.Context
+
+	// rows is set instead of Data when the response body is decoded row by row, see newStreamResponse
+	rows      rowReader
+	closeBody func()
+	precision *precisionTracker
+
+	// arrow is set instead of Data for ArrowStream responses, see toFramesArrow
+	arrow *arrowResult
+}
+
+// forEachRow calls fn for each row of the response.
+// For streamed responses rows are decoded on demand and the row map is reused between calls,
+// so fn must not keep a reference to it.
+func (r *Response) forEachRow(fn func(row map[string]interface{}) error) error {
+	if r.arrow != nil {
+		return r.arrow.forEachRow(r.Meta, func(row map[string]interface{}) error {
+			if r.precision != nil {
+				r.precision.observe(row)
+			}
+			return fn(row)
+		})
+	}
+	if r.rows == nil {
+		for _, row := range r.Data {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	row := make(map[string]interface{}, len(r.Meta))
+	for {
+		hasRow, err := r.rows.next(row)
+		if err != nil {
+			return err
+		}
+		if !hasRow {
+			return nil
+		}
+		if r.precision != nil {
+			r.precision.observe(row)
+		}
+		if err = fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// close releases the response body of streamed responses, it's safe to call it for any response
+func (r *Response) close() {
+	if r.closeBody != nil {
+		r.closeBody()
+		r.closeBody = nil
+	}
 }
 
 var complexTypeRE = regexp.MustCompile("Array|Tuple|Map")
@@ -27,6 +82,20 @@ var labelTypeRE = regexp.MustCompile("String|UUID|Enum|IPv4|IPv6")
 var seriesFromMacrosRE = regexp.MustCompile(`Array\(Tuple\(([^,]+), ([^)]+)\)\)`)
 
 func (r *Response) toFrames(query *Query, fetchTZ FetchTZFunc) (data.Frames, error) {
+	if isAnnotationsQuery(query) {
+		return r.toFramesAnnotations(query, fetchTZ)
+	}
+	switch query.Format {
+	case QueryFormatLogs:
+		return r.toFramesLogs(query, fetchTZ)
+	case QueryFormatTraces:
+		return r.toFramesTraces(query, fetchTZ)
+	case QueryFormatFlamegraph:
+		return r.toFramesFlamegraph(query)
+	}
+	if r.arrow != nil {
+		return r.toFramesArrow(query, fetchTZ)
+	}
 
 	labelFieldsMap, hasLabelFields := r.prepareLabelFieldsMap()
 	timeStampFieldIdx, hasTimeStamp := r.getTimestampFieldIdx()
@@ -73,8 +142,8 @@ func (r *Response) toFramesWithTimeStamp(query *Query, fetchTZ FetchTZFunc, hasL
 
 	timeZonesMap, metaTypes := r.analyzeResponseMeta(fetchTZ)
 
-	// Analyze which UInt64/Int64 columns need string precision
-	needsStringPrecision := r.analyzeColumnPrecisionNeeds(metaTypes)
+	// Analyze which wide numeric columns need string precision
+	needsStringPrecision := r.analyzeColumnPrecisionNeeds(metaTypes, query.PrecisionPolicy, query.streaming)
 
 	// 1 value field + 1 timestamp field
 	hasMultipleTimeSeries := (len(r.Meta) - len(labelFieldsMap)) > 2
@@ -84,30 +153,32 @@ func (r *Response) toFramesWithTimeStamp(query *Query, fetchTZ FetchTZFunc, hasL
 	// frameName -> valueDataFieldMap
 	valueDataFieldMap := map[string]*data.Field{}
 
-	for _, row := range r.Data {
+	err := r.forEachRow(func(row map[string]interface{}) error {
 		value := ParseValue(timestampFieldName, timestampFieldType, timeZonesMap[timestampFieldName], row[timestampFieldName], false)
 		timestampValue, ok := value.(time.Time)
 		if !ok {
-			return nil, fmt.Errorf("Unexpected type from ParseValue of field %s. Expected time.Time, got %T ", timestampFieldName, value)
+			return fmt.Errorf("Unexpected type from ParseValue of field %s. Expected time.Time, got %T ", timestampFieldName, value)
 		}
 
 		if hasLabelFields {
 			framePrefix := r.generateFrameNameByLabels(row, metaTypes, labelFieldsMap)
 			frameLabels := r.generateFrameLabelsByLabels(row, metaTypes, labelFieldsMap)
 
-			for fieldName, fieldValue := range row {
+			for _, meta := range r.Meta {
+				fieldName, fieldValue := meta.Name, row[meta.Name]
 				_, isLabel := labelFieldsMap[fieldName]
 				if fieldName != timestampFieldName && !isLabel {
 					frameName := framePrefix
 					if hasMultipleTimeSeries {
 						frameName += ", " + fieldName
 					}
-					r.createFrameIfNotExistsAndAddPoint(query, framesMap, frameName, timeStampDataFieldMap, timestampFieldName, valueDataFieldMap, fieldName, metaTypes[fieldName], timestampValue, timeZonesMap, fieldValue, needsStringPrecision)
+					r.createFrameIfNotExistsAndAddPoint(query, framesMap, &frames, frameName, timeStampDataFieldMap, timestampFieldName, valueDataFieldMap, fieldName, metaTypes[fieldName], timestampValue, timeZonesMap, fieldValue, needsStringPrecision)
 					valueDataFieldMap[frameName].Labels = frameLabels
 				}
 			}
 		} else {
-			for fieldName, fieldValue := range row {
+			for _, meta := range r.Meta {
+				fieldName, fieldValue := meta.Name, row[meta.Name]
 				if fieldName != timestampFieldName {
 					if seriesFromMacrosRE.MatchString(metaTypes[fieldName]) {
 						for _, match := range seriesFromMacrosRE.FindAllStringSubmatch(metaTypes[fieldName], -1) {
@@ -135,32 +206,36 @@ func (r *Response) toFramesWithTimeStamp(query *Query, fetchTZ FetchTZFunc, hasL
 											}
 										}
 										r.createFrameIfNotExistsAndAddPoint(
-											query, framesMap, tsNameString, timeStampDataFieldMap, timestampFieldName, valueDataFieldMap,
+											query, framesMap, &frames, tsNameString, timeStampDataFieldMap, timestampFieldName, valueDataFieldMap,
 											fieldName, valueType, timestampValue, timeZonesMap, tuple[1], needsStringPrecision,
 										)
 
 									default:
-										return nil, fmt.Errorf("unable to parse data section type=%T in response json: %s", tuple, tuple)
+										return fmt.Errorf("unable to parse data section type=%T in response json: %s", tuple, tuple)
 									}
 								}
 							default:
-								return nil, fmt.Errorf("unable to parse data section name=%s type=%T in response json: %s", fieldName, fieldValue, fieldValue)
+								return fmt.Errorf("unable to parse data section name=%s type=%T in response json: %s", fieldName, fieldValue, fieldValue)
 							}
 
 						}
 
 					} else {
 						frameName := fieldName
-						r.createFrameIfNotExistsAndAddPoint(query, framesMap, frameName, timeStampDataFieldMap, timestampFieldName, valueDataFieldMap, fieldName, metaTypes[fieldName], timestampValue, timeZonesMap, fieldValue, needsStringPrecision)
+						r.createFrameIfNotExistsAndAddPoint(query, framesMap, &frames, frameName, timeStampDataFieldMap, timestampFieldName, valueDataFieldMap, fieldName, metaTypes[fieldName], timestampValue, timeZonesMap, fieldValue, needsStringPrecision)
 					}
 				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	for _, frame := range framesMap {
-		frames = append(frames, frame)
+	if r.precision != nil {
+		r.precision.finishFields(frames)
 	}
-	return frames, nil
+	return finishTimeSeriesFrames(frames, query), nil
 }
 
 func (r *Response) analyzeResponseMeta(fetchTZ FetchTZFunc) (map[string]*time.Location, map[string]string) {
@@ -177,26 +252,38 @@ func (r *Response) analyzeResponseMeta(fetchTZ FetchTZFunc) (map[string]*time.Lo
 	return timeZonesMap, metaTypes
 }
 
-// analyzeColumnPrecisionNeeds scans all data to determine which UInt64/Int64 columns
-// contain values that exceed JavaScript's safe integer range.
+// analyzeColumnPrecisionNeeds scans all data to determine which [U]Int64, [U]Int128, [U]Int256 and Decimal
+// columns contain values that exceed JavaScript's safe integer range or float64 precision.
 // Returns a map of fieldName -> needsStringPrecision.
 // If ALL values in a column are safe for float64, needsStringPrecision is false (better for Grafana alerts).
 // If ANY value exceeds the safe range, needsStringPrecision is true (preserves precision).
-func (r *Response) analyzeColumnPrecisionNeeds(metaTypes map[string]string) map[string]bool {
+// PrecisionPolicyFloat64 and PrecisionPolicyString skip the scan and return the same decision for all columns,
+// PrecisionPolicySplit makes precisionTracker split string fields of unsafe columns after frames are built.
+// Streamed queries skip the scan too, their decisions depend on ClickHouse types, see typeNeedsStringPrecision.
+func (r *Response) analyzeColumnPrecisionNeeds(metaTypes map[string]string, policy string, streaming bool) map[string]bool {
 	needsStringPrecision := map[string]bool{}
 
 	// Initialize all columns to false (assume safe for float64)
 	for fieldName, fieldType := range metaTypes {
-		normalizedType := fieldType
-		if strings.HasPrefix(normalizedType, "LowCardinality(") {
-			normalizedType = strings.TrimSuffix(strings.TrimPrefix(normalizedType, "LowCardinality("), ")")
+		if parseClickHouseType(fieldType).fieldKind(fieldName) == fieldKindWideNumber {
+			needsStringPrecision[fieldName] = policy == PrecisionPolicyString
 		}
-		if strings.HasPrefix(normalizedType, "Nullable(") {
-			normalizedType = strings.TrimSuffix(strings.TrimPrefix(normalizedType, "Nullable("), ")")
-		}
-		if normalizedType == "UInt64" || normalizedType == "Int64" {
-			needsStringPrecision[fieldName] = false
+	}
+	if policy == PrecisionPolicyFloat64 || policy == PrecisionPolicyString {
+		return needsStringPrecision
+	}
+	if streaming {
+		return r.analyzeTypePrecisionNeeds(metaTypes, needsStringPrecision, policy)
+	}
+
+	// Streamed rows can't be scanned before frames are built, keep precision and narrow fields afterwards
+	if r.rows != nil || r.arrow != nil {
+		r.precision = newPrecisionTracker(needsStringPrecision, metaTypes, policy)
+		r.precision.narrow = true
+		for fieldName := range needsStringPrecision {
+			needsStringPrecision[fieldName] = true
 		}
+		return needsStringPrecision
 	}
 
 	// Scan data to find any unsafe values
@@ -219,10 +306,16 @@ func (r *Response) analyzeColumnPrecisionNeeds(metaTypes map[string]string) map[
 		}
 	}
 
+	if policy == PrecisionPolicySplit {
+		r.precision = newPrecisionTracker(needsStringPrecision, metaTypes, policy)
+		for fieldName, needsString := range needsStringPrecision {
+			r.precision.unsafe[fieldName] = needsString
+		}
+	}
 	return needsStringPrecision
 }
 
-func (r *Response) createFrameIfNotExistsAndAddPoint(query *Query, framesMap map[string]*data.Frame, frameName string, timeStampDataFieldMap map[string]*data.Field, timestampFieldName string, valueDataFieldMap map[string]*data.Field, fieldName string, fieldType string, timestampValue time.Time, timeZonesMap map[string]*time.Location, fieldValue interface{}, needsStringPrecision map[string]bool) {
+func (r *Response) createFrameIfNotExistsAndAddPoint(query *Query, framesMap map[string]*data.Frame, frames *data.Frames, frameName string, timeStampDataFieldMap map[string]*data.Field, timestampFieldName string, valueDataFieldMap map[string]*data.Field, fieldName string, fieldType string, timestampValue time.Time, timeZonesMap map[string]*time.Location, fieldValue interface{}, needsStringPrecision map[string]bool) {
 	// Determine if this field needs string precision
 	needsString := false
 	if val, exists := needsStringPrecision[fieldName]; exists {
@@ -239,6 +332,11 @@ func (r *Response) createFrameIfNotExistsAndAddPoint(query *Query, framesMap map
 		)
 
 		framesMap[frameName].RefID = query.RefId
+		// frames keep the order of the first appearance, so series order doesn't change between requests
+		*frames = append(*frames, framesMap[frameName])
+		if r.precision != nil {
+			r.precision.track(fieldName, valueDataFieldMap[frameName])
+		}
 	}
 	timeStampDataFieldMap[frameName].Append(timestampValue)
 	valueDataFieldMap[frameName].Append(ParseValueOptimized(fieldName, fieldType, timeZonesMap[fieldName], fieldValue, false, needsString))
@@ -278,8 +376,8 @@ func (r *Response) generateFrameLabelsByLabels(row map[string]interface{}, metaT
 func (r *Response) toFramesTable(query *Query, fetchTZ FetchTZFunc) (data.Frames, error) {
 	timeZonesMap, metaTypes := r.analyzeResponseMeta(fetchTZ)
 
-	// Analyze which UInt64/Int64 columns need string precision
-	needsStringPrecision := r.analyzeColumnPrecisionNeeds(metaTypes)
+	// Analyze which wide numeric columns need string precision
+	needsStringPrecision := r.analyzeColumnPrecisionNeeds(metaTypes, query.PrecisionPolicy, query.streaming)
 
 	framesMap := map[string]*data.Frame{}
 	frames := data.Frames{}
@@ -290,8 +388,11 @@ func (r *Response) toFramesTable(query *Query, fetchTZ FetchTZFunc) (data.Frames
 		}
 		framesMap[field.Name] = data.NewFrame(field.Name, NewDataFieldByTypeOptimized(field.Name, field.Type, needsString))
 		framesMap[field.Name].RefID = query.RefId
+		if r.precision != nil {
+			r.precision.track(field.Name, framesMap[field.Name].Fields[0])
+		}
 	}
-	for _, row := range r.Data {
+	err := r.forEachRow(func(row map[string]interface{}) error {
 		for fieldName, fieldValue := range row {
 			needsString := false
 			if val, exists := needsStringPrecision[fieldName]; exists {
@@ -301,9 +402,108 @@ func (r *Response) toFramesTable(query *Query, fetchTZ FetchTZFunc) (data.Frames
 				fieldName, metaTypes[fieldName], timeZonesMap[fieldName], fieldValue, false, needsString,
 			))
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// one frame per column in the column order
+	for _, field := range r.Meta {
+		frames = append(frames, framesMap[field.Name])
 	}
-	for _, frame := range framesMap {
-		frames = append(frames, frame)
+	if r.precision != nil {
+		r.precision.finishFields(frames)
 	}
 	return frames, nil
 }
+
+// precisionTracker replaces the data scan of analyzeColumnPrecisionNeeds for streamed responses:
+// wide numeric fields are built as strings, and when all values of a column turn out
+// to be safe for float64, its fields are narrowed to float64 after the last row.
+// With PrecisionPolicySplit string fields of unsafe columns are split into high and low float64 fields.
+type precisionTracker struct {
+	metaTypes map[string]string
+	unsafe    map[string]bool
+	fields    map[string][]*data.Field
+	// narrow converts fields of safe columns, it's set when values are observed while frames are built
+	narrow bool
+	split  bool
+}
+
+func newPrecisionTracker(columns map[string]bool, metaTypes map[string]string, policy string) *precisionTracker {
+	tracker := &precisionTracker{
+		metaTypes: map[string]string{},
+		unsafe:    map[string]bool{},
+		fields:    map[string][]*data.Field{},
+		split:     policy == PrecisionPolicySplit,
+	}
+	for fieldName := range columns {
+		tracker.metaTypes[fieldName] = metaTypes[fieldName]
+	}
+	return tracker
+}
+
+// observe checks values of tracked columns in the row
+func (p *precisionTracker) observe(row map[string]interface{}) {
+	for fieldName, fieldType := range p.metaTypes {
+		if !p.unsafe[fieldName] && !IsValueSafeForFloat64(row[fieldName], fieldType) {
+			p.unsafe[fieldName] = true
+		}
+	}
+}
+
+// track remembers the field built for the column
+func (p *precisionTracker) track(fieldName string, field *data.Field) {
+	if _, tracked := p.metaTypes[fieldName]; tracked {
+		p.fields[fieldName] = append(p.fields[fieldName], field)
+	}
+}
+
+// finishFields converts string fields of safe columns to float64 in place,
+// and splits string fields of unsafe columns in frames for PrecisionPolicySplit
+func (p *precisionTracker) finishFields(frames data.Frames) {
+	var splitFields []*data.Field
+	for fieldName, fields := range p.fields {
+		if p.unsafe[fieldName] {
+			if p.split {
+				splitFields = append(splitFields, fields...)
+			}
+			continue
+		}
+		if p.narrow {
+			for _, field := range fields {
+				narrowStringField(field)
+			}
+		}
+	}
+	if len(splitFields) > 0 {
+		splitPreciseFields(frames, splitFields)
+	}
+	p.fields = map[string][]*data.Field{}
+}
+
+func narrowStringField(field *data.Field) {
+	var narrowed *data.Field
+	switch field.Type() {
+	case data.FieldTypeString:
+		values := make([]float64, field.Len())
+		for i := range values {
+			values[i], _ = strconv.ParseFloat(field.At(i).(string), 64)
+		}
+		narrowed = data.NewField(field.Name, field.Labels, values)
+	case data.FieldTypeNullableString:
+		values := make([]*float64, field.Len())
+		for i := range values {
+			if str := field.At(i).(*string); str != nil {
+				if v, err := strconv.ParseFloat(*str, 64); err == nil {
+					values[i] = &v
+				}
+			}
+		}
+		narrowed = data.NewField(field.Name, field.Labels, values)
+	default:
+		return
+	}
+	narrowed.Config = field.Config
+	*field = *narrowed
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// resultCache keeps frames of recent queries in memory, so identical panel refreshes of many viewers
+// are served without ClickHouse. Frames are kept in Arrow encoding, which gives the size for the LRU limit
+// and a deep copy on each hit.
+type resultCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+type resultCacheEntry struct {
+	key     string
+	frames  [][]byte
+	size    int64
+	created time.Time
+	expires time.Time
+	// from and to are the time range of frames kept by the delta cache, see executeDeltaQuery
+	from, to time.Time
+}
+
+// cachedResult is the copy of cached frames
+type cachedResult struct {
+	frames   data.Frames
+	age      time.Duration
+	from, to time.Time
+}
+
+func newResultCache(maxBytes int64) *resultCache {
+	return &resultCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns frames of the query
+func (c *resultCache) get(key string) (*cachedResult, bool) {
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := element.Value.(*resultCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(element)
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	c.mu.Unlock()
+
+	frames, err := data.UnmarshalArrowFrames(entry.frames)
+	if err != nil {
+		return nil, false
+	}
+	return &cachedResult{frames: frames, age: time.Since(entry.created), from: entry.from, to: entry.to}, true
+}
+
+// set stores frames for ttl, least recently used results are evicted when the cache exceeds maxBytes
+func (c *resultCache) set(key string, frames data.Frames, ttl time.Duration) error {
+	return c.setRange(key, frames, ttl, time.Time{}, time.Time{})
+}
+
+// setRange stores frames which contain rows of the time range [from, to)
+func (c *resultCache) setRange(key string, frames data.Frames, ttl time.Duration, from, to time.Time) error {
+	encoded, err := frames.MarshalArrow()
+	if err != nil {
+		return fmt.Errorf("unable to encode frames for result cache: %w", err)
+	}
+	entry := &resultCacheEntry{key: key, frames: encoded, created: time.Now(), from: from, to: to}
+	entry.expires = entry.created.Add(ttl)
+	for _, frame := range encoded {
+		entry.size += int64(len(frame))
+	}
+	if entry.size > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
+
+// remove drops the element, c.mu must be locked
+func (c *resultCache) remove(element *list.Element) {
+	entry := element.Value.(*resultCacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// resultCacheKey identifies the result by the final SQL, ClickHouse settings and options which change frames
+func resultCacheKey(sql string, settings ClickHouseSettings, query *Query) string {
+	key, _ := json.Marshal(struct {
+		SQL             string
+		Settings        ClickHouseSettings
+		Format          string
+		Extrapolate     bool
+		WideFormat      bool
+		PrecisionPolicy string
+		From, To        int64
+	}{sql, settings, query.Format, query.Extrapolate, query.WideFormat, query.PrecisionPolicy, query.From.UnixMilli(), query.To.UnixMilli()})
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseCacheTTL returns the query TTL when it's set and the datasource TTL otherwise, "0" disables the cache.
+// The query TTL is limited by the datasource TTL, so queries can't keep results longer than the admin allows.
+func parseCacheTTL(queryTTL, datasourceTTL string) (time.Duration, error) {
+	var limit time.Duration
+	if datasourceTTL != "" {
+		var err error
+		if limit, err = time.ParseDuration(datasourceTTL); err != nil {
+			return 0, fmt.Errorf("invalid cacheTTL %q: %w", datasourceTTL, err)
+		}
+	}
+	if queryTTL == "" {
+		return limit, nil
+	}
+	duration, err := time.ParseDuration(queryTTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cacheTTL %q: %w", queryTTL, err)
+	}
+	if limit > 0 && duration > limit {
+		return limit, nil
+	}
+	return duration, nil
+}
+
+// resultCacheTTL returns how long the query result is cached, 0 when the cache is disabled
+func (client *ClickHouseClient) resultCacheTTL(query *Query) (time.Duration, error) {
+	if client.settings.cache == nil {
+		return 0, nil
+	}
+	return parseCacheTTL(query.CacheTTL, client.settings.CacheTTL)
+}
+
+// alignTimeRange extends the time range to boundaries of the query interval, so refreshes of rolling ranges
+// like "last 6h" within one interval produce the same SQL and the panel isn't cut by more than a bucket
+func alignTimeRange(from, to time.Time, step time.Duration) (time.Time, time.Time) {
+	alignedTo := to.Truncate(step)
+	if alignedTo.Before(to) {
+		alignedTo = alignedTo.Add(step)
+	}
+	return from.Truncate(step), alignedTo
+}
+
+// addFramesNotice appends the info notice to each frame
+func addFramesNotice(frames data.Frames, text string) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{Severity: data.NoticeSeverityInfo, Text: text})
+	}
+}
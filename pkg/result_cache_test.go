@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/altinity/clickhouse-grafana/pkg/eval"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+type testInstanceManager struct {
+	settings *DatasourceSettings
+}
+
+func (im *testInstanceManager) Get(_ context.Context, _ backend.PluginContext) (instancemgmt.Instance, error) {
+	return im.settings, nil
+}
+
+func (im *testInstanceManager) Do(_ context.Context, _ backend.PluginContext, fn instancemgmt.InstanceCallbackFunc) error {
+	return fmt.Errorf("unexpected Do(%T)", fn)
+}
+
+func resultCacheTestFrames(values ...float64) data.Frames {
+	return data.Frames{data.NewFrame("value", data.NewField("value", nil, values))}
+}
+
+func TestResultCacheLRU(t *testing.T) {
+	encoded, err := resultCacheTestFrames(1).MarshalArrow()
+	require.NoError(t, err)
+	entrySize := int64(len(encoded[0]))
+
+	cache := newResultCache(2 * entrySize)
+	require.NoError(t, cache.set("a", resultCacheTestFrames(1), time.Minute))
+	require.NoError(t, cache.set("b", resultCacheTestFrames(2), time.Minute))
+	_, ok := cache.get("a")
+	require.True(t, ok)
+	require.NoError(t, cache.set("c", resultCacheTestFrames(3), time.Minute))
+
+	// b is the least recently used
+	_, ok = cache.get("b")
+	require.False(t, ok)
+	cached, ok := cache.get("c")
+	require.True(t, ok)
+	require.Equal(t, 3.0, cached.frames[0].Fields[0].At(0))
+	require.Equal(t, 2*entrySize, cache.size)
+
+	// frames are copied, changes don't reach the cache
+	cached.frames[0].Fields[0].Set(0, 4.0)
+	cached, _ = cache.get("c")
+	require.Equal(t, 3.0, cached.frames[0].Fields[0].At(0))
+
+	// results larger than the cache aren't stored
+	require.NoError(t, cache.set("d", resultCacheTestFrames(make([]float64, 1000)...), time.Minute))
+	_, ok = cache.get("d")
+	require.False(t, ok)
+}
+
+func TestResultCacheTTL(t *testing.T) {
+	cache := newResultCache(1 << 20)
+	require.NoError(t, cache.set("a", resultCacheTestFrames(1), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	_, ok := cache.get("a")
+	require.False(t, ok)
+	require.Zero(t, cache.size)
+}
+
+func TestResultCacheKey(t *testing.T) {
+	q := &Query{Format: "time_series"}
+	key := resultCacheKey("SELECT 1", ClickHouseSettings{"max_threads": "1"}, q)
+	require.Equal(t, key, resultCacheKey("SELECT 1", ClickHouseSettings{"max_threads": "1"}, &Query{Format: "time_series", RefId: "B"}))
+	require.NotEqual(t, key, resultCacheKey("SELECT 2", ClickHouseSettings{"max_threads": "1"}, q))
+	require.NotEqual(t, key, resultCacheKey("SELECT 1", ClickHouseSettings{"max_threads": "2"}, q))
+	require.NotEqual(t, key, resultCacheKey("SELECT 1", ClickHouseSettings{"max_threads": "1"}, &Query{Format: "table"}))
+}
+
+func TestParseCacheTTL(t *testing.T) {
+	ttl, err := parseCacheTTL("", "1m")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, ttl)
+	ttl, err = parseCacheTTL("0", "1m")
+	require.NoError(t, err)
+	require.Zero(t, ttl)
+	_, err = parseCacheTTL("1 minute", "")
+	require.Error(t, err)
+	// the query can't keep results longer than the datasource allows
+	ttl, err = parseCacheTTL("1h", "1m")
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, ttl)
+	ttl, err = parseCacheTTL("30s", "1m")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, ttl)
+}
+
+func TestAlignTimeRange(t *testing.T) {
+	from := time.Date(2024, 1, 15, 4, 12, 30, 0, time.UTC)
+	to := time.Date(2024, 1, 15, 10, 12, 30, 0, time.UTC)
+	alignedFrom, alignedTo := alignTimeRange(from, to, time.Minute)
+	require.Equal(t, time.Date(2024, 1, 15, 4, 12, 0, 0, time.UTC), alignedFrom)
+	require.Equal(t, time.Date(2024, 1, 15, 10, 13, 0, 0, time.UTC), alignedTo)
+
+	alignedFrom, alignedTo = alignTimeRange(alignedFrom, alignedTo, time.Minute)
+	require.Equal(t, time.Date(2024, 1, 15, 4, 12, 0, 0, time.UTC), alignedFrom)
+	require.Equal(t, time.Date(2024, 1, 15, 10, 13, 0, 0, time.UTC), alignedTo)
+}
+
+func TestExecuteQueryUsesResultCache(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("query") == TimeZoneQuery {
+			_, _ = w.Write([]byte(`{"meta":[{"name":"timezone()","type":"String"}],"data":[{"timezone()":"UTC"}]}`))
+			return
+		}
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"meta":[{"name":"value","type":"UInt8"}],"data":[{"value":1}]}`))
+	}))
+	defer server.Close()
+
+	ds := &ClickHouseDatasource{im: &testInstanceManager{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+		CacheTTL:   "1m",
+		cache:      newResultCache(1 << 20),
+	}}}
+	queryRef := func(refId, cacheTTL string) backend.DataResponse {
+		return ds.executeQuery(backend.PluginContext{}, context.Background(), &Query{RefId: refId, RawQuery: "SELECT 1 AS value", Format: "table", CacheTTL: cacheTTL})
+	}
+	query := func(cacheTTL string) backend.DataResponse {
+		return queryRef("A", cacheTTL)
+	}
+
+	miss := query("")
+	require.NoError(t, miss.Error)
+	require.True(t, strings.HasPrefix(miss.Frames[0].Meta.Notices[0].Text, "Cache miss"))
+	hit := query("")
+	require.NoError(t, hit.Error)
+	require.True(t, strings.HasPrefix(hit.Frames[0].Meta.Notices[0].Text, "Cache hit"))
+	require.Len(t, hit.Frames[0].Meta.Notices, 1)
+	require.Equal(t, miss.Frames[0].Fields[0].At(0), hit.Frames[0].Fields[0].At(0))
+	require.Equal(t, int32(1), requests.Load())
+
+	// the query of another panel with the same SQL gets the cached frames with its own refId
+	other := queryRef("B", "")
+	require.NoError(t, other.Error)
+	require.True(t, strings.HasPrefix(other.Frames[0].Meta.Notices[0].Text, "Cache hit"))
+	require.Equal(t, "B", other.Frames[0].RefID)
+	require.Equal(t, int32(1), requests.Load())
+
+	disabled := query("0")
+	require.NoError(t, disabled.Error)
+	require.Nil(t, disabled.Frames[0].Meta)
+	require.Equal(t, int32(2), requests.Load())
+
+	require.Error(t, query("soon").Error)
+}
+
+func TestEvalQueryAlignsTimeRangeToInterval(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		_, _ = w.Write([]byte(`{"meta":[{"name":"value","type":"UInt8"}],"data":[{"value":1}]}`))
+	}))
+	defer server.Close()
+
+	ds := &ClickHouseDatasource{im: &testInstanceManager{settings: &DatasourceSettings{
+		Instance:            backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient:          server.Client(),
+		CacheTTL:            "5m",
+		CacheAlignTimeRange: true,
+		cache:               newResultCache(1 << 20),
+	}}}
+	from := time.Date(2024, 1, 15, 10, 0, 3, 0, time.UTC)
+	resp := ds.evalQuery(backend.PluginContext{}, context.Background(), &eval.EvalQuery{
+		RefId:    "A",
+		Query:    "SELECT 1 AS value FROM t WHERE t >= $from AND t <= $to",
+		Interval: "10s",
+		Format:   "table",
+		From:     from,
+		To:       from.Add(time.Hour),
+	})
+	require.NoError(t, resp.Error)
+	// the range is extended to the 10s interval, not to the 5m cache TTL
+	require.Contains(t, queries[0], fmt.Sprintf("t >= %d AND t <= %d", from.Unix()-3, from.Add(time.Hour).Unix()+7))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxErrorTailLength limits the part of the body which is attached to decoding errors,
+// ClickHouse writes exception text into the body when a query fails after the first rows were sent
+const maxErrorTailLength = 4096
+
+// rowReader reads response rows one by one, so the whole result never has to be kept in memory.
+type rowReader interface {
+	// next fills row with values of the next row, returns false when there are no more rows
+	next(row map[string]interface{}) (bool, error)
+}
+
+// newStreamResponse reads the response header of row based format and returns Response
+// which decodes rows lazily from body, closeBody is called by Response.close.
+func newStreamResponse(ctx context.Context, format string, body io.Reader, closeBody func()) (*Response, error) {
+	var rows rowReader
+	var meta []*FieldMeta
+	var err error
+	switch format {
+	case ResponseFormatNative:
+		rows, meta, err = newNativeRowReader(body)
+	case ResponseFormatJSONCompactEachRow:
+		rows, meta, err = newJSONCompactEachRowReader(body)
+	default:
+		err = fmt.Errorf("unsupported response format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		ctx:       ctx,
+		Meta:      meta,
+		rows:      rows,
+		closeBody: closeBody,
+	}, nil
+}
+
+// jsonCompactEachRowReader decodes JSONCompactEachRowWithNamesAndTypes format:
+// the first line contains JSON array of names, the second one array of types, then one array per row.
+type jsonCompactEachRowReader struct {
+	decoder *json.Decoder
+	body    io.Reader
+	names   []string
+	values  []interface{}
+}
+
+func newJSONCompactEachRowReader(body io.Reader) (*jsonCompactEachRowReader, []*FieldMeta, error) {
+	reader := &jsonCompactEachRowReader{
+		decoder: json.NewDecoder(body),
+		body:    body,
+	}
+	// keep precision of UInt64/Int64 values, see https://github.com/Altinity/clickhouse-grafana/issues/832
+	reader.decoder.UseNumber()
+
+	var types []string
+	if err := reader.decoder.Decode(&reader.names); err != nil {
+		return nil, nil, reader.decodeError("names", err)
+	}
+	if err := reader.decoder.Decode(&types); err != nil {
+		return nil, nil, reader.decodeError("types", err)
+	}
+	if len(reader.names) != len(types) {
+		return nil, nil, fmt.Errorf("names count %d doesn't match types count %d", len(reader.names), len(types))
+	}
+	meta := make([]*FieldMeta, len(types))
+	for i := range types {
+		meta[i] = &FieldMeta{Name: reader.names[i], Type: types[i]}
+	}
+	return reader, meta, nil
+}
+
+func (r *jsonCompactEachRowReader) next(row map[string]interface{}) (bool, error) {
+	r.values = r.values[:0]
+	if err := r.decoder.Decode(&r.values); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, r.decodeError("row", err)
+	}
+	if len(r.values) != len(r.names) {
+		return false, fmt.Errorf("row contains %d values, expected %d", len(r.values), len(r.names))
+	}
+	for i, name := range r.names {
+		row[name] = r.values[i]
+	}
+	return true, nil
+}
+
+// decodeError attaches the rest of the body to the error, usually it's ClickHouse exception text
+func (r *jsonCompactEachRowReader) decodeError(section string, err error) error {
+	tail, _ := io.ReadAll(io.LimitReader(io.MultiReader(r.decoder.Buffered(), r.body), maxErrorTailLength))
+	if text := strings.TrimSpace(string(tail)); text != "" {
+		return fmt.Errorf("unable to parse %s: %w, response: %s", section, err, text)
+	}
+	return fmt.Errorf("unable to parse %s: %w", section, err)
+}
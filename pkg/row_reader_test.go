@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONCompactEachRowResponseToFrames(t *testing.T) {
+	body := `["event_time","category","requests","hits"]
+["DateTime","String","UInt64","Nullable(Int64)"]
+["2024-01-15 10:00:00","web","150","1"]
+["2024-01-15 10:00:00","api","300",null]
+["2024-01-15 11:00:00","web","18446744073709551615","2"]
+["2024-01-15 11:00:00","api","350","3"]
+`
+	closed := false
+	resp, err := newStreamResponse(context.Background(), ResponseFormatJSONCompactEachRow, strings.NewReader(body), func() { closed = true })
+	require.NoError(t, err)
+	require.Equal(t, []*FieldMeta{
+		{Name: "event_time", Type: "DateTime"},
+		{Name: "category", Type: "String"},
+		{Name: "requests", Type: "UInt64"},
+		{Name: "hits", Type: "Nullable(Int64)"},
+	}, resp.Meta)
+
+	frames, err := resp.toFrames(&Query{RefId: "A"}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	resp.close()
+	require.True(t, closed)
+
+	// web, api x requests, hits
+	require.Len(t, frames, 4)
+	for _, frame := range frames {
+		require.Equal(t, 2, frame.Rows())
+		valueField := frame.Fields[1]
+		switch valueField.Name {
+		case "web, requests", "api, requests":
+			// UInt64 above 2^53 in any row keeps the whole column as strings
+			require.Equal(t, data.FieldTypeString, valueField.Type())
+		case "web, hits", "api, hits":
+			// all values are safe, fields are narrowed to float64
+			require.Equal(t, data.FieldTypeNullableFloat64, valueField.Type())
+		default:
+			t.Fatalf("unexpected field %s", valueField.Name)
+		}
+	}
+}
+
+func TestJSONCompactEachRowResponseException(t *testing.T) {
+	body := `["n"]
+["UInt64"]
+["1"]
+Code: 241. DB::Exception: Memory limit (total) exceeded. (MEMORY_LIMIT_EXCEEDED)
+`
+	resp, err := newStreamResponse(context.Background(), ResponseFormatJSONCompactEachRow, strings.NewReader(body), nil)
+	require.NoError(t, err)
+	_, err = resp.toFrames(&Query{RefId: "A"}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "MEMORY_LIMIT_EXCEEDED")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// defaultStreamCheckpointMaxAge is used when StreamCheckpointMaxAge isn't set
+const defaultStreamCheckpointMaxAge = time.Hour
+
+const streamCheckpointExt = ".checkpoint"
+
+// streamCheckpointInterval is the minimal time between checkpoints of the stream, each one encodes
+// all accumulated frames, so they aren't written on every tick of fast streams with long ranges
+const streamCheckpointInterval = 30 * time.Second
+
+// streamCheckpoint is the state of runDeltaLoop saved after the ticks which changed accumulated frames
+type streamCheckpoint struct {
+	DashboardFrom time.Time `json:"dashboardFrom"`
+	LastTo        time.Time `json:"lastTo"`
+	SavedAt       time.Time `json:"savedAt"`
+	// Frames are Arrow encoded accumulated frames
+	Frames [][]byte `json:"frames"`
+}
+
+// streamCheckpoints keeps checkpoints of delta streams in files of dir, so the restarted plugin or the reconnected
+// stream queries only rows after the checkpoint, checkpoints older than maxAge are removed and the full range is queried.
+// Files are named by checkpoint keys, see streamCheckpointKey.
+// Methods of nil streamCheckpoints do nothing.
+type streamCheckpoints struct {
+	dir    string
+	maxAge time.Duration
+}
+
+// streamCheckpointRoot is the directory of the plugin where StreamCheckpointDir is created
+func streamCheckpointRoot() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "vertamedia-clickhouse-datasource", "stream-checkpoints")
+}
+
+// streamCheckpointPath returns StreamCheckpointDir inside streamCheckpointRoot, the setting is editable
+// by organization admins, so absolute paths and paths which leave the root are rejected
+func streamCheckpointPath(dir string) (string, error) {
+	if !filepath.IsLocal(dir) {
+		return "", fmt.Errorf("invalid streamCheckpointDir %q, expected a relative path without ..", dir)
+	}
+	return filepath.Join(streamCheckpointRoot(), dir), nil
+}
+
+func newStreamCheckpoints(dir string, maxAge string) (*streamCheckpoints, error) {
+	checkpoints := &streamCheckpoints{dir: dir, maxAge: defaultStreamCheckpointMaxAge}
+	if maxAge != "" {
+		var err error
+		if checkpoints.maxAge, err = time.ParseDuration(maxAge); err != nil || checkpoints.maxAge <= 0 {
+			return nil, fmt.Errorf("invalid streamCheckpointMaxAge %q, expected a positive duration like 1h", maxAge)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("unable to create stream checkpoint directory: %w", err)
+	}
+	checkpoints.prune()
+	return checkpoints, nil
+}
+
+func (c *streamCheckpoints) file(key string) string {
+	return filepath.Join(c.dir, key+streamCheckpointExt)
+}
+
+// load returns accumulated frames and lastTo of the stream, the checkpoint is ignored when it's older than maxAge
+// or when it doesn't have rows since dashboardFrom, rows before dashboardFrom are dropped
+func (c *streamCheckpoints) load(key string, dashboardFrom time.Time) (map[string]*data.Frame, time.Time, bool) {
+	if c == nil {
+		return nil, time.Time{}, false
+	}
+	raw, err := os.ReadFile(c.file(key))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			backend.Logger.Warn(fmt.Sprintf("[streaming] unable to read checkpoint %.12s: %v", key, err))
+		}
+		return nil, time.Time{}, false
+	}
+	var checkpoint streamCheckpoint
+	if err := json.Unmarshal(raw, &checkpoint); err != nil {
+		backend.Logger.Warn(fmt.Sprintf("[streaming] unable to parse checkpoint %.12s: %v", key, err))
+		c.remove(key)
+		return nil, time.Time{}, false
+	}
+	if age := time.Since(checkpoint.SavedAt); age > c.maxAge {
+		backend.Logger.Info(fmt.Sprintf("[streaming] checkpoint %.12s is %s old, the full range is queried", key, age.Truncate(time.Second)))
+		c.remove(key)
+		return nil, time.Time{}, false
+	}
+	if checkpoint.DashboardFrom.After(dashboardFrom) || !checkpoint.LastTo.After(dashboardFrom) {
+		return nil, time.Time{}, false
+	}
+	frames, err := data.UnmarshalArrowFrames(checkpoint.Frames)
+	if err != nil {
+		backend.Logger.Warn(fmt.Sprintf("[streaming] unable to decode checkpoint %.12s: %v", key, err))
+		c.remove(key)
+		return nil, time.Time{}, false
+	}
+	accumulated := make(map[string]*data.Frame, len(frames))
+	for _, frame := range frames {
+		accumulated[frameKey(frame)] = frame
+	}
+	trimAccumulatedFrames(accumulated, dashboardFrom)
+	return accumulated, checkpoint.LastTo, true
+}
+
+// save writes the checkpoint to a temporary file and renames it, so load never reads a partial checkpoint
+func (c *streamCheckpoints) save(key string, dashboardFrom, lastTo time.Time, accumulated map[string]*data.Frame) error {
+	if c == nil {
+		return nil
+	}
+	frames := make(data.Frames, 0, len(accumulated))
+	for _, frame := range accumulated {
+		frames = append(frames, frame)
+	}
+	encoded, err := frames.MarshalArrow()
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(streamCheckpoint{DashboardFrom: dashboardFrom, LastTo: lastTo, SavedAt: time.Now(), Frames: encoded})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, key+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.file(key))
+}
+
+func (c *streamCheckpoints) remove(key string) {
+	if err := os.Remove(c.file(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		backend.Logger.Warn(fmt.Sprintf("[streaming] unable to remove checkpoint %.12s: %v", key, err))
+	}
+}
+
+// prune removes checkpoints older than maxAge, e.g. of dashboards which aren't opened anymore
+func (c *streamCheckpoints) prune() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		backend.Logger.Warn(fmt.Sprintf("[streaming] unable to list checkpoints: %v", err))
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), streamCheckpointExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) <= c.maxAge {
+			continue
+		}
+		c.remove(strings.TrimSuffix(entry.Name(), streamCheckpointExt))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamCheckpoints(t *testing.T) {
+	checkpoints, err := newStreamCheckpoints(t.TempDir(), "1h")
+	require.NoError(t, err)
+	dashboardFrom := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	lastTo := dashboardFrom.Add(time.Hour)
+	old, value := int64(3), int64(7)
+	accumulated := map[string]*data.Frame{
+		"host0": data.NewFrame("",
+			data.NewField("t", nil, []time.Time{dashboardFrom, dashboardFrom.Add(5 * time.Minute)}),
+			data.NewField("host0", nil, []*int64{&old, &value})),
+	}
+	config := func(from, to time.Time) *streamConfig {
+		raw := fmt.Sprintf(`{"refId":"A","query":"SELECT $timeSeries AS t, count() FROM t WHERE $timeFilter GROUP BY t","interval":"1m","timeRange":{"from":%q,"to":%q}}`,
+			from.Format(time.RFC3339), to.Format(time.RFC3339))
+		cfg, err := newStreamConfig(backend.PluginContext{OrgID: 1}, json.RawMessage(raw), &DatasourceSettings{})
+		require.NoError(t, err)
+		return cfg
+	}
+	saved := config(dashboardFrom, lastTo)
+	require.NoError(t, checkpoints.save(saved.checkpointKey, saved.dashboardFrom, lastTo, accumulated))
+
+	// the relative range has moved after the reload, the session is new, but the checkpoint is found
+	reloaded := config(dashboardFrom.Add(2*time.Minute), lastTo.Add(2*time.Minute))
+	require.NotEqual(t, saved.key, reloaded.key)
+	key := reloaded.checkpointKey
+	require.Equal(t, saved.checkpointKey, key)
+	restored, restoredTo, ok := checkpoints.load(key, reloaded.dashboardFrom)
+	require.True(t, ok)
+	require.True(t, lastTo.Equal(restoredTo))
+	// rows before the moved window are dropped
+	require.Equal(t, 1, restored["host0"].Rows())
+	require.Equal(t, data.FieldTypeNullableInt64, restored["host0"].Fields[1].Type())
+	require.Equal(t, &value, restored["host0"].Fields[1].At(0))
+
+	// another length of the range doesn't use the checkpoint
+	require.NotEqual(t, key, config(dashboardFrom, lastTo.Add(time.Hour)).checkpointKey)
+
+	// the checkpoint doesn't have rows of the earlier or the later dashboard range
+	_, _, ok = checkpoints.load(key, dashboardFrom.Add(-time.Minute))
+	require.False(t, ok)
+	_, _, ok = checkpoints.load(key, lastTo)
+	require.False(t, ok)
+
+	// checkpoints older than maxAge are removed
+	checkpoints.maxAge = time.Nanosecond
+	_, _, ok = checkpoints.load(key, dashboardFrom)
+	require.False(t, ok)
+	_, err = os.Stat(checkpoints.file(key))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	var disabled *streamCheckpoints
+	require.NoError(t, disabled.save(key, dashboardFrom, lastTo, accumulated))
+	_, _, ok = disabled.load(key, dashboardFrom)
+	require.False(t, ok)
+
+	_, err = newStreamCheckpoints(t.TempDir(), "1 hour")
+	require.Error(t, err)
+}
+
+func TestStreamCheckpointsPrune(t *testing.T) {
+	dir := t.TempDir()
+	checkpoints, err := newStreamCheckpoints(dir, "1h")
+	require.NoError(t, err)
+	require.NoError(t, checkpoints.save("old", time.Now(), time.Now(), nil))
+	require.NoError(t, checkpoints.save("new", time.Now(), time.Now(), nil))
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(checkpoints.file("old"), old, old))
+
+	_, err = newStreamCheckpoints(dir, "1h")
+	require.NoError(t, err)
+	_, err = os.Stat(checkpoints.file("old"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(checkpoints.file("new"))
+	require.NoError(t, err)
+}
+
+func TestRunDeltaLoopThrottlesCheckpoints(t *testing.T) {
+	var ranges [][2]time.Time
+	server := deltaCacheTestServer(t, &ranges)
+	defer server.Close()
+
+	ds := &ClickHouseDatasource{im: &testInstanceManager{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+	}}}
+	checkpoints, err := newStreamCheckpoints(t.TempDir(), "1h")
+	require.NoError(t, err)
+	sq := &streamQuery{
+		RefId:       "A",
+		Query:       "SELECT $timeSeries AS t, count() AS v FROM $table WHERE $timeFilter GROUP BY t ORDER BY t",
+		DateTimeCol: "dt",
+		Table:       "events",
+		Interval:    "1s",
+		Format:      "time_series",
+	}
+	dashboardFrom := roundDownTo(time.Now().Add(-10*time.Minute), 1)
+	pacer := newStreamPacer(100*time.Millisecond, 0)
+	defer pacer.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ds.runDeltaLoop(ctx, backend.PluginContext{}, &testStreamSender{}, sq, dashboardFrom, 0, pacer, 1, checkpoints, "key")
+	}()
+
+	var firstTo time.Time
+	require.Eventually(t, func() bool {
+		var ok bool
+		_, firstTo, ok = checkpoints.load("key", dashboardFrom)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	// next ticks have new rows, but they aren't saved until streamCheckpointInterval passes
+	time.Sleep(1500 * time.Millisecond)
+	_, lastTo, _ := checkpoints.load("key", dashboardFrom)
+	require.True(t, firstTo.Equal(lastTo))
+
+	// the pending checkpoint is saved when the stream stops
+	cancel()
+	require.NoError(t, <-done)
+	_, lastTo, _ = checkpoints.load("key", dashboardFrom)
+	require.True(t, lastTo.After(firstTo))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// streamDiff is the schema of the frame last sent by the stream with StreamingDiff. The frame is sent with its schema
+// on the first tick and when the schema changes, e.g. a new series appears, other ticks send only the data of rows
+// since the first re-queried bucket. The frontend appends them and keeps the last row of each time, so buckets
+// updated by the lookback replace the previous values, and drops rows older than the rolling window.
+type streamDiff struct {
+	schema string
+}
+
+// streamDiffSender is implemented by streamSession, the whole frame is replayed to subscribers which join later
+type streamDiffSender interface {
+	SendDiff(diff, snapshot *data.Frame) error
+}
+
+// frameSchema describes fields of the frame, frames with the same schema could be sent as data only
+func frameSchema(frame *data.Frame) string {
+	var schema strings.Builder
+	for _, field := range frame.Fields {
+		fmt.Fprintf(&schema, "%s\x00%s\x00%s\x00", field.Name, field.Type(), field.Labels)
+	}
+	return schema.String()
+}
+
+// sendAccumulatedDiff merges accumulated frames like sendAccumulatedFrames, but only rows since changedFrom
+// are sent when the schema is the same as the schema of the previous frame, zero changedFrom sends the whole frame
+func (ds *ClickHouseDatasource) sendAccumulatedDiff(
+	sender streamFrameSender,
+	accumulated map[string]*data.Frame,
+	sq *streamQuery,
+	tickCount int,
+	pacer *streamPacer,
+	diff *streamDiff,
+	changedFrom time.Time,
+) {
+	wide := mergeFramesToWide(accumulated)
+	if wide == nil {
+		backend.Logger.Debug(fmt.Sprintf("[streaming] tick #%d | DELTA: nothing to send", tickCount))
+		return
+	}
+	if frameTimeFieldIdx(wide) < 0 {
+		ds.sendAccumulatedFrames(sender, accumulated, sq, tickCount, pacer)
+		return
+	}
+	// the single accumulated frame is changed by next ticks, while sessions keep the sent frame for late subscribers
+	if len(accumulated) == 1 {
+		wide = sliceFrameByTime(wide, time.Time{}, time.Time{})
+	}
+	wide.RefID = sq.RefId
+	addStreamingNotice(wide, "delta", tickCount, wide.Rows(), pacer)
+
+	schema := frameSchema(wide)
+	if changedFrom.IsZero() || schema != diff.schema {
+		diff.schema = schema
+		if err := sender.SendFrame(wide, data.IncludeAll); err != nil {
+			backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | SendFrame ERROR: %s", tickCount, err))
+			return
+		}
+		backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | refId=%s | schema and rows=%d", tickCount, sq.RefId, wide.Rows()))
+		return
+	}
+
+	changed := sliceFrameByTime(wide, changedFrom, time.Time{})
+	if changed.Rows() == 0 {
+		return
+	}
+	var err error
+	if diffSender, ok := sender.(streamDiffSender); ok {
+		err = diffSender.SendDiff(changed, wide)
+	} else {
+		err = sender.SendFrame(changed, data.IncludeDataOnly)
+	}
+	if err != nil {
+		backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | SendFrame ERROR: %s", tickCount, err))
+		return
+	}
+	backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | refId=%s | changed rows=%d of %d",
+		tickCount, sq.RefId, changed.Rows(), wide.Rows()))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendAccumulatedDiff(t *testing.T) {
+	ds := &ClickHouseDatasource{}
+	pacer := newStreamPacer(time.Second, 0)
+	defer pacer.stop()
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	minutes := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Minute) }
+	accumulated := map[string]*data.Frame{
+		"host0": data.NewFrame("", data.NewField("t", nil, []time.Time{minutes(0), minutes(1)}), data.NewField("host0", nil, []float64{1, 2})),
+	}
+	sq := &streamQuery{RefId: "A", StreamingDiff: true}
+
+	var sessions streamSessions
+	a := &streamSubscriber{sender: &testStreamSender{}, refId: "A"}
+	session := sessions.join(context.Background(), "key", "ch", a, func(ctx context.Context, _ streamFrameSender) error {
+		<-ctx.Done()
+		return nil
+	})
+	defer sessions.leave(session, a)
+	var diff streamDiff
+	ds.sendAccumulatedDiff(session, accumulated, sq, 1, pacer, &diff, time.Time{})
+
+	// the lookback re-queries the last bucket
+	accumulated["host0"] = upsertFrameRows(accumulated["host0"], data.NewFrame("", data.NewField("t", nil, []time.Time{minutes(1), minutes(2)}), data.NewField("host0", nil, []float64{20, 3})))
+	ds.sendAccumulatedDiff(session, accumulated, sq, 2, pacer, &diff, minutes(1))
+
+	sender := a.sender.(*testStreamSender)
+	require.Equal(t, []data.FrameInclude{data.IncludeAll, data.IncludeDataOnly}, sender.includes)
+	require.Equal(t, 2, sender.frames[0].Rows())
+	require.Equal(t, 2, sender.frames[1].Rows())
+	require.Equal(t, 20.0, sender.frames[1].Fields[1].At(0))
+	require.Equal(t, "A", sender.frames[1].RefID)
+
+	// the subscriber which joins later gets the whole frame with the schema
+	b := &streamSubscriber{sender: &testStreamSender{}, refId: "B"}
+	sessions.join(context.Background(), "key", "ch", b, nil)
+	defer sessions.leave(session, b)
+	late := b.sender.(*testStreamSender)
+	require.Equal(t, []data.FrameInclude{data.IncludeAll}, late.includes)
+	require.Equal(t, 3, late.frames[0].Rows())
+
+	// a new series changes the schema
+	accumulated["host1"] = data.NewFrame("", data.NewField("t", nil, []time.Time{minutes(2)}), data.NewField("host1", nil, []float64{5}))
+	ds.sendAccumulatedDiff(session, accumulated, sq, 3, pacer, &diff, minutes(2))
+	require.Equal(t, data.IncludeAll, sender.includes[2])
+	require.Len(t, sender.frames[2].Fields, 3)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	// defaultStreamingLatencyBudget is the fraction of the poll interval which a tick could take before the stream backs off
+	defaultStreamingLatencyBudget = 0.5
+	// maxStreamingBackoff limits how many times the poll interval is extended
+	maxStreamingBackoff = 16
+)
+
+// overloadErrorCodes are ClickHouse error codes which mean the server is busy:
+// TIMEOUT_EXCEEDED, TOO_SLOW, QUOTA_EXCEEDED, TOO_MANY_SIMULTANEOUS_QUERIES, NO_FREE_CONNECTION,
+// MEMORY_LIMIT_EXCEEDED, CANNOT_SCHEDULE_TASK
+var overloadErrorCodes = map[int]bool{159: true, 160: true, 201: true, 202: true, 203: true, 241: true, 439: true}
+
+// querySummary sums X-ClickHouse-Summary headers of queries executed with the context of withQuerySummary
+type querySummary struct {
+	mu        sync.Mutex
+	queries   int
+	readRows  uint64
+	readBytes uint64
+}
+
+type querySummaryKey struct{}
+
+// withQuerySummary returns the context which collects summaries of its queries
+func withQuerySummary(ctx context.Context) (context.Context, *querySummary) {
+	summary := &querySummary{}
+	return context.WithValue(ctx, querySummaryKey{}, summary), summary
+}
+
+// recordQuerySummary adds X-ClickHouse-Summary header to the summary of the context, ClickHouse sends the header
+// before the result, so read rows are complete only for queries with wait_end_of_query=1 or buffered results
+func recordQuerySummary(ctx context.Context, header http.Header) {
+	summary, ok := ctx.Value(querySummaryKey{}).(*querySummary)
+	if !ok {
+		return
+	}
+	var parsed struct {
+		ReadRows  uint64 `json:"read_rows,string"`
+		ReadBytes uint64 `json:"read_bytes,string"`
+	}
+	if raw := header.Get("X-ClickHouse-Summary"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+			backend.Logger.Debug(fmt.Sprintf("unable to parse X-ClickHouse-Summary %s: %v", raw, err))
+		}
+	}
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	summary.queries++
+	summary.readRows += parsed.ReadRows
+	summary.readBytes += parsed.ReadBytes
+}
+
+// isOverloadError checks whether the query failed because ClickHouse is busy
+func isOverloadError(err error) bool {
+	var chErr *ClickHouseError
+	if errors.As(err, &chErr) {
+		return overloadErrorCodes[chErr.Code] || chErr.StatusCode == http.StatusTooManyRequests || chErr.StatusCode == http.StatusServiceUnavailable
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// streamPacer adapts the poll interval of the stream to the cost of its queries: the interval is doubled
+// when a tick takes longer than budget of the interval or ClickHouse is overloaded, and it's halved back
+// to the configured one when ticks take less than a half of the budget
+type streamPacer struct {
+	ticker   *time.Ticker
+	base     time.Duration
+	interval time.Duration
+	budget   float64
+
+	// last tick measurements, they're shown in the streaming notice
+	latency  time.Duration
+	readRows uint64
+}
+
+func newStreamPacer(base time.Duration, budget float64) *streamPacer {
+	if budget <= 0 {
+		budget = defaultStreamingLatencyBudget
+	}
+	return &streamPacer{ticker: time.NewTicker(base), base: base, interval: base, budget: budget}
+}
+
+func (p *streamPacer) stop() {
+	p.ticker.Stop()
+}
+
+// measure executes the tick query and adjusts the interval by its latency, read rows and error
+func (p *streamPacer) measure(ctx context.Context, query func(ctx context.Context) backend.DataResponse) backend.DataResponse {
+	summaryCtx, summary := withQuerySummary(ctx)
+	start := time.Now()
+	response := query(summaryCtx)
+	if ctx.Err() != nil {
+		return response
+	}
+	p.latency = time.Since(start)
+	summary.mu.Lock()
+	p.readRows = summary.readRows
+	summary.mu.Unlock()
+	p.adjust(isOverloadError(response.Error))
+	return response
+}
+
+func (p *streamPacer) adjust(overloaded bool) {
+	limit := time.Duration(float64(p.interval) * p.budget)
+	interval := p.interval
+	switch {
+	case overloaded || p.latency > limit:
+		interval = min(p.interval*2, p.base*maxStreamingBackoff)
+	case p.latency < limit/2:
+		interval = max(p.interval/2, p.base)
+	}
+	if interval == p.interval {
+		return
+	}
+	backend.Logger.Info(fmt.Sprintf("[streaming] poll interval %s -> %s | latency=%s | readRows=%d | overloaded=%v",
+		p.interval, interval, p.latency.Round(time.Millisecond), p.readRows, overloaded))
+	p.interval = interval
+	p.ticker.Reset(interval)
+}
+
+// String describes the effective interval for the streaming notice
+func (p *streamPacer) String() string {
+	text := fmt.Sprintf("interval %s", p.interval)
+	if p.interval != p.base {
+		text += fmt.Sprintf(" (backed off from %s)", p.base)
+	}
+	return text + fmt.Sprintf(" | query %s, %d rows read", p.latency.Round(time.Millisecond), p.readRows)
+}
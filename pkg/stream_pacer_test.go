@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamPacerAdjust(t *testing.T) {
+	pacer := newStreamPacer(time.Second, 0.5)
+	defer pacer.stop()
+	tick := func(latency time.Duration, overloaded bool) time.Duration {
+		pacer.latency = latency
+		pacer.adjust(overloaded)
+		return pacer.interval
+	}
+	require.Equal(t, time.Second, tick(100*time.Millisecond, false))
+	// slower than a half of the interval
+	require.Equal(t, 2*time.Second, tick(600*time.Millisecond, false))
+	require.Equal(t, 2*time.Second, tick(600*time.Millisecond, false))
+	require.Equal(t, 4*time.Second, tick(100*time.Millisecond, true))
+	for i := 0; i < 10; i++ {
+		tick(time.Minute, false)
+	}
+	require.Equal(t, maxStreamingBackoff*time.Second, pacer.interval)
+	require.Contains(t, pacer.String(), "interval 16s (backed off from 1s)")
+
+	// the interval is restored when the load drops
+	for i := 0; i < 10; i++ {
+		tick(time.Millisecond, false)
+	}
+	require.Equal(t, time.Second, pacer.interval)
+	require.Equal(t, "interval 1s | query 1ms, 0 rows read", pacer.String())
+}
+
+func TestStreamPacerMeasure(t *testing.T) {
+	pacer := newStreamPacer(time.Second, 0)
+	defer pacer.stop()
+	require.Equal(t, defaultStreamingLatencyBudget, pacer.budget)
+
+	response := pacer.measure(context.Background(), func(ctx context.Context) backend.DataResponse {
+		for _, summary := range []string{`{"read_rows":"1000","read_bytes":"8000"}`, `{"read_rows":"24","read_bytes":"192"}`} {
+			header := http.Header{}
+			header.Set("X-ClickHouse-Summary", summary)
+			recordQuerySummary(ctx, header)
+		}
+		return backend.DataResponse{Error: &ClickHouseError{Code: 202, StatusCode: http.StatusInternalServerError}}
+	})
+	require.Error(t, response.Error)
+	require.Equal(t, uint64(1024), pacer.readRows)
+	require.Equal(t, 2*time.Second, pacer.interval)
+
+	frame := data.NewFrame("")
+	addStreamingNotice(frame, "delta", 3, 10, pacer)
+	require.Contains(t, frame.Meta.Notices[0].Text, "Streaming: delta mode | tick #3 | 10 rows | interval 2s (backed off from 1s) | query ")
+	require.Contains(t, frame.Meta.Notices[0].Text, "1024 rows read")
+
+	// queries without the collector aren't recorded
+	recordQuerySummary(context.Background(), http.Header{})
+}
+
+func TestIsOverloadError(t *testing.T) {
+	require.True(t, isOverloadError(fmt.Errorf("query: %w", &ClickHouseError{Code: 241})))
+	require.True(t, isOverloadError(&ClickHouseError{StatusCode: http.StatusServiceUnavailable}))
+	require.True(t, isOverloadError(context.DeadlineExceeded))
+	require.False(t, isOverloadError(&ClickHouseError{Code: 62, StatusCode: http.StatusBadRequest}))
+	require.False(t, isOverloadError(nil))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// streamFrameSender is implemented by backend.StreamSender and streamSession
+type streamFrameSender interface {
+	SendFrame(frame *data.Frame, include data.FrameInclude) error
+}
+
+// streamSubscriber is one RunStream call, frames are sent to it with its refId
+type streamSubscriber struct {
+	sender streamFrameSender
+	refId  string
+}
+
+// streamSession is the polling loop and the accumulated frames shared by all subscribers
+// of streams with the same query, datasource and time window
+type streamSession struct {
+	key        string
+	datasource string
+	cancel     context.CancelFunc
+	// done is closed when the polling loop returns, err is the error of the loop
+	done chan struct{}
+	err  error
+
+	mu          sync.Mutex
+	subscribers map[*streamSubscriber]struct{}
+	lastFrame   *data.Frame
+	lastInclude data.FrameInclude
+}
+
+// SendFrame sends the frame to each subscriber, the last frame is kept for subscribers which join later
+func (s *streamSession) SendFrame(frame *data.Frame, include data.FrameInclude) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFrame, s.lastInclude = frame, include
+	return s.broadcast(frame, include)
+}
+
+// SendDiff sends the data only frame to each subscriber, snapshot is the whole frame which is kept for subscribers
+// which join later, they get it with the schema
+func (s *streamSession) SendDiff(diff, snapshot *data.Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFrame, s.lastInclude = snapshot, data.IncludeAll
+	return s.broadcast(diff, data.IncludeDataOnly)
+}
+
+// broadcast sends the frame to each subscriber, it fails only when no subscriber got the frame, s.mu must be held
+func (s *streamSession) broadcast(frame *data.Frame, include data.FrameInclude) error {
+	var sendErr error
+	sent := len(s.subscribers) == 0
+	for subscriber := range s.subscribers {
+		if err := subscriber.send(frame, include); err != nil {
+			backend.Logger.Warn(fmt.Sprintf("[streaming] unable to send frame to refId=%s: %v", subscriber.refId, err))
+			sendErr = err
+			continue
+		}
+		sent = true
+	}
+	if sent {
+		return nil
+	}
+	return sendErr
+}
+
+// replay sends the last frame to the subscriber which joined the running session
+func (s *streamSession) replay(subscriber *streamSubscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastFrame == nil {
+		return
+	}
+	if err := subscriber.send(s.lastFrame, s.lastInclude); err != nil {
+		backend.Logger.Warn(fmt.Sprintf("[streaming] unable to replay frame to refId=%s: %v", subscriber.refId, err))
+	}
+}
+
+// send replaces refId of the frame by the subscriber refId, fields are shared, they're only serialized
+func (subscriber *streamSubscriber) send(frame *data.Frame, include data.FrameInclude) error {
+	copied := *frame
+	copied.RefID = subscriber.refId
+	return subscriber.sender.SendFrame(&copied, include)
+}
+
+// streamSessions counts subscribers of each session, the polling loop starts with the first subscriber
+// and stops when the last one leaves
+type streamSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*streamSession
+}
+
+// join adds the subscriber to the session with the key, run is started when there's no such session,
+// the session context keeps values of ctx, but it's cancelled only by leave
+func (r *streamSessions) join(ctx context.Context, key, datasourceUID string, subscriber *streamSubscriber, run func(ctx context.Context, sender streamFrameSender) error) *streamSession {
+	r.mu.Lock()
+	if r.sessions == nil {
+		r.sessions = make(map[string]*streamSession)
+	}
+	session, running := r.sessions[key]
+	if !running {
+		sessionCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		session = &streamSession{
+			key:         key,
+			datasource:  datasourceUID,
+			cancel:      cancel,
+			done:        make(chan struct{}),
+			subscribers: make(map[*streamSubscriber]struct{}),
+		}
+		r.sessions[key] = session
+		go func() {
+			var err error
+			defer func() {
+				// the loop runs outside of the gRPC handler, so its recovery doesn't catch panics of ticks,
+				// the session ends with an error instead of crashing the plugin process
+				if p := recover(); p != nil {
+					backend.Logger.Error(fmt.Sprintf("[streaming] session %.12s | panic: %v\n%s", key, p, debug.Stack()))
+					err = fmt.Errorf("streaming session failed: %v", p)
+				}
+				r.mu.Lock()
+				if r.sessions[key] == session {
+					delete(r.sessions, key)
+				}
+				r.mu.Unlock()
+				cancel()
+				session.err = err
+				close(session.done)
+			}()
+			err = run(sessionCtx, session)
+		}()
+	}
+	session.mu.Lock()
+	session.subscribers[subscriber] = struct{}{}
+	subscribers := len(session.subscribers)
+	session.mu.Unlock()
+	r.mu.Unlock()
+
+	backend.Logger.Info(fmt.Sprintf("[streaming] session %.12s | refId=%s joined | subscribers=%d", key, subscriber.refId, subscribers))
+	if running {
+		session.replay(subscriber)
+	}
+	return session
+}
+
+// running checks whether subscribers of the key would join the running session
+func (r *streamSessions) running(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.sessions[key]
+	return ok
+}
+
+// count returns the number of polling loops of the datasource
+func (r *streamSessions) count(datasourceUID string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, session := range r.sessions {
+		if session.datasource == datasourceUID {
+			n++
+		}
+	}
+	return n
+}
+
+// leave removes the subscriber and stops the polling loop of the session without subscribers
+func (r *streamSessions) leave(session *streamSession, subscriber *streamSubscriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session.mu.Lock()
+	delete(session.subscribers, subscriber)
+	subscribers := len(session.subscribers)
+	session.mu.Unlock()
+
+	backend.Logger.Info(fmt.Sprintf("[streaming] session %.12s | refId=%s left | subscribers=%d", session.key, subscriber.refId, subscribers))
+	if subscribers > 0 {
+		return
+	}
+	if r.sessions[session.key] == session {
+		delete(r.sessions, session.key)
+	}
+	session.cancel()
+}
+
+// streamSessionKey identifies the session by the checkpoint key and the start of the window,
+// see streamCheckpointKey
+func streamSessionKey(pluginContext backend.PluginContext, sq *streamQuery, windowFrom time.Time, window time.Duration) string {
+	sum := sha256.Sum256([]byte(streamCheckpointKey(pluginContext, sq, window) + "/" + strconv.FormatInt(windowFrom.Unix(), 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+// streamCheckpointKey identifies checkpoints of the delta mode by the datasource, the query without refId
+// and the length of the window. The start of the window isn't a part of it: relative ranges move it on every reload,
+// and rows of the checkpoint before it are dropped by streamCheckpoints.load.
+func streamCheckpointKey(pluginContext backend.PluginContext, sq *streamQuery, window time.Duration) string {
+	q := *sq
+	q.RefId = ""
+	q.TimeRange.From, q.TimeRange.To = "", ""
+	var datasourceUID string
+	if pluginContext.DataSourceInstanceSettings != nil {
+		datasourceUID = pluginContext.DataSourceInstanceSettings.UID
+	}
+	key, _ := json.Marshal(struct {
+		OrgID      int64
+		Datasource string
+		Query      streamQuery
+		Window     time.Duration
+	}{pluginContext.OrgID, datasourceUID, q, window})
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
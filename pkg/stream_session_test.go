@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+type testStreamSender struct {
+	mu       sync.Mutex
+	frames   []*data.Frame
+	includes []data.FrameInclude
+}
+
+func (s *testStreamSender) SendFrame(frame *data.Frame, include data.FrameInclude) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames = append(s.frames, frame)
+	s.includes = append(s.includes, include)
+	return nil
+}
+
+func (s *testStreamSender) refIds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refIds := make([]string, len(s.frames))
+	for i, frame := range s.frames {
+		refIds[i] = frame.RefID
+	}
+	return refIds
+}
+
+func TestStreamSessionsShareLoop(t *testing.T) {
+	var sessions streamSessions
+	var starts atomic.Int32
+	sendFrame := make(chan struct{})
+	stopped := make(chan struct{})
+	run := func(ctx context.Context, sender streamFrameSender) error {
+		starts.Add(1)
+		defer close(stopped)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-sendFrame:
+				_ = sender.SendFrame(data.NewFrame("wide"), data.IncludeAll)
+				sendFrame <- struct{}{}
+			}
+		}
+	}
+	send := func() {
+		sendFrame <- struct{}{}
+		<-sendFrame
+	}
+
+	a := &streamSubscriber{sender: &testStreamSender{}, refId: "A"}
+	sessionA := sessions.join(context.Background(), "key", "ch", a, run)
+	send()
+
+	b := &streamSubscriber{sender: &testStreamSender{}, refId: "B"}
+	sessionB := sessions.join(context.Background(), "key", "ch", b, run)
+	require.Same(t, sessionA, sessionB)
+	// the late subscriber gets the last frame at once
+	require.Equal(t, []string{"B"}, b.sender.(*testStreamSender).refIds())
+	send()
+	require.Equal(t, []string{"A", "A"}, a.sender.(*testStreamSender).refIds())
+	require.Equal(t, []string{"B", "B"}, b.sender.(*testStreamSender).refIds())
+	require.Equal(t, int32(1), starts.Load())
+
+	sessions.leave(sessionA, a)
+	send()
+	require.Len(t, a.sender.(*testStreamSender).frames, 2)
+	require.Len(t, b.sender.(*testStreamSender).frames, 3)
+
+	sessions.leave(sessionB, b)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("polling loop isn't stopped after the last subscriber left")
+	}
+	<-sessionB.done
+	require.Empty(t, sessions.sessions)
+}
+
+func TestStreamSessionError(t *testing.T) {
+	var sessions streamSessions
+	subscriber := &streamSubscriber{sender: &testStreamSender{}, refId: "A"}
+	session := sessions.join(context.Background(), "key", "ch", subscriber, func(ctx context.Context, sender streamFrameSender) error {
+		return errors.New("delta mode validation")
+	})
+	<-session.done
+	require.EqualError(t, session.err, "delta mode validation")
+	sessions.leave(session, subscriber)
+	require.Empty(t, sessions.sessions)
+}
+
+func TestStreamSessionPanic(t *testing.T) {
+	var sessions streamSessions
+	subscriber := &streamSubscriber{sender: &testStreamSender{}, refId: "A"}
+	session := sessions.join(context.Background(), "key", "ch", subscriber, func(ctx context.Context, sender streamFrameSender) error {
+		panic("field type mismatch")
+	})
+	<-session.done
+	require.EqualError(t, session.err, "streaming session failed: field type mismatch")
+	require.False(t, sessions.running("key"))
+	sessions.leave(session, subscriber)
+	require.Empty(t, sessions.sessions)
+}
+
+func TestStreamSessionKey(t *testing.T) {
+	pluginContext := backend.PluginContext{OrgID: 1, DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "ch"}}
+	window := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	sq := &streamQuery{RefId: "A", Query: "SELECT $timeSeries AS t, count() FROM t WHERE $timeFilter GROUP BY t", StreamingMode: "delta"}
+	sq.TimeRange.From = "2024-01-15T10:00:01.123Z"
+	key := streamSessionKey(pluginContext, sq, window, time.Hour)
+
+	other := *sq
+	other.RefId = "B"
+	other.TimeRange.From = "2024-01-15T10:00:02.456Z"
+	require.Equal(t, key, streamSessionKey(pluginContext, &other, window, time.Hour))
+
+	require.NotEqual(t, key, streamSessionKey(pluginContext, sq, window.Add(time.Minute), time.Hour))
+	// ranges with the same start and another length trim accumulated rows to other windows
+	require.NotEqual(t, key, streamSessionKey(pluginContext, sq, window, 6*time.Hour))
+	other.StreamingMode = "full"
+	require.NotEqual(t, key, streamSessionKey(pluginContext, &other, window, time.Hour))
+	otherDatasource := backend.PluginContext{OrgID: 1, DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "ch2"}}
+	require.NotEqual(t, key, streamSessionKey(otherDatasource, sq, window, time.Hour))
+}
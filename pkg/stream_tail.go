@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// tailBatchRows limits rows of one tail query, the next batch is queried at once when the limit is reached
+const tailBatchRows = 10000
+
+// minTailStreamingIntervalMs is the minimal poll interval of the tail mode, its queries fetch only new rows
+const minTailStreamingIntervalMs = 200
+
+var tailColumnRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// runTailLoop implements the tail streaming mode for log and event panels. Instead of time buckets it follows
+// the monotonically increasing StreamingTailColumn, e.g. an offset or an insert time: the first poll fetches
+// the last tailBatchRows rows, next polls fetch rows with the key greater than the key of the last sent row,
+// and only new rows are sent. When there are several frames, the key of the last frame is used.
+//
+// The tail condition wraps the query after macros are applied, see tailQuery. Time macros of the first poll get
+// [dashboardFrom, now], next polls start at the latest time of sent rows, so ClickHouse doesn't scan the whole
+// dashboard range to find a few new rows. Rows which arrive later with an earlier time aren't found then.
+// When a poll returns the full batch, the next one is sent at once, so bursts are drained without waiting
+// for the ticker.
+func (ds *ClickHouseDatasource) runTailLoop(
+	ctx context.Context,
+	pluginContext backend.PluginContext,
+	sender streamFrameSender,
+	sq *streamQuery,
+	dashboardFrom time.Time,
+	pacer *streamPacer,
+) error {
+	tickCount := 0
+	tail := *sq
+	// lastTime is the latest time of sent rows, the time range of next polls starts at it
+	lastTime := dashboardFrom
+	poll := func() bool {
+		tickCount++
+		backend.Logger.Debug(fmt.Sprintf("[streaming] tick #%d | TAIL | %s > %s | from=%s", tickCount, sq.StreamingTailColumn, tail.tailKey, lastTime.Format("15:04:05")))
+
+		response := ds.executeStreamTick(ctx, pacer, pluginContext, &tail, lastTime, time.Now())
+		if response.Error != nil {
+			if ctx.Err() == nil {
+				backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | QUERY ERROR: %s", tickCount, response.Error))
+				ds.sendErrorFrame(sender, sq.RefId, response.Error.Error())
+			}
+			return false
+		}
+		frames := joinColumnFrames(response.Frames)
+		key, err := lastTailKey(frames, sq.StreamingTailColumn)
+		if err != nil {
+			ds.sendErrorFrame(sender, sq.RefId, err.Error())
+			return false
+		}
+		if key != "" {
+			tail.tailKey = key
+		}
+		full := false
+		for _, frame := range frames {
+			if frame.Rows() == 0 {
+				continue
+			}
+			lastTime = latestFrameTime(frame, lastTime)
+			full = full || frame.Rows() >= tailBatchRows
+			frame.RefID = sq.RefId
+			addStreamingNotice(frame, "tail", tickCount, frame.Rows(), pacer)
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | SendFrame ERROR: %s", tickCount, err))
+			}
+		}
+		return full
+	}
+
+	for {
+		for poll() && ctx.Err() == nil {
+		}
+		select {
+		case <-ctx.Done():
+			backend.Logger.Info(fmt.Sprintf("[streaming] RunStream STOPPED | refId=%s | totalTicks=%d", sq.RefId, tickCount))
+			return nil
+		case <-pacer.ticker.C:
+		}
+	}
+}
+
+// tailUnsupportedMacroPattern matches macros which aggregate rows by time buckets or expand to whole queries,
+// rows of their results change until buckets are complete, so they can't be followed by the tail column
+var tailUnsupportedMacroPattern = regexp.MustCompile(`\$(?:` +
+	`timeSeries\b|timeSeriesMs\b|naturalTimeSeries\b|` +
+	`columns\b|columnsMs\b|` +
+	`rate\b|rateColumns\b|rateColumnsAggregated\b|` +
+	`perSecond\b|perSecondColumns\b|perSecondColumnsAggregated\b|` +
+	`delta\b|deltaColumns\b|deltaColumnsAggregated\b|` +
+	`increase\b|increaseColumns\b|increaseColumnsAggregated\b|` +
+	`lttb\b|lttbMs\b` +
+	`)`)
+
+// tailTrailingClauseRE matches FORMAT and SETTINGS clauses at the end of the query, they can't be a part of the subquery
+var tailTrailingClauseRE = regexp.MustCompile(`(?is)\b(FORMAT|SETTINGS)\s+(\w+)(\s*=[^()]*)?\s*;?\s*$`)
+
+// validateTailQuery rejects queries which tailQuery can't wrap into valid SQL
+func validateTailQuery(query string) error {
+	if macro := tailUnsupportedMacroPattern.FindString(query); macro != "" {
+		return fmt.Errorf("tail streaming mode follows rows of the query, %s aggregates them by time, use delta streaming mode instead", macro)
+	}
+	if clause := tailTrailingClauseRE.FindStringSubmatch(query); clause != nil {
+		keyword, isSetting := strings.ToUpper(clause[1]), clause[3] != ""
+		// "ORDER BY format DESC" and "WHERE format = 'json'" refer to columns
+		if (keyword == "SETTINGS" && isSetting) || (keyword == "FORMAT" && !isSetting && !isSortDirection(clause[2])) {
+			return fmt.Errorf("tail streaming mode wraps the query into a subquery, %s at the end of the query isn't supported", keyword)
+		}
+	}
+	return nil
+}
+
+func isSortDirection(word string) bool {
+	return strings.EqualFold(word, "ASC") || strings.EqualFold(word, "DESC")
+}
+
+// tailQuery wraps the query with applied macros, so it returns rows after lastKey ordered by the tail column,
+// without lastKey it returns the last tailBatchRows rows
+func tailQuery(query, column, lastKey string) string {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	if lastKey == "" {
+		return fmt.Sprintf("SELECT * FROM (SELECT * FROM (\n%s\n) ORDER BY %s DESC LIMIT %d) ORDER BY %s", query, column, tailBatchRows, column)
+	}
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) WHERE %s > %s ORDER BY %s LIMIT %d", query, column, lastKey, column, tailBatchRows)
+}
+
+// latestFrameTime returns the latest time of the frame rows, or latest when the frame doesn't have later rows
+func latestFrameTime(frame *data.Frame, latest time.Time) time.Time {
+	timeIdx := frameTimeFieldIdx(frame)
+	if timeIdx < 0 {
+		return latest
+	}
+	for r := 0; r < frame.Rows(); r++ {
+		if t, ok := frame.Fields[timeIdx].ConcreteAt(r); ok && t.(time.Time).After(latest) {
+			latest = t.(time.Time)
+		}
+	}
+	return latest
+}
+
+// joinColumnFrames joins frames of the table format, which are a frame per column, into one frame,
+// so new rows are sent as a whole
+func joinColumnFrames(frames data.Frames) data.Frames {
+	if len(frames) < 2 {
+		return frames
+	}
+	joined := data.NewFrame("")
+	joined.RefID = frames[0].RefID
+	for _, frame := range frames {
+		if len(frame.Fields) != 1 || frame.Rows() != frames[0].Rows() {
+			return frames
+		}
+		joined.Fields = append(joined.Fields, frame.Fields[0])
+	}
+	return data.Frames{joined}
+}
+
+// lastTailKey returns the SQL literal of the last non-null value of the tail column
+func lastTailKey(frames data.Frames, column string) (string, error) {
+	found := false
+	key := ""
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if field.Name != column {
+				continue
+			}
+			found = true
+			for r := field.Len() - 1; r >= 0; r-- {
+				if value, ok := field.ConcreteAt(r); ok {
+					literal, err := tailKeyLiteral(value)
+					if err != nil {
+						return "", err
+					}
+					key = literal
+					break
+				}
+			}
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("tail column %s isn't in the query result", column)
+	}
+	return key, nil
+}
+
+func tailKeyLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return fmt.Sprintf("fromUnixTimestamp64Nano(toInt64(%d))", v.UnixNano()), nil
+	case string:
+		return "'" + strings.ReplaceAll(strings.ReplaceAll(v, `\`, `\\`), "'", `\'`) + "'", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("tail column type %T isn't supported, use a number, a string or a time column", value)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTailKeyLiteral(t *testing.T) {
+	for value, expected := range map[interface{}]string{
+		uint64(18446744073709551615): "18446744073709551615",
+		int32(-5):                    "-5",
+		1.5:                          "1.5",
+		`it's \ here`:                `'it\'s \\ here'`,
+		time.Unix(1705312800, 123):   "fromUnixTimestamp64Nano(toInt64(1705312800000000123))",
+	} {
+		literal, err := tailKeyLiteral(value)
+		require.NoError(t, err)
+		require.Equal(t, expected, literal)
+	}
+	_, err := tailKeyLiteral(true)
+	require.Error(t, err)
+
+	offset := uint64(7)
+	frames := joinColumnFrames(data.Frames{
+		data.NewFrame("offset", data.NewField("offset", nil, []*uint64{&offset, nil})),
+		data.NewFrame("message", data.NewField("message", nil, []string{"a", "b"})),
+	})
+	require.Len(t, frames, 1)
+	require.Len(t, frames[0].Fields, 2)
+	key, err := lastTailKey(frames, "offset")
+	require.NoError(t, err)
+	require.Equal(t, "7", key)
+	_, err = lastTailKey(frames, "insert_time")
+	require.ErrorContains(t, err, "tail column insert_time isn't in the query result")
+}
+
+func TestRunTailLoop(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		if query == TimeZoneQuery {
+			_, _ = w.Write([]byte(`{"meta":[{"name":"timezone()","type":"String"}],"data":[{"timezone()":"UTC"}]}`))
+			return
+		}
+		mu.Lock()
+		queries = append(queries, query)
+		mu.Unlock()
+		rows := `{"ts":"1705312860","id":1,"message":"a"},{"ts":"1705312920","id":2,"message":"b"}`
+		if strings.Contains(query, "WHERE id > 2") {
+			rows = `{"ts":"1705312980","id":3,"message":"c"}`
+		} else if strings.Contains(query, "WHERE id >") {
+			rows = ``
+		}
+		_, _ = w.Write([]byte(`{"meta":[{"name":"ts","type":"DateTime('UTC')"},{"name":"id","type":"UInt32"},{"name":"message","type":"String"}],"data":[` + rows + `]}`))
+	}))
+	defer server.Close()
+
+	ds := &ClickHouseDatasource{im: &testInstanceManager{settings: &DatasourceSettings{
+		Instance:   backend.DataSourceInstanceSettings{URL: server.URL},
+		HTTPClient: server.Client(),
+	}}}
+	sq := &streamQuery{RefId: "A", Query: "SELECT ts, id, message FROM logs WHERE $timeFilter;", DateTimeCol: "ts", DateTimeType: "DATETIME",
+		Format: "logs", StreamingMode: "tail", StreamingTailColumn: "id"}
+	sender := &testStreamSender{}
+	ctx, cancel := context.WithCancel(context.Background())
+	pacer := newStreamPacer(10*time.Millisecond, 0)
+	defer pacer.stop()
+	done := make(chan error)
+	go func() {
+		done <- ds.runTailLoop(ctx, backend.PluginContext{}, sender, sq, time.Unix(1705312800, 0), pacer)
+	}()
+	// the third query is sent after the second batch
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(queries) >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the tail condition wraps the query with applied macros
+	require.True(t, strings.HasPrefix(queries[0], "SELECT * FROM (SELECT * FROM (\nSELECT ts, id, message FROM logs WHERE ts >= toDateTime(1705312800) AND ts <= "), queries[0])
+	require.Contains(t, queries[0], ") ORDER BY id DESC LIMIT 10000) ORDER BY id")
+	// next polls start at the latest time of sent rows instead of the dashboard range
+	require.Contains(t, queries[1], "WHERE ts >= toDateTime(1705312920) AND ")
+	require.Contains(t, queries[1], "WHERE id > 2 ORDER BY id LIMIT 10000")
+	require.Contains(t, queries[2], "WHERE ts >= toDateTime(1705312980) AND ")
+	require.Contains(t, queries[2], "WHERE id > 3")
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	require.Equal(t, 2, sender.frames[0].Rows())
+	require.Equal(t, 1, sender.frames[1].Rows())
+	require.Len(t, sender.frames[1].Fields, 3)
+	require.Equal(t, "c", sender.frames[1].Fields[1].At(0))
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/altinity/clickhouse-grafana/pkg/eval"
@@ -57,14 +60,22 @@ type streamQuery struct {
 	Table                  string `json:"table"`
 	MaxDataPoints          int64  `json:"maxDataPoints"`
 	StreamingInterval      int    `json:"streamingInterval"`
-	StreamingMode          string `json:"streamingMode"`    // "delta" or "full"
-	StreamingLookback      int    `json:"streamingLookback"` // number of points to re-query for partial bucket updates
+	StreamingMode          string `json:"streamingMode"`       // "delta", "full" or "tail"
+	StreamingLookback      int    `json:"streamingLookback"`   // number of points to re-query for partial bucket updates
+	StreamingTailColumn    string `json:"streamingTailColumn"` // monotonically increasing column followed by the tail mode
+	// StreamingUniqueColumn identifies rows of logs and tables with the same time, they're appended instead of upserted by time
+	StreamingUniqueColumn string `json:"streamingUniqueColumn"`
+	// StreamingDiff sends only rows changed by the tick after the first frame, see sendAccumulatedDiff
+	StreamingDiff bool `json:"streamingDiff"`
 
 	// Time range from the dashboard
 	TimeRange struct {
 		From string `json:"from"`
 		To   string `json:"to"`
 	} `json:"timeRange"`
+
+	// tailKey is the SQL literal of the tail column value of the last sent row, see runTailLoop
+	tailKey string
 }
 
 // parseIntervalSeconds extracts seconds from an interval string like "20s", "1m", "200ms".
@@ -106,9 +117,31 @@ func roundDownTo(t time.Time, intervalSec int64) time.Time {
 	return time.Unix(rounded, 0)
 }
 
-// SubscribeStream validates a subscription request and allows the client to subscribe.
-func (ds *ClickHouseDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+// SubscribeStream validates the stream query and the limits of the datasource before the stream is started.
+// Malformed requests get NotFound, streams which exceed limits of DatasourceSettings get PermissionDenied.
+func (ds *ClickHouseDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
 	backend.Logger.Debug(fmt.Sprintf("SubscribeStream called for path: %s", req.Path))
+	deny := func(status backend.SubscribeStreamStatus, err error) (*backend.SubscribeStreamResponse, error) {
+		backend.Logger.Warn(fmt.Sprintf("[streaming] subscription to %s is rejected: %v", req.Path, err))
+		return &backend.SubscribeStreamResponse{Status: status}, nil
+	}
+	if !strings.HasPrefix(req.Path, streamPathPrefix) {
+		return deny(backend.SubscribeStreamStatusNotFound, fmt.Errorf("unknown stream path, expected %s<refId>/<hash>", streamPathPrefix))
+	}
+	client, err := ds.getClient(ctx, req.PluginContext)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := newStreamConfig(req.PluginContext, req.Data, client.settings)
+	if errors.Is(err, errStreamLimit) {
+		return deny(backend.SubscribeStreamStatusPermissionDenied, err)
+	}
+	if err != nil {
+		return deny(backend.SubscribeStreamStatusNotFound, err)
+	}
+	if maxStreams := client.settings.MaxStreams; maxStreams > 0 && !ds.streams.running(cfg.key) && ds.streams.count(cfg.datasourceUID) >= maxStreams {
+		return deny(backend.SubscribeStreamStatusPermissionDenied, fmt.Errorf("%w: datasource already runs %d streams", errStreamLimit, maxStreams))
+	}
 	return &backend.SubscribeStreamResponse{
 		Status: backend.SubscribeStreamStatusOK,
 	}, nil
@@ -140,47 +173,168 @@ func frameFingerprint(frame *data.Frame) [16]byte {
 // RunStream is the core streaming loop. Supports two modes:
 //   - "delta": first tick full range, subsequent ticks only new data (reduces CH load)
 //   - "full": every tick re-queries full range, sends only when data changes
+//
+// Streams with the same query, datasource and time window share one loop, see streamSessions.
 func (ds *ClickHouseDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
 	backend.Logger.Info(fmt.Sprintf("[streaming] RunStream STARTED | path=%s", req.Path))
 
-	var sq streamQuery
-	if err := json.Unmarshal(req.Data, &sq); err != nil {
-		return fmt.Errorf("failed to unmarshal stream query: %w", err)
-	}
-
-	intervalMs := sq.StreamingInterval
-	if intervalMs < minStreamingIntervalMs {
-		intervalMs = defaultStreamingIntervalMs
+	client, err := ds.getClient(ctx, req.PluginContext)
+	if err != nil {
+		return err
 	}
-
-	mode := sq.StreamingMode
-	if mode == "" {
-		mode = "delta"
+	settings := client.settings
+	cfg, err := newStreamConfig(req.PluginContext, req.Data, settings)
+	if err != nil {
+		return err
 	}
+	sq, mode, intervalMs, queryIntervalSec, dashboardFrom, window := &cfg.query, cfg.mode, cfg.intervalMs, cfg.queryIntervalSec, cfg.dashboardFrom, cfg.window
 
 	backend.Logger.Info(fmt.Sprintf("[streaming] config | refId=%s | mode=%s | pollInterval=%dms | interval=%s | query=%.100s",
 		sq.RefId, mode, intervalMs, sq.Interval, sq.Query))
+	backend.Logger.Info(fmt.Sprintf("[streaming] dashboardFrom=%s | queryInterval=%ds",
+		dashboardFrom.Format(time.RFC3339), queryIntervalSec))
+
+	// Subscribers of the same query and time window share one polling loop, see streamSessions.
+	// The loop outlives the subscriber which started it, so it gets only the datasource settings and cfg.
+	subscriber := &streamSubscriber{sender: sender, refId: sq.RefId}
+	loopCtx := backend.WithUser(backend.WithPluginContext(ctx, cfg.pluginContext), nil)
+	session := ds.streams.join(loopCtx, cfg.key, cfg.datasourceUID, subscriber, func(sessionCtx context.Context, sessionSender streamFrameSender) error {
+		pacer := newStreamPacer(time.Duration(intervalMs)*time.Millisecond, settings.StreamingLatencyBudget)
+		defer pacer.stop()
+		switch mode {
+		case "tail":
+			return ds.runTailLoop(sessionCtx, cfg.pluginContext, sessionSender, sq, dashboardFrom, pacer)
+		case "full":
+			return ds.runFullRefreshLoop(sessionCtx, cfg.pluginContext, sessionSender, sq, dashboardFrom, pacer, queryIntervalSec)
+		default:
+			return ds.runDeltaLoop(sessionCtx, cfg.pluginContext, sessionSender, sq, dashboardFrom, window, pacer, queryIntervalSec, settings.checkpoints, cfg.checkpointKey)
+		}
+	})
+	defer ds.streams.leave(session, subscriber)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-session.done:
+		return session.err
+	}
+}
+
+// streamPathPrefix is the prefix of channel paths built by the frontend: stream/<refId>/<hash>
+const streamPathPrefix = "stream/"
+
+// errStreamLimit wraps errors of streams which exceed limits of DatasourceSettings
+var errStreamLimit = errors.New("stream limit exceeded")
+
+// streamConfig is the validated stream query with defaults applied
+type streamConfig struct {
+	query            streamQuery
+	mode             string
+	intervalMs       int
+	queryIntervalSec int64
+	// dashboardFrom is rounded down to the query interval, so subscribers of the same window share a session
+	dashboardFrom time.Time
+	// window is the length of the dashboard time range, the delta mode keeps rows of the last window
+	window        time.Duration
+	datasourceUID string
+	key           string
+	// checkpointKey doesn't change with the start of the window, so checkpoints are found after reloads
+	checkpointKey string
+	// pluginContext identifies the organization and the datasource of the session without the user of the subscriber
+	pluginContext backend.PluginContext
+}
+
+// newStreamConfig parses and validates the stream query from the subscription data
+func newStreamConfig(pluginContext backend.PluginContext, raw json.RawMessage, settings *DatasourceSettings) (*streamConfig, error) {
+	cfg := &streamConfig{}
+	sq := &cfg.query
+	if err := json.Unmarshal(raw, sq); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal stream query: %w", err)
+	}
+	if strings.TrimSpace(sq.Query) == "" {
+		return nil, errors.New("stream query is empty")
+	}
+
+	cfg.mode = sq.StreamingMode
+	if cfg.mode == "" {
+		cfg.mode = "delta"
+	}
+	switch cfg.mode {
+	case "delta":
+		// Delta queries must be scoped by time, otherwise every tick fetches the entire dataset
+		if !queryHasTimeMacro(sq.Query) {
+			return nil, errors.New("Delta streaming mode requires a time-scoping macro in the query " +
+				"(e.g. $timeFilter, $timeFilterMs, $timeSeries, $columns, $rate, etc.). " +
+				"Without it, every tick would fetch the entire dataset. " +
+				"Either add a time macro to the WHERE clause or switch to Full refresh mode.")
+		}
+	case "full":
+	case "tail":
+		if !tailColumnRE.MatchString(sq.StreamingTailColumn) {
+			return nil, fmt.Errorf("tail streaming mode requires streamingTailColumn, a monotonically increasing column "+
+				"of the query result like an offset or an insert time, got %q", sq.StreamingTailColumn)
+		}
+		if err := validateTailQuery(sq.Query); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown streamingMode %q, expected delta, full or tail", sq.StreamingMode)
+	}
 
-	ticker := time.NewTicker(time.Duration(intervalMs) * time.Millisecond)
-	defer ticker.Stop()
+	cfg.intervalMs = sq.StreamingInterval
+	if cfg.intervalMs == 0 {
+		cfg.intervalMs = defaultStreamingIntervalMs
+	}
+	minIntervalMs := minStreamingIntervalMs
+	if cfg.mode == "tail" {
+		minIntervalMs = minTailStreamingIntervalMs
+	}
+	if cfg.intervalMs < minIntervalMs {
+		return nil, fmt.Errorf("streamingInterval %dms is less than %dms", sq.StreamingInterval, minIntervalMs)
+	}
+	if cfg.intervalMs < settings.MinStreamingInterval {
+		return nil, fmt.Errorf("%w: streamingInterval %dms is less than %dms allowed by the datasource", errStreamLimit, cfg.intervalMs, settings.MinStreamingInterval)
+	}
+	if sq.StreamingLookback < 0 {
+		return nil, fmt.Errorf("streamingLookback %d is negative", sq.StreamingLookback)
+	}
 
-	dashboardFrom := time.Now().Add(-time.Duration(intervalMs) * time.Millisecond)
+	cfg.dashboardFrom = time.Now().Add(-time.Duration(cfg.intervalMs) * time.Millisecond)
 	if sq.TimeRange.From != "" {
-		if parsed, err := time.Parse(time.RFC3339, sq.TimeRange.From); err == nil {
-			dashboardFrom = parsed
+		parsed, err := time.Parse(time.RFC3339, sq.TimeRange.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeRange.from: %w", err)
+		}
+		cfg.dashboardFrom = parsed
+		if to, err := time.Parse(time.RFC3339, sq.TimeRange.To); err == nil && to.After(parsed) {
+			cfg.window = to.Sub(parsed)
 		}
 	}
 
 	// Parse query $interval to round timestamps to complete buckets.
 	// This prevents the last partial bucket from causing visual jumps.
-	queryIntervalSec := parseIntervalSeconds(sq.Interval)
-	backend.Logger.Info(fmt.Sprintf("[streaming] dashboardFrom=%s | queryInterval=%ds",
-		dashboardFrom.Format(time.RFC3339), queryIntervalSec))
-
-	if mode == "full" {
-		return ds.runFullRefreshLoop(ctx, req, sender, &sq, dashboardFrom, intervalMs, ticker, queryIntervalSec)
-	}
-	return ds.runDeltaLoop(ctx, req, sender, &sq, dashboardFrom, ticker, queryIntervalSec)
+	cfg.queryIntervalSec = parseIntervalSeconds(sq.Interval)
+	windowSec := cfg.queryIntervalSec
+	if windowSec <= 0 {
+		windowSec = int64(cfg.intervalMs / 1000)
+	}
+	cfg.dashboardFrom = roundDownTo(cfg.dashboardFrom, windowSec)
+
+	if pluginContext.DataSourceInstanceSettings != nil {
+		cfg.datasourceUID = pluginContext.DataSourceInstanceSettings.UID
+	}
+	cfg.key = streamSessionKey(pluginContext, sq, cfg.dashboardFrom, cfg.window)
+	cfg.checkpointKey = streamCheckpointKey(pluginContext, sq, cfg.window)
+	cfg.pluginContext = backend.PluginContext{
+		OrgID:                      pluginContext.OrgID,
+		PluginID:                   pluginContext.PluginID,
+		PluginVersion:              pluginContext.PluginVersion,
+		DataSourceInstanceSettings: pluginContext.DataSourceInstanceSettings,
+		GrafanaConfig:              pluginContext.GrafanaConfig,
+		APIVersion:                 pluginContext.APIVersion,
+		Namespace:                  pluginContext.Namespace,
+	}
+	return cfg, nil
 }
 
 // runDeltaLoop implements delta streaming with server-side accumulation:
@@ -191,122 +345,176 @@ func (ds *ClickHouseDatasource) RunStream(ctx context.Context, req *backend.RunS
 // This avoids Grafana's Append buffer issues with multi-frame responses (e.g. GROUP BY host)
 // while still keeping ClickHouse load low (only delta queries after tick 1).
 //
+// Accumulated frames and lastTo are saved to checkpoints with the session key, a restarted or reconnected
+// stream restores them and starts with the delta query instead of the full range.
+// Rows older than the rolling window of the dashboard range length are trimmed, streams with
+// StreamingDiff send only rows since the lookback start instead of the whole frame, see streamDiff.
+//
 // Requires time-scoping macros ($timeFilter, $timeSeries, $columns, etc.) in the query
-// so that the time range substitution actually limits the data fetched.
+// so that the time range substitution actually limits the data fetched, see newStreamConfig.
 func (ds *ClickHouseDatasource) runDeltaLoop(
 	ctx context.Context,
-	req *backend.RunStreamRequest,
-	sender *backend.StreamSender,
+	pluginContext backend.PluginContext,
+	sender streamFrameSender,
 	sq *streamQuery,
 	dashboardFrom time.Time,
-	ticker *time.Ticker,
+	window time.Duration,
+	pacer *streamPacer,
 	queryIntervalSec int64,
+	checkpoints *streamCheckpoints,
+	checkpointKey string,
 ) error {
-	// Validate that the query contains time-scoping macros
-	if !queryHasTimeMacro(sq.Query) {
-		errMsg := "Delta streaming mode requires a time-scoping macro in the query " +
-			"(e.g. $timeFilter, $timeFilterMs, $timeSeries, $columns, $rate, etc.). " +
-			"Without it, every tick would fetch the entire dataset. " +
-			"Either add a time macro to the WHERE clause or switch to Full refresh mode."
-		backend.Logger.Error(fmt.Sprintf("[streaming] DELTA VALIDATION FAILED | refId=%s | %s", sq.RefId, errMsg))
-		ds.sendErrorFrame(sender, sq.RefId, errMsg)
-		return fmt.Errorf("delta mode validation: %s", errMsg)
-	}
-
 	tickCount := 0
 	// Server-side accumulated frames, keyed by frame name
 	accumulated := map[string]*data.Frame{}
-
-	// Tick 1: full range [dashboardFrom, now] — initial data load
-	tickCount++
-	now := roundDownTo(time.Now(), queryIntervalSec)
-	backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | DELTA/INITIAL | from=%s | to=%s",
-		tickCount, dashboardFrom.Format("15:04:05"), now.Format("15:04:05")))
-
-	response := ds.executeStreamEvalQuery(req.PluginContext, ctx, sq, dashboardFrom, now)
-	if response.Error != nil {
-		backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | QUERY ERROR: %s", tickCount, response.Error))
-		ds.sendErrorFrame(sender, sq.RefId, response.Error.Error())
-	} else {
-		for _, frame := range response.Frames {
-			if frame.Rows() > 0 {
-				name := frameKey(frame)
-				accumulated[name] = frame
-			}
+	// checkpoints are saved at most every streamCheckpointInterval, the pending one is saved when the stream stops
+	var checkpointedAt time.Time
+	pendingCheckpoint := false
+	saveCheckpoint := func(lastTo time.Time, force bool) {
+		if !force && time.Since(checkpointedAt) < streamCheckpointInterval {
+			pendingCheckpoint = true
+			return
+		}
+		pendingCheckpoint, checkpointedAt = false, time.Now()
+		if err := checkpoints.save(checkpointKey, dashboardFrom, lastTo, accumulated); err != nil {
+			backend.Logger.Warn(fmt.Sprintf("[streaming] tick #%d | unable to save checkpoint: %v", tickCount, err))
 		}
-		ds.sendAccumulatedFrames(sender, accumulated, sq, tickCount)
 	}
-	lastTo := now
 
 	// Lookback: re-query N recent points to update partial buckets
 	lookbackPoints := sq.StreamingLookback
-	if lookbackPoints < 0 {
-		lookbackPoints = 0
+
+	// windowFrom is the start of the rolling window which follows now, the frontend keeps the same window
+	windowFrom := func(now time.Time) time.Time {
+		if window <= 0 || !now.Add(-window).After(dashboardFrom) {
+			return dashboardFrom
+		}
+		return roundDownTo(now.Add(-window), queryIntervalSec)
+	}
+	var diff streamDiff
+	// send sends the accumulated frames, rows since changedFrom are sent by StreamingDiff streams
+	send := func(changedFrom time.Time) {
+		if sq.StreamingDiff {
+			ds.sendAccumulatedDiff(sender, accumulated, sq, tickCount, pacer, &diff, changedFrom)
+			return
+		}
+		ds.sendAccumulatedFrames(sender, accumulated, sq, tickCount, pacer)
 	}
 
-	// Tick 2+: delta query with lookback overlap
-	for {
-		select {
-		case <-ctx.Done():
-			backend.Logger.Info(fmt.Sprintf("[streaming] RunStream STOPPED | path=%s | totalTicks=%d", req.Path, tickCount))
-			return nil
-		case <-ticker.C:
-			tickCount++
-			now = roundDownTo(time.Now(), queryIntervalSec)
+	var lastTo time.Time
+	// delta queries [lastTo - lookback, now] and merges new rows into accumulated frames
+	delta := func() {
+		now := roundDownTo(time.Now(), queryIntervalSec)
 
-			if !now.After(lastTo) {
-				backend.Logger.Debug(fmt.Sprintf("[streaming] tick #%d | DELTA: skipped (now <= lastTo)", tickCount))
-				continue
-			}
+		if !now.After(lastTo) {
+			backend.Logger.Debug(fmt.Sprintf("[streaming] tick #%d | DELTA: skipped (now <= lastTo)", tickCount))
+			return
+		}
 
-			// Shift from back by lookback to re-query recent incomplete buckets
-			deltaFrom := lastTo
-			if lookbackPoints > 0 && queryIntervalSec > 0 {
-				lookbackDuration := time.Duration(int64(lookbackPoints)*queryIntervalSec) * time.Second
-				deltaFrom = lastTo.Add(-lookbackDuration)
-				if deltaFrom.Before(dashboardFrom) {
-					deltaFrom = dashboardFrom
-				}
+		// Shift from back by lookback to re-query recent incomplete buckets
+		deltaFrom := lastTo
+		if lookbackPoints > 0 && queryIntervalSec > 0 {
+			lookbackDuration := time.Duration(int64(lookbackPoints)*queryIntervalSec) * time.Second
+			deltaFrom = lastTo.Add(-lookbackDuration)
+			if deltaFrom.Before(dashboardFrom) {
+				deltaFrom = dashboardFrom
 			}
+		}
 
-			backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | DELTA | from=%s | to=%s | lookback=%d",
-				tickCount, deltaFrom.Format("15:04:05"), now.Format("15:04:05"), lookbackPoints))
+		backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | DELTA | from=%s | to=%s | lookback=%d",
+			tickCount, deltaFrom.Format("15:04:05"), now.Format("15:04:05"), lookbackPoints))
 
-			response := ds.executeStreamEvalQuery(req.PluginContext, ctx, sq, deltaFrom, now)
-			if response.Error != nil {
-				backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | QUERY ERROR: %s", tickCount, response.Error))
-				ds.sendErrorFrame(sender, sq.RefId, response.Error.Error())
-				lastTo = now
+		response := ds.executeStreamTick(ctx, pacer, pluginContext, sq, deltaFrom, now)
+		if response.Error != nil {
+			backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | QUERY ERROR: %s", tickCount, response.Error))
+			ds.sendErrorFrame(sender, sq.RefId, response.Error.Error())
+			lastTo = now
+			return
+		}
+
+		hasNewData := false
+		for _, frame := range streamFrames(sq, response.Frames) {
+			if frame.Rows() == 0 {
 				continue
 			}
+			hasNewData = true
+			name := frameKey(frame)
+			if existing, ok := accumulated[name]; ok && isAppendOnlyFormat(sq.Format) {
+				accumulated[name] = appendFrameRows(existing, frame, sq.StreamingUniqueColumn)
+			} else if ok {
+				accumulated[name] = upsertFrameRows(existing, frame)
+			} else {
+				accumulated[name] = frame
+			}
+		}
 
-			hasNewData := false
-			for _, frame := range response.Frames {
-				if frame.Rows() == 0 {
-					continue
-				}
-				hasNewData = true
-				name := frameKey(frame)
-				if existing, ok := accumulated[name]; ok {
-					upsertFrameRows(existing, frame)
-				} else {
+		lastTo = now
+		if hasNewData {
+			// Trim data older than the rolling window to prevent unbounded memory growth
+			trimAccumulatedFrames(accumulated, windowFrom(now))
+			send(deltaFrom)
+			saveCheckpoint(lastTo, false)
+		} else {
+			backend.Logger.Debug(fmt.Sprintf("[streaming] tick #%d | DELTA: no new rows", tickCount))
+		}
+	}
+
+	tickCount++
+	if restored, restoredTo, ok := checkpoints.load(checkpointKey, dashboardFrom); ok {
+		// Tick 1: accumulated frames of the checkpoint and the delta since it
+		backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | DELTA/CHECKPOINT | lastTo=%s | series=%d",
+			tickCount, restoredTo.Format("15:04:05"), len(restored)))
+		accumulated, lastTo = restored, restoredTo
+		trimAccumulatedFrames(accumulated, windowFrom(roundDownTo(time.Now(), queryIntervalSec)))
+		send(time.Time{})
+		delta()
+	} else {
+		// Tick 1: full range [dashboardFrom, now] — initial data load
+		now := roundDownTo(time.Now(), queryIntervalSec)
+		backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | DELTA/INITIAL | from=%s | to=%s",
+			tickCount, dashboardFrom.Format("15:04:05"), now.Format("15:04:05")))
+
+		response := ds.executeStreamTick(ctx, pacer, pluginContext, sq, dashboardFrom, now)
+		if response.Error != nil {
+			backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | QUERY ERROR: %s", tickCount, response.Error))
+			ds.sendErrorFrame(sender, sq.RefId, response.Error.Error())
+		} else {
+			for _, frame := range streamFrames(sq, response.Frames) {
+				if frame.Rows() > 0 {
+					name := frameKey(frame)
 					accumulated[name] = frame
 				}
 			}
+			send(time.Time{})
+			saveCheckpoint(now, false)
+		}
+		lastTo = now
+	}
 
-			if hasNewData {
-				// Trim data older than dashboardFrom to prevent unbounded memory growth
-				trimAccumulatedFrames(accumulated, dashboardFrom)
-				ds.sendAccumulatedFrames(sender, accumulated, sq, tickCount)
-			} else {
-				backend.Logger.Debug(fmt.Sprintf("[streaming] tick #%d | DELTA: no new rows", tickCount))
+	// Tick 2+: delta query with lookback overlap
+	for {
+		select {
+		case <-ctx.Done():
+			if pendingCheckpoint {
+				saveCheckpoint(lastTo, true)
 			}
-
-			lastTo = now
+			backend.Logger.Info(fmt.Sprintf("[streaming] RunStream STOPPED | refId=%s | totalTicks=%d", sq.RefId, tickCount))
+			return nil
+		case <-pacer.ticker.C:
+			tickCount++
+			delta()
 		}
 	}
 }
 
+// streamFrames joins frames of the table format, which are a frame per column, records of such streams are merged as rows
+func streamFrames(sq *streamQuery, frames data.Frames) data.Frames {
+	if isAppendOnlyFormat(sq.Format) {
+		return joinColumnFrames(frames)
+	}
+	return frames
+}
+
 // frameKey returns a stable key for a frame, used to match frames across ticks.
 // response.go creates frames with empty Name — the unique series identifier
 // is stored in the value field's name (e.g., "host0", "host1").
@@ -328,19 +536,27 @@ func frameKey(frame *data.Frame) string {
 //   - If a timestamp is new, append the row
 //
 // This handles the lookback overlap where recent buckets are re-queried
-// and may have updated aggregation values.
-func upsertFrameRows(dst, src *data.Frame) {
+// and may have updated aggregation values. It returns dst, or src when the schema
+// has changed, e.g. a column became Nullable, since values of another type can't be set into dst.
+func upsertFrameRows(dst, src *data.Frame) *data.Frame {
 	srcRows := src.Rows()
-	if srcRows == 0 || len(dst.Fields) != len(src.Fields) || len(dst.Fields) < 2 {
-		return
+	if srcRows == 0 {
+		return dst
+	}
+	if !sameFrameSchema(dst, src) {
+		backend.Logger.Info(fmt.Sprintf("[streaming] frame schema has changed, %d accumulated rows are replaced by %d new rows", dst.Rows(), srcRows))
+		return src
+	}
+	if len(dst.Fields) < 2 {
+		return dst
 	}
 
-	// Build time -> row index lookup for dst
+	// Build time -> row index lookup for dst, keyed by nanoseconds to keep DateTime64(9) rows apart
 	dstTimeIdx := map[int64]int{}
 	dstTimeField := dst.Fields[0]
 	for r := 0; r < dst.Rows(); r++ {
 		if t, ok := dstTimeField.At(r).(time.Time); ok {
-			dstTimeIdx[t.UnixMilli()] = r
+			dstTimeIdx[t.UnixNano()] = r
 		}
 	}
 
@@ -349,7 +565,7 @@ func upsertFrameRows(dst, src *data.Frame) {
 		if !ok {
 			continue
 		}
-		if existingRow, exists := dstTimeIdx[srcTime.UnixMilli()]; exists {
+		if existingRow, exists := dstTimeIdx[srcTime.UnixNano()]; exists {
 			// Update existing row — overwrite value fields
 			for i := 1; i < len(src.Fields); i++ {
 				dst.Fields[i].Set(existingRow, src.Fields[i].At(r))
@@ -359,56 +575,98 @@ func upsertFrameRows(dst, src *data.Frame) {
 			for i, srcField := range src.Fields {
 				dst.Fields[i].Append(srcField.At(r))
 			}
-			dstTimeIdx[srcTime.UnixMilli()] = dst.Rows() - 1
+			dstTimeIdx[srcTime.UnixNano()] = dst.Rows() - 1
 		}
 	}
+	return dst
 }
 
 // trimAccumulatedFrames removes rows older than cutoff from all accumulated frames.
 // This prevents unbounded memory growth for long-running streams.
+// Rows of logs aren't ordered by time, so every row is checked.
 func trimAccumulatedFrames(accumulated map[string]*data.Frame, cutoff time.Time) {
-	cutoffMs := cutoff.UnixMilli()
 	for name, frame := range accumulated {
-		if len(frame.Fields) == 0 || frame.Rows() == 0 {
+		timeIdx := frameTimeFieldIdx(frame)
+		if timeIdx < 0 || frame.Rows() == 0 {
 			continue
 		}
-		timeField := frame.Fields[0]
-		// Find the first row >= cutoff
-		firstValid := -1
+		timeField := frame.Fields[timeIdx]
 		for r := 0; r < frame.Rows(); r++ {
-			if t, ok := timeField.At(r).(time.Time); ok && t.UnixMilli() >= cutoffMs {
-				firstValid = r
+			if t, ok := timeField.ConcreteAt(r); !ok || t.(time.Time).Before(cutoff) {
+				// Rebuild fields with only valid rows
+				accumulated[name] = sliceFrameByTime(frame, cutoff, time.Time{})
 				break
 			}
 		}
-		if firstValid <= 0 {
-			continue // nothing to trim (or all rows are valid)
-		}
-		// Rebuild fields with only valid rows
-		trimmed := data.NewFrame(frame.Name)
-		trimmed.RefID = frame.RefID
-		trimmed.Meta = frame.Meta
-		for _, field := range frame.Fields {
-			newField := data.NewFieldFromFieldType(field.Type(), 0)
-			newField.Name = field.Name
-			newField.Labels = field.Labels
-			for r := firstValid; r < field.Len(); r++ {
-				newField.Append(field.At(r))
+	}
+}
+
+// isAppendOnlyFormat checks whether rows of the format are records like log lines,
+// new rows of such streams are appended instead of upserting values by time, see appendFrameRows
+func isAppendOnlyFormat(format string) bool {
+	return format == QueryFormatLogs || format == "table"
+}
+
+// appendFrameRows appends rows of src which aren't in dst yet and returns dst. Rows are identified by the time
+// and the value of uniqueColumn, or by all values when the frame doesn't have uniqueColumn,
+// so rows re-queried by the lookback aren't duplicated, while rows with the same time are kept apart.
+// When the schema has changed, e.g. a column was added or became Nullable, src replaces dst,
+// since rows of the old schema can't be merged with the new ones.
+func appendFrameRows(dst, src *data.Frame, uniqueColumn string) *data.Frame {
+	if src.Rows() == 0 {
+		return dst
+	}
+	if !sameFrameSchema(dst, src) {
+		backend.Logger.Info(fmt.Sprintf("[streaming] frame schema has changed, %d accumulated rows are replaced by %d new rows", dst.Rows(), src.Rows()))
+		return src
+	}
+	timeIdx, uniqueIdx := frameTimeFieldIdx(dst), -1
+	for i, field := range dst.Fields {
+		if uniqueColumn != "" && field.Name == uniqueColumn {
+			uniqueIdx = i
+		}
+	}
+	rowKey := func(frame *data.Frame, r int) string {
+		var key strings.Builder
+		if timeIdx >= 0 {
+			if t, ok := frame.Fields[timeIdx].ConcreteAt(r); ok {
+				key.WriteString(strconv.FormatInt(t.(time.Time).UnixNano(), 10))
+			}
+		}
+		for i, field := range frame.Fields {
+			if i == timeIdx || (uniqueIdx >= 0 && i != uniqueIdx) {
+				continue
 			}
-			trimmed.Fields = append(trimmed.Fields, newField)
+			v, _ := field.ConcreteAt(r)
+			fmt.Fprintf(&key, "\x00%v", v)
+		}
+		return key.String()
+	}
+
+	seen := make(map[string]struct{}, dst.Rows()+src.Rows())
+	for r := 0; r < dst.Rows(); r++ {
+		seen[rowKey(dst, r)] = struct{}{}
+	}
+	for r := 0; r < src.Rows(); r++ {
+		key := rowKey(src, r)
+		if _, ok := seen[key]; ok {
+			continue
 		}
-		accumulated[name] = trimmed
+		seen[key] = struct{}{}
+		dst.AppendRow(src.RowCopy(r)...)
 	}
+	return dst
 }
 
 // sendAccumulatedFrames merges accumulated frames into a single wide-format frame
 // and sends it via Replace. Grafana's streaming Replace mode only keeps the last
 // frame sent per channel, so we must combine all series into one frame.
 func (ds *ClickHouseDatasource) sendAccumulatedFrames(
-	sender *backend.StreamSender,
+	sender streamFrameSender,
 	accumulated map[string]*data.Frame,
 	sq *streamQuery,
 	tickCount int,
+	pacer *streamPacer,
 ) {
 	wide := mergeFramesToWide(accumulated)
 	if wide == nil {
@@ -416,7 +674,7 @@ func (ds *ClickHouseDatasource) sendAccumulatedFrames(
 		return
 	}
 	wide.RefID = sq.RefId
-	addStreamingNotice(wide, "delta", tickCount, wide.Rows())
+	addStreamingNotice(wide, "delta", tickCount, wide.Rows(), pacer)
 	if err := sender.SendFrame(wide, data.IncludeAll); err != nil {
 		backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | SendFrame ERROR: %s", tickCount, err))
 		return
@@ -431,7 +689,8 @@ func (ds *ClickHouseDatasource) sendAccumulatedFrames(
 //
 // Handles frames with different row counts by building a unified time index
 // and filling missing values with nil (null). This supports GROUP BY queries
-// where some series may not have data in every time bucket.
+// where some series may not have data in every time bucket. Value fields keep
+// their types and are only made nullable, so strings aren't lost.
 //
 // Input:  {host0: [t, cpu], host1: [t, cpu], host2: [t, cpu]}
 // Output: single frame [t, host0_cpu, host1_cpu, host2_cpu]
@@ -464,31 +723,31 @@ func mergeFramesToWide(accumulated map[string]*data.Frame) *data.Frame {
 		timeField := frame.Fields[0]
 		for r := 0; r < timeField.Len(); r++ {
 			if t, ok := timeField.At(r).(time.Time); ok {
-				timeSet[t.UnixMilli()] = t
+				timeSet[t.UnixNano()] = t
 			}
 		}
 	}
 
 	// 2. Sort timestamps
-	sortedMs := make([]int64, 0, len(timeSet))
-	for ms := range timeSet {
-		sortedMs = append(sortedMs, ms)
+	sortedNanos := make([]int64, 0, len(timeSet))
+	for nanos := range timeSet {
+		sortedNanos = append(sortedNanos, nanos)
 	}
-	sort.Slice(sortedMs, func(i, j int) bool { return sortedMs[i] < sortedMs[j] })
+	sort.Slice(sortedNanos, func(i, j int) bool { return sortedNanos[i] < sortedNanos[j] })
 
-	totalRows := len(sortedMs)
-	msToIdx := make(map[int64]int, totalRows)
+	totalRows := len(sortedNanos)
+	nanosToIdx := make(map[int64]int, totalRows)
 	timeValues := make([]time.Time, totalRows)
-	for i, ms := range sortedMs {
-		msToIdx[ms] = i
-		timeValues[i] = timeSet[ms]
+	for i, nanos := range sortedNanos {
+		nanosToIdx[nanos] = i
+		timeValues[i] = timeSet[nanos]
 	}
 
 	// 3. Build wide frame with unified time field
 	wide := data.NewFrame("")
 	wide.Fields = append(wide.Fields, data.NewField("t", nil, timeValues))
 
-	// 4. For each series, create nullable value fields aligned to the time index
+	// 4. For each series, create nullable value fields of the same type aligned to the time index
 	for _, name := range names {
 		frame := accumulated[name]
 		if len(frame.Fields) < 2 {
@@ -498,22 +757,26 @@ func mergeFramesToWide(accumulated map[string]*data.Frame) *data.Frame {
 
 		for fi := 1; fi < len(frame.Fields); fi++ {
 			srcField := frame.Fields[fi]
-			values := make([]*float64, totalRows) // nil = no data
+			values := data.NewFieldFromFieldType(srcField.Type().NullableType(), totalRows) // nil = no data
+			values.Name = srcField.Name
+			values.Labels = srcField.Labels
+			values.Config = srcField.Config
 
 			for r := 0; r < frame.Rows(); r++ {
 				t, ok := timeField.At(r).(time.Time)
 				if !ok {
 					continue
 				}
-				idx, exists := msToIdx[t.UnixMilli()]
+				idx, exists := nanosToIdx[t.UnixNano()]
 				if !exists {
 					continue
 				}
-				v := toFloat64(srcField.At(r))
-				values[idx] = &v
+				if v, ok := srcField.ConcreteAt(r); ok {
+					values.SetConcrete(idx, v)
+				}
 			}
 
-			wide.Fields = append(wide.Fields, data.NewField(srcField.Name, srcField.Labels, values))
+			wide.Fields = append(wide.Fields, values)
 		}
 	}
 
@@ -527,49 +790,16 @@ func mergeFramesToWide(accumulated map[string]*data.Frame) *data.Frame {
 	return wide
 }
 
-// toFloat64 converts a data.Field value to float64.
-func toFloat64(v interface{}) float64 {
-	switch val := v.(type) {
-	case float64:
-		return val
-	case float32:
-		return float64(val)
-	case int64:
-		return float64(val)
-	case int32:
-		return float64(val)
-	case int:
-		return float64(val)
-	case uint64:
-		return float64(val)
-	case uint32:
-		return float64(val)
-	case *float64:
-		if val != nil {
-			return *val
-		}
-		return 0
-	case *float32:
-		if val != nil {
-			return float64(*val)
-		}
-		return 0
-	default:
-		return 0
-	}
-}
-
 // runFullRefreshLoop: every tick re-queries [dashboardFrom, now()].
 // Only sends data when the result actually changes (fingerprint comparison).
 // Frontend uses Replace mode.
 func (ds *ClickHouseDatasource) runFullRefreshLoop(
 	ctx context.Context,
-	req *backend.RunStreamRequest,
-	sender *backend.StreamSender,
+	pluginContext backend.PluginContext,
+	sender streamFrameSender,
 	sq *streamQuery,
 	dashboardFrom time.Time,
-	intervalMs int,
-	ticker *time.Ticker,
+	pacer *streamPacer,
 	queryIntervalSec int64,
 ) error {
 	tickCount := 0
@@ -580,19 +810,19 @@ func (ds *ClickHouseDatasource) runFullRefreshLoop(
 	now := roundDownTo(time.Now(), queryIntervalSec)
 	backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | FULL_REFRESH | from=%s | to=%s",
 		tickCount, dashboardFrom.Format("15:04:05"), now.Format("15:04:05")))
-	ds.sendFramesWithDedup(ctx, req.PluginContext, sender, sq, dashboardFrom, now, &lastFingerprint, tickCount)
+	ds.sendFramesWithDedup(ctx, pluginContext, sender, sq, dashboardFrom, now, &lastFingerprint, tickCount, pacer)
 
 	for {
 		select {
 		case <-ctx.Done():
-			backend.Logger.Info(fmt.Sprintf("[streaming] RunStream STOPPED | path=%s | totalTicks=%d", req.Path, tickCount))
+			backend.Logger.Info(fmt.Sprintf("[streaming] RunStream STOPPED | refId=%s | totalTicks=%d", sq.RefId, tickCount))
 			return nil
-		case <-ticker.C:
+		case <-pacer.ticker.C:
 			tickCount++
 			now = roundDownTo(time.Now(), queryIntervalSec)
 			backend.Logger.Info(fmt.Sprintf("[streaming] tick #%d | FULL_REFRESH | from=%s | to=%s",
 				tickCount, dashboardFrom.Format("15:04:05"), now.Format("15:04:05")))
-			ds.sendFramesWithDedup(ctx, req.PluginContext, sender, sq, dashboardFrom, now, &lastFingerprint, tickCount)
+			ds.sendFramesWithDedup(ctx, pluginContext, sender, sq, dashboardFrom, now, &lastFingerprint, tickCount, pacer)
 		}
 	}
 }
@@ -603,13 +833,14 @@ func (ds *ClickHouseDatasource) runFullRefreshLoop(
 func (ds *ClickHouseDatasource) sendFramesWithDedup(
 	ctx context.Context,
 	pluginContext backend.PluginContext,
-	sender *backend.StreamSender,
+	sender streamFrameSender,
 	sq *streamQuery,
 	from, to time.Time,
 	lastFingerprint *[16]byte,
 	tickCount int,
+	pacer *streamPacer,
 ) {
-	response := ds.executeStreamEvalQuery(pluginContext, ctx, sq, from, to)
+	response := ds.executeStreamTick(ctx, pacer, pluginContext, sq, from, to)
 	if response.Error != nil {
 		backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | QUERY ERROR: %s", tickCount, response.Error))
 		ds.sendErrorFrame(sender, sq.RefId, response.Error.Error())
@@ -618,7 +849,7 @@ func (ds *ClickHouseDatasource) sendFramesWithDedup(
 
 	// Collect non-empty frames into a map for merging
 	framesMap := map[string]*data.Frame{}
-	for _, frame := range response.Frames {
+	for _, frame := range streamFrames(sq, response.Frames) {
 		if frame.Rows() > 0 {
 			framesMap[frameKey(frame)] = frame
 		}
@@ -644,7 +875,7 @@ func (ds *ClickHouseDatasource) sendFramesWithDedup(
 	*lastFingerprint = fp
 
 	wide.RefID = sq.RefId
-	addStreamingNotice(wide, "full", tickCount, wide.Rows())
+	addStreamingNotice(wide, "full", tickCount, wide.Rows(), pacer)
 	if err := sender.SendFrame(wide, data.IncludeAll); err != nil {
 		backend.Logger.Error(fmt.Sprintf("[streaming] tick #%d | SendFrame ERROR: %s", tickCount, err))
 		return
@@ -654,6 +885,19 @@ func (ds *ClickHouseDatasource) sendFramesWithDedup(
 		tickCount, sq.RefId, len(framesMap), wide.Rows()))
 }
 
+// executeStreamTick executes the query of the tick, its latency and errors adjust the poll interval of the pacer
+func (ds *ClickHouseDatasource) executeStreamTick(
+	ctx context.Context,
+	pacer *streamPacer,
+	pluginContext backend.PluginContext,
+	sq *streamQuery,
+	from, to time.Time,
+) backend.DataResponse {
+	return pacer.measure(ctx, func(ctx context.Context) backend.DataResponse {
+		return ds.executeStreamEvalQuery(pluginContext, ctx, sq, from, to)
+	})
+}
+
 // executeStreamEvalQuery builds and executes an EvalQuery.
 func (ds *ClickHouseDatasource) executeStreamEvalQuery(
 	pluginContext backend.PluginContext,
@@ -661,7 +905,7 @@ func (ds *ClickHouseDatasource) executeStreamEvalQuery(
 	sq *streamQuery,
 	from, to time.Time,
 ) backend.DataResponse {
-	return ds.evalQuery(pluginContext, ctx, &eval.EvalQuery{
+	evalQuery := &eval.EvalQuery{
 		RefId:                  sq.RefId,
 		RawQuery:               sq.RawQuery,
 		Query:                  sq.Query,
@@ -680,14 +924,21 @@ func (ds *ClickHouseDatasource) executeStreamEvalQuery(
 		Table:                  sq.Table,
 		MaxDataPoints:          sq.MaxDataPoints,
 		FrontendDatasource:     true,
-		From:                   from,
-		To:                     to,
-	})
+		// polled results must be fresh
+		CacheTTL:  "0",
+		Streaming: true,
+		From:      from,
+		To:        to,
+	}
+	if sq.StreamingMode == "tail" {
+		evalQuery.TailColumn, evalQuery.TailKey = sq.StreamingTailColumn, sq.tailKey
+	}
+	return ds.evalQuery(pluginContext, ctx, evalQuery)
 }
 
 // addStreamingNotice attaches a streaming status notice to the frame's metadata.
-func addStreamingNotice(frame *data.Frame, mode string, tickCount int, rows int) {
-	text := fmt.Sprintf("Streaming: %s mode | tick #%d | %d rows", mode, tickCount, rows)
+func addStreamingNotice(frame *data.Frame, mode string, tickCount int, rows int, pacer *streamPacer) {
+	text := fmt.Sprintf("Streaming: %s mode | tick #%d | %d rows | %s", mode, tickCount, rows, pacer)
 	notice := data.Notice{
 		Severity: data.NoticeSeverityInfo,
 		Text:     text,
@@ -698,7 +949,7 @@ func addStreamingNotice(frame *data.Frame, mode string, tickCount int, rows int)
 	frame.Meta.Notices = []data.Notice{notice}
 }
 
-func (ds *ClickHouseDatasource) sendHeartbeat(sender *backend.StreamSender, refId string) {
+func (ds *ClickHouseDatasource) sendHeartbeat(sender streamFrameSender, refId string) {
 	frame := data.NewFrame("heartbeat",
 		data.NewField("time", nil, []time.Time{}),
 		data.NewField("value", nil, []float64{}),
@@ -707,7 +958,7 @@ func (ds *ClickHouseDatasource) sendHeartbeat(sender *backend.StreamSender, refI
 	_ = sender.SendFrame(frame, data.IncludeAll)
 }
 
-func (ds *ClickHouseDatasource) sendErrorFrame(sender *backend.StreamSender, refId, errMsg string) {
+func (ds *ClickHouseDatasource) sendErrorFrame(sender streamFrameSender, refId, errMsg string) {
 	frame := data.NewFrame("error")
 	frame.RefID = refId
 	frame.Meta = &data.FrameMeta{
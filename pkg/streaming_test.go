@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStreamConfig(t *testing.T) {
+	pluginContext := backend.PluginContext{OrgID: 1, User: &backend.User{Login: "viewer"}, DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "ch"}}
+	settings := &DatasourceSettings{MinStreamingInterval: 2000}
+	config := func(raw string) (*streamConfig, error) {
+		return newStreamConfig(pluginContext, json.RawMessage(raw), settings)
+	}
+
+	cfg, err := config(`{"refId":"A","query":"SELECT $timeSeries AS t, count() FROM t WHERE $timeFilter GROUP BY t","interval":"1m","timeRange":{"from":"2024-01-15T10:00:30Z"}}`)
+	require.NoError(t, err)
+	require.Equal(t, "delta", cfg.mode)
+	require.Equal(t, defaultStreamingIntervalMs, cfg.intervalMs)
+	require.Equal(t, int64(60), cfg.queryIntervalSec)
+	require.Equal(t, "2024-01-15T10:00:00Z", cfg.dashboardFrom.UTC().Format("2006-01-02T15:04:05Z07:00"))
+	require.Equal(t, "ch", cfg.datasourceUID)
+	// the shared loop doesn't act on behalf of the subscriber which started it
+	require.Nil(t, cfg.pluginContext.User)
+	require.Equal(t, int64(1), cfg.pluginContext.OrgID)
+	require.Same(t, pluginContext.DataSourceInstanceSettings, cfg.pluginContext.DataSourceInstanceSettings)
+
+	_, err = config(`{"query":"SELECT count() FROM t","streamingMode":"full","streamingInterval":3000}`)
+	require.NoError(t, err)
+	// columns named format aren't clauses
+	_, err = config(`{"query":"SELECT offset, format FROM t WHERE $timeFilter ORDER BY format DESC","streamingMode":"tail","streamingTailColumn":"offset"}`)
+	require.NoError(t, err)
+
+	for raw, message := range map[string]string{
+		`[]`:            "failed to unmarshal stream query",
+		`{"query":" "}`: "stream query is empty",
+		`{"query":"SELECT 1","streamingMode":"watch"}`:                                                                    "unknown streamingMode",
+		`{"query":"SELECT 1","streamingMode":"tail"}`:                                                                     "requires streamingTailColumn",
+		`{"query":"SELECT 1","streamingMode":"tail","streamingTailColumn":"a; DROP"}`:                                     "requires streamingTailColumn",
+		`{"query":"SELECT 1","streamingMode":"tail","streamingTailColumn":"offset","streamingInterval":100}`:              "less than 200ms",
+		`{"query":"$columns(host, count() c) FROM t","streamingMode":"tail","streamingTailColumn":"offset"}`:              "$columns aggregates them by time",
+		`{"query":"SELECT offset FROM t FORMAT JSONEachRow;","streamingMode":"tail","streamingTailColumn":"offset"}`:      "FORMAT at the end of the query isn't supported",
+		`{"query":"SELECT offset FROM t SETTINGS max_threads = 1","streamingMode":"tail","streamingTailColumn":"offset"}`: "SETTINGS at the end of the query isn't supported",
+		`{"query":"SELECT count() FROM t"}`:                                                                               "Delta streaming mode requires a time-scoping macro",
+		`{"query":"SELECT 1","streamingMode":"full","streamingInterval":-1}`:                                              "less than 1000ms",
+		`{"query":"SELECT 1","streamingMode":"full","streamingLookback":-1}`:                                              "streamingLookback -1 is negative",
+		`{"query":"SELECT 1","streamingMode":"full","timeRange":{"from":"now-1h"}}`:                                       "invalid timeRange.from",
+	} {
+		_, err := config(raw)
+		require.ErrorContains(t, err, message, raw)
+		require.NotErrorIs(t, err, errStreamLimit, raw)
+	}
+
+	_, err = config(`{"query":"SELECT 1","streamingMode":"full","streamingInterval":1000}`)
+	require.ErrorIs(t, err, errStreamLimit)
+}
+
+func TestSubscribeStream(t *testing.T) {
+	ds := &ClickHouseDatasource{im: &testInstanceManager{settings: &DatasourceSettings{MaxStreams: 1, MinStreamingInterval: 2000}}}
+	pluginContext := backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "ch"}}
+	subscribe := func(path, raw string) backend.SubscribeStreamStatus {
+		resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{PluginContext: pluginContext, Path: path, Data: json.RawMessage(raw)})
+		require.NoError(t, err)
+		return resp.Status
+	}
+	query := `{"refId":"A","query":"SELECT 1","streamingMode":"full","timeRange":{"from":"2024-01-15T10:00:00Z"}}`
+
+	require.Equal(t, backend.SubscribeStreamStatusNotFound, subscribe("other/A", query))
+	require.Equal(t, backend.SubscribeStreamStatusNotFound, subscribe("stream/A/1", `{"query":"SELECT 1","streamingMode":"watch"}`))
+	require.Equal(t, backend.SubscribeStreamStatusPermissionDenied, subscribe("stream/A/1", `{"query":"SELECT 1","streamingMode":"full","streamingInterval":1000}`))
+	require.Equal(t, backend.SubscribeStreamStatusOK, subscribe("stream/A/1", query))
+
+	// the running stream of the same query is shared, other queries exceed the limit
+	cfg, err := newStreamConfig(pluginContext, json.RawMessage(query), ds.im.(*testInstanceManager).settings)
+	require.NoError(t, err)
+	subscriber := &streamSubscriber{sender: &testStreamSender{}, refId: "A"}
+	session := ds.streams.join(context.Background(), cfg.key, cfg.datasourceUID, subscriber, func(ctx context.Context, _ streamFrameSender) error {
+		<-ctx.Done()
+		return nil
+	})
+	defer ds.streams.leave(session, subscriber)
+	require.Equal(t, backend.SubscribeStreamStatusOK, subscribe("stream/B/1", query))
+	require.Equal(t, backend.SubscribeStreamStatusPermissionDenied, subscribe("stream/C/2", `{"refId":"C","query":"SELECT 2","streamingMode":"full"}`))
+}
+
+func TestMergeFramesToWideKeepsTypes(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Minute)
+	merged := mergeFramesToWide(map[string]*data.Frame{
+		"host0": data.NewFrame("host0", data.NewField("t", nil, []time.Time{t0, t1}), data.NewField("status", nil, []string{"ok", "fail"})),
+		"host1": data.NewFrame("host1", data.NewField("t", nil, []time.Time{t1}), data.NewField("count", data.Labels{"host": "host1"}, []uint64{7})),
+	})
+	require.Equal(t, 2, merged.Rows())
+	require.Equal(t, data.FieldTypeNullableString, merged.Fields[1].Type())
+	require.Equal(t, "fail", *merged.Fields[1].At(1).(*string))
+	require.Equal(t, data.FieldTypeNullableUint64, merged.Fields[2].Type())
+	require.Nil(t, merged.Fields[2].At(0))
+	require.Equal(t, uint64(7), *merged.Fields[2].At(1).(*uint64))
+	require.Equal(t, data.Labels{"host": "host1"}, merged.Fields[2].Labels)
+}
+
+func TestUpsertFrameRows(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	accumulated := data.NewFrame("", data.NewField("t", nil, []time.Time{t0, t0.Add(time.Minute)}), data.NewField("value", nil, []float64{1, 2}))
+	// the lookback re-queries the last bucket
+	accumulated = upsertFrameRows(accumulated, data.NewFrame("", data.NewField("t", nil, []time.Time{t0.Add(time.Minute), t0.Add(2 * time.Minute)}), data.NewField("value", nil, []float64{5, 3})))
+	require.Equal(t, 3, accumulated.Rows())
+	require.Equal(t, 5.0, accumulated.Fields[1].At(1))
+
+	// the value became a string, e.g. a Decimal with more than 15 digits, new rows replace the accumulated ones
+	changed := data.NewFrame("", data.NewField("t", nil, []time.Time{t0.Add(2 * time.Minute)}), data.NewField("value", nil, []string{"1234567.1234567891"}))
+	require.NotPanics(t, func() { accumulated = upsertFrameRows(accumulated, changed) })
+	require.Same(t, changed, accumulated)
+}
+
+func TestAppendFrameRows(t *testing.T) {
+	t0 := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	logs := func(times []time.Time, ids []uint64, messages []string) *data.Frame {
+		return data.NewFrame("", data.NewField("timestamp", nil, times), data.NewField("id", nil, ids), data.NewField("content", nil, messages))
+	}
+	accumulated := logs([]time.Time{t0, t0}, []uint64{1, 2}, []string{"a", "b"})
+	// the lookback re-queries the rows of t0, the row with the same time and another id is new
+	accumulated = appendFrameRows(accumulated, logs([]time.Time{t0, t0, t0.Add(time.Second)}, []uint64{2, 3, 4}, []string{"b", "c", "d"}), "id")
+	require.Equal(t, 4, accumulated.Rows())
+	require.Equal(t, "c", accumulated.Fields[2].At(2))
+
+	// without the unique column rows are compared by all values
+	accumulated = appendFrameRows(accumulated, logs([]time.Time{t0, t0}, []uint64{1, 1}, []string{"a", "e"}), "")
+	require.Equal(t, 5, accumulated.Rows())
+	require.Equal(t, "e", accumulated.Fields[2].At(4))
+
+	// the new column changes the schema, new rows replace the accumulated ones instead of being dropped
+	level := "info"
+	changed := data.NewFrame("",
+		data.NewField("timestamp", nil, []time.Time{t0.Add(2 * time.Second)}),
+		data.NewField("id", nil, []uint64{7}),
+		data.NewField("content", nil, []string{"f"}),
+		data.NewField("level", nil, []*string{&level}))
+	accumulated = appendFrameRows(accumulated, changed, "id")
+	require.Same(t, changed, accumulated)
+	accumulated = appendFrameRows(accumulated, data.NewFrame("",
+		data.NewField("timestamp", nil, []time.Time{t0.Add(3 * time.Second)}),
+		data.NewField("id", nil, []uint64{8}),
+		data.NewField("content", nil, []string{"g"}),
+		data.NewField("level", nil, []*string{nil})), "id")
+	require.Equal(t, 2, accumulated.Rows())
+	require.Equal(t, "g", accumulated.Fields[2].At(1))
+
+	// log rows aren't ordered by time
+	frames := map[string]*data.Frame{"content": logs([]time.Time{t0.Add(time.Hour), t0}, []uint64{5, 6}, []string{"new", "old"})}
+	trimAccumulatedFrames(frames, t0.Add(time.Minute))
+	require.Equal(t, 1, frames["content"].Rows())
+	require.Equal(t, "new", frames["content"].Fields[2].At(0))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Backend implementation of the "traces" query format, it follows src/datasource/sql-series/toTraces.ts.
+// Expected columns are traceID, spanID, parentSpanID, operationName, serviceName, startTime (milliseconds
+// or DateTime) and duration (milliseconds). tags, serviceTags and logs are optional and could be stored
+// as Map or Array(Tuple) columns, see https://grafana.com/docs/grafana/latest/panels-visualizations/visualizations/traces/#data-api
+
+// QueryFormatTraces is the value of Query.Format which requests trace frames
+const QueryFormatTraces = "traces"
+
+// traceDateTimeLayout parses DateTime and DateTime64 values of any precision
+const traceDateTimeLayout = "2006-01-02 15:04:05.999999999"
+
+type traceKeyValue struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type traceLog struct {
+	Timestamp float64         `json:"timestamp"`
+	Fields    []traceKeyValue `json:"fields"`
+}
+
+type traceFrameFields struct {
+	traceID       *data.Field
+	spanID        *data.Field
+	operationName *data.Field
+	parentSpanID  *data.Field
+	serviceName   *data.Field
+	startTime     *data.Field
+	duration      *data.Field
+	tags          *data.Field
+	serviceTags   *data.Field
+	logs          *data.Field
+}
+
+func newTraceFrameFields(hasLogs bool) *traceFrameFields {
+	fields := &traceFrameFields{
+		traceID:       data.NewField("traceID", nil, []string{}),
+		spanID:        data.NewField("spanID", nil, []string{}),
+		operationName: data.NewField("operationName", nil, []string{}),
+		parentSpanID:  data.NewField("parentSpanID", nil, []*string{}),
+		serviceName:   data.NewField("serviceName", nil, []string{}),
+		startTime:     data.NewField("startTime", nil, []float64{}),
+		duration:      data.NewField("duration", nil, []float64{}),
+		tags:          data.NewField("tags", nil, []json.RawMessage{}),
+		serviceTags:   data.NewField("serviceTags", nil, []json.RawMessage{}),
+	}
+	if hasLogs {
+		fields.logs = data.NewField("logs", nil, []json.RawMessage{})
+	}
+	return fields
+}
+
+func (f *traceFrameFields) frame(refID string) *data.Frame {
+	frame := data.NewFrame("", f.traceID, f.spanID, f.operationName, f.parentSpanID, f.serviceName, f.startTime, f.duration, f.tags, f.serviceTags)
+	if f.logs != nil {
+		frame.Fields = append(frame.Fields, f.logs)
+	}
+	frame.RefID = refID
+	frame.Meta = &data.FrameMeta{PreferredVisualization: data.VisTypeTrace}
+	return frame
+}
+
+// toFramesTraces builds one frame per traceID, Grafana traces panel computes critical paths per trace
+// and renders only the first frame, so frames are sorted by spans count descending.
+func (r *Response) toFramesTraces(query *Query, fetchTZ FetchTZFunc) (data.Frames, error) {
+	if r.metaByName("traceID") == nil || r.metaByName("spanID") == nil {
+		return nil, fmt.Errorf("traces format requires traceID and spanID columns")
+	}
+	timeZonesMap, metaTypes := r.analyzeResponseMeta(fetchTZ)
+	hasLogs := r.metaByName("logs") != nil
+
+	traces := map[string]*traceFrameFields{}
+	var traceIDs []string
+	err := r.forEachRow(func(row map[string]interface{}) error {
+		traceID := traceString(row["traceID"])
+		fields, exists := traces[traceID]
+		if !exists {
+			fields = newTraceFrameFields(hasLogs)
+			traces[traceID] = fields
+			traceIDs = append(traceIDs, traceID)
+		}
+		startTime, err := traceMilliseconds(row["startTime"], metaTypes["startTime"], timeZonesMap["startTime"])
+		if err != nil {
+			return fmt.Errorf("unable to parse startTime of span %v: %w", row["spanID"], err)
+		}
+		duration, err := traceMilliseconds(row["duration"], metaTypes["duration"], nil)
+		if err != nil {
+			return fmt.Errorf("unable to parse duration of span %v: %w", row["spanID"], err)
+		}
+
+		fields.traceID.Append(traceID)
+		// UInt64 span ids exceed float64 precision, they're kept as strings to build the span tree correctly
+		fields.spanID.Append(traceString(row["spanID"]))
+		fields.operationName.Append(traceString(row["operationName"]))
+		fields.parentSpanID.Append(traceParentSpanID(row["parentSpanID"]))
+		fields.serviceName.Append(traceString(row["serviceName"]))
+		fields.startTime.Append(startTime)
+		fields.duration.Append(duration)
+		fields.tags.Append(traceJSON(traceKeyValues(row["tags"])))
+		fields.serviceTags.Append(traceJSON(traceKeyValues(row["serviceTags"])))
+		if hasLogs {
+			fields.logs.Append(traceJSON(traceLogs(row["logs"], timeZonesMap["logs"])))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(traceIDs, func(i, j int) bool {
+		return traces[traceIDs[i]].traceID.Len() > traces[traceIDs[j]].traceID.Len()
+	})
+	frames := make(data.Frames, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		frames = append(frames, traces[traceID].frame(query.RefId))
+	}
+	return frames, nil
+}
+
+func traceString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// traceParentSpanID returns nil for root spans, ClickHouse usually stores them with empty or zero parent
+func traceParentSpanID(value interface{}) *string {
+	parentSpanID := traceString(value)
+	if parentSpanID == "" || parentSpanID == "0" {
+		return nil
+	}
+	return &parentSpanID
+}
+
+// traceMilliseconds converts DateTime values or numbers to milliseconds since epoch
+func traceMilliseconds(value interface{}, fieldType string, tz *time.Location) (float64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	if strings.Contains(fieldType, "Date") {
+		t, err := traceTime(value, tz)
+		if err != nil {
+			return 0, err
+		}
+		return float64(t.UnixNano()) / float64(time.Millisecond), nil
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case time.Time:
+		return float64(v.UnixNano()) / float64(time.Millisecond), nil
+	default:
+		return strconv.ParseFloat(traceString(v), 64)
+	}
+}
+
+func traceTime(value interface{}, tz *time.Location) (time.Time, error) {
+	if t, ok := value.(time.Time); ok {
+		return t, nil
+	}
+	if tz == nil {
+		tz = time.UTC
+	}
+	str := traceString(value)
+	if len(str) == len(dateLayout) {
+		return time.ParseInLocation(dateLayout, str, tz)
+	}
+	return time.ParseInLocation(traceDateTimeLayout, str, tz)
+}
+
+// traceKeyValues converts Map, Array(Tuple(key, value)) and named tuples values into key/value pairs
+func traceKeyValues(value interface{}) []traceKeyValue {
+	keyValues := []traceKeyValue{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if key, hasKey := v["key"]; hasKey && len(v) == 2 {
+			if tagValue, hasValue := v["value"]; hasValue {
+				return append(keyValues, traceKeyValue{Key: traceString(key), Value: tagValue})
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyValues = append(keyValues, traceKeyValue{Key: key, Value: v[key]})
+		}
+	case []interface{}:
+		for _, item := range v {
+			switch pair := item.(type) {
+			case []interface{}:
+				if len(pair) >= 2 {
+					keyValues = append(keyValues, traceKeyValue{Key: traceString(pair[0]), Value: pair[1]})
+				}
+			case map[string]interface{}:
+				keyValues = append(keyValues, traceKeyValues(pair)...)
+			}
+		}
+	}
+	return keyValues
+}
+
+// traceLogs converts Array(Tuple(timestamp, fields)) values, fields are Map or Array(Tuple(key, value))
+func traceLogs(value interface{}, tz *time.Location) []traceLog {
+	logs := []traceLog{}
+	items, ok := value.([]interface{})
+	if !ok {
+		return logs
+	}
+	for _, item := range items {
+		var timestamp, fields interface{}
+		switch log := item.(type) {
+		case []interface{}:
+			if len(log) < 2 {
+				continue
+			}
+			timestamp, fields = log[0], log[1]
+		case map[string]interface{}:
+			timestamp, fields = log["timestamp"], log["fields"]
+		default:
+			continue
+		}
+		ms, err := traceMilliseconds(timestamp, "", nil)
+		if err != nil {
+			t, timeErr := traceTime(timestamp, tz)
+			if timeErr != nil {
+				continue
+			}
+			ms = float64(t.UnixNano()) / float64(time.Millisecond)
+		}
+		logs = append(logs, traceLog{Timestamp: ms, Fields: traceKeyValues(fields)})
+	}
+	return logs
+}
+
+func traceJSON(value interface{}) json.RawMessage {
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return json.RawMessage("[]")
+	}
+	return valueJSON
+}
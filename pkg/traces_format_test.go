@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToFramesTraces(t *testing.T) {
+	r := &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "traceID", Type: "String"},
+			{Name: "spanID", Type: "UInt64"},
+			{Name: "parentSpanID", Type: "UInt64"},
+			{Name: "operationName", Type: "String"},
+			{Name: "serviceName", Type: "LowCardinality(String)"},
+			{Name: "startTime", Type: "DateTime64(3, 'UTC')"},
+			{Name: "duration", Type: "Float64"},
+			{Name: "tags", Type: "Map(String, String)"},
+			{Name: "serviceTags", Type: "Array(Tuple(String, String))"},
+			{Name: "logs", Type: "Array(Tuple(DateTime64(3, 'UTC'), Map(String, String)))"},
+		},
+		Data: []map[string]interface{}{
+			{
+				"traceID": "t1", "spanID": json.Number("18446744073709551615"), "parentSpanID": json.Number("0"),
+				"operationName": "query", "serviceName": "clickhouse", "startTime": "2024-01-15 10:00:00.123", "duration": 15.5,
+				"tags":        map[string]interface{}{"db": "default", "user": "admin"},
+				"serviceTags": []interface{}{[]interface{}{"host", "ch-1"}},
+				"logs":        []interface{}{[]interface{}{"2024-01-15 10:00:00.125", map[string]interface{}{"message": "read"}}},
+			},
+			{
+				"traceID": "t2", "spanID": json.Number("7"), "parentSpanID": json.Number("0"),
+				"operationName": "insert", "serviceName": "clickhouse", "startTime": "2024-01-15 10:00:01.000", "duration": 1.0,
+				"tags": map[string]interface{}{}, "serviceTags": []interface{}{}, "logs": []interface{}{},
+			},
+			{
+				"traceID": "t1", "spanID": json.Number("2"), "parentSpanID": json.Number("18446744073709551615"),
+				"operationName": "read", "serviceName": "clickhouse", "startTime": "2024-01-15 10:00:00.124", "duration": 3.0,
+				"tags": map[string]interface{}{}, "serviceTags": []interface{}{}, "logs": []interface{}{},
+			},
+		},
+	}
+
+	frames, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatTraces}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	// largest trace goes first
+	require.Len(t, frames, 2)
+	require.Equal(t, 2, frames[0].Rows())
+	require.Equal(t, 1, frames[1].Rows())
+
+	frame := frames[0]
+	require.Equal(t, "A", frame.RefID)
+	require.Equal(t, data.VisTypeTrace, string(frame.Meta.PreferredVisualization))
+	require.Equal(t, "18446744073709551615", frame.Fields[1].At(0))
+	require.Nil(t, frame.Fields[3].At(0))
+	require.Equal(t, "18446744073709551615", *frame.Fields[3].At(1).(*string))
+	require.Equal(t, float64(time.Date(2024, 1, 15, 10, 0, 0, 123000000, time.UTC).UnixMilli()), frame.Fields[5].At(0))
+	require.Equal(t, 15.5, frame.Fields[6].At(0))
+	require.JSONEq(t, `[{"key":"db","value":"default"},{"key":"user","value":"admin"}]`, string(frame.Fields[7].At(0).(json.RawMessage)))
+	require.JSONEq(t, `[{"key":"host","value":"ch-1"}]`, string(frame.Fields[8].At(0).(json.RawMessage)))
+	require.Equal(t, "logs", frame.Fields[9].Name)
+	require.JSONEq(t, `[{"timestamp":1705312800125,"fields":[{"key":"message","value":"read"}]}]`, string(frame.Fields[9].At(0).(json.RawMessage)))
+}
+
+func TestToFramesTracesRequiresSpanColumns(t *testing.T) {
+	r := &Response{
+		ctx:  context.Background(),
+		Meta: []*FieldMeta{{Name: "traceID", Type: "String"}},
+	}
+	_, err := r.toFrames(&Query{RefId: "A", Format: QueryFormatTraces}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.Error(t, err)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// finishTimeSeriesFrames applies per query post-processing to frames built by toFramesWithTimeStamp
+func finishTimeSeriesFrames(frames data.Frames, query *Query) data.Frames {
+	if query.Extrapolate {
+		extrapolateFrames(frames, query)
+	}
+	if query.WideFormat && len(frames) > 1 {
+		wide := alignFramesToWide(frames)
+		wide.RefID = query.RefId
+		return data.Frames{wide}
+	}
+	return frames
+}
+
+// alignFramesToWide joins time series frames by time into a single frame with shared time field.
+// Unlike mergeFramesToWide in streaming, value fields keep the frames order, names, labels and types,
+// they only become nullable, because some series may not have data in every time bucket.
+//
+// Input:  [t, host0], [t, host1]
+// Output: [t, host0, host1]
+func alignFramesToWide(frames data.Frames) *data.Frame {
+	timeSet := map[int64]time.Time{}
+	timeFieldName := ""
+	for _, frame := range frames {
+		if len(frame.Fields) == 0 {
+			continue
+		}
+		timeField := frame.Fields[0]
+		if timeFieldName == "" {
+			timeFieldName = timeField.Name
+		}
+		for i := 0; i < timeField.Len(); i++ {
+			if t, ok := timeField.ConcreteAt(i); ok {
+				timeSet[t.(time.Time).UnixNano()] = t.(time.Time)
+			}
+		}
+	}
+
+	sortedTimes := make([]int64, 0, len(timeSet))
+	for ts := range timeSet {
+		sortedTimes = append(sortedTimes, ts)
+	}
+	sort.Slice(sortedTimes, func(i, j int) bool { return sortedTimes[i] < sortedTimes[j] })
+	timeIdx := make(map[int64]int, len(sortedTimes))
+	timeValues := make([]time.Time, len(sortedTimes))
+	for i, ts := range sortedTimes {
+		timeIdx[ts] = i
+		timeValues[i] = timeSet[ts]
+	}
+
+	wide := data.NewFrame("", data.NewField(timeFieldName, nil, timeValues))
+	for _, frame := range frames {
+		if len(frame.Fields) < 2 {
+			continue
+		}
+		timeField := frame.Fields[0]
+		for _, src := range frame.Fields[1:] {
+			dst := data.NewFieldFromFieldType(src.Type().NullableType(), len(timeValues))
+			dst.Name = src.Name
+			dst.Labels = src.Labels
+			dst.Config = src.Config
+			for i := 0; i < src.Len(); i++ {
+				t, ok := timeField.ConcreteAt(i)
+				if !ok {
+					continue
+				}
+				if v, ok := src.ConcreteAt(i); ok {
+					dst.SetConcrete(timeIdx[t.(time.Time).UnixNano()], v)
+				}
+			}
+			wide.Fields = append(wide.Fields, dst)
+		}
+	}
+	return wide
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func newMultiSeriesResponse() *Response {
+	return &Response{
+		ctx: context.Background(),
+		Meta: []*FieldMeta{
+			{Name: "event_time", Type: "DateTime('UTC')"},
+			{Name: "host", Type: "String"},
+			{Name: "requests", Type: "UInt64"},
+		},
+		Data: []map[string]interface{}{
+			{"event_time": "2024-01-15 10:00:00", "host": "web", "requests": "150"},
+			{"event_time": "2024-01-15 10:00:00", "host": "api", "requests": "300"},
+			{"event_time": "2024-01-15 10:01:00", "host": "db", "requests": "5"},
+			{"event_time": "2024-01-15 10:01:00", "host": "web", "requests": "200"},
+		},
+	}
+}
+
+func TestToFramesWithTimeStampOrder(t *testing.T) {
+	fetchTZ := func(ctx context.Context) *time.Location { return time.UTC }
+	for i := 0; i < 20; i++ {
+		frames, err := newMultiSeriesResponse().toFrames(&Query{RefId: "A"}, fetchTZ)
+		require.NoError(t, err)
+		require.Len(t, frames, 3)
+		// order of the first appearance in the result
+		require.Equal(t, "web", frames[0].Fields[1].Name)
+		require.Equal(t, "api", frames[1].Fields[1].Name)
+		require.Equal(t, "db", frames[2].Fields[1].Name)
+	}
+}
+
+func TestToFramesTableOrder(t *testing.T) {
+	r := &Response{
+		ctx:  context.Background(),
+		Meta: []*FieldMeta{{Name: "c", Type: "String"}, {Name: "a", Type: "String"}, {Name: "b", Type: "Float64"}},
+		Data: []map[string]interface{}{{"c": "1", "a": "2", "b": 3.0}},
+	}
+	frames, err := r.toFrames(&Query{RefId: "A"}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "a", "b"}, []string{frames[0].Name, frames[1].Name, frames[2].Name})
+}
+
+func TestToFramesWideFormat(t *testing.T) {
+	frames, err := newMultiSeriesResponse().toFrames(&Query{RefId: "A", WideFormat: true}, func(ctx context.Context) *time.Location { return time.UTC })
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+
+	wide := frames[0]
+	require.Equal(t, "A", wide.RefID)
+	require.Len(t, wide.Fields, 4)
+	require.Equal(t, "event_time", wide.Fields[0].Name)
+	require.Equal(t, 2, wide.Rows())
+	require.Equal(t, time.Date(2024, 1, 15, 10, 1, 0, 0, time.UTC), wide.Fields[0].At(1))
+
+	web := wide.Fields[1]
+	require.Equal(t, "web", web.Name)
+	require.Equal(t, data.Labels{"host": "web"}, web.Labels)
+	require.Equal(t, data.FieldTypeNullableFloat64, web.Type())
+	require.Equal(t, 150.0, *web.At(0).(*float64))
+	require.Equal(t, 200.0, *web.At(1).(*float64))
+
+	api := wide.Fields[2]
+	require.Equal(t, 300.0, *api.At(0).(*float64))
+	require.Nil(t, api.At(1))
+
+	db := wide.Fields[3]
+	require.Nil(t, db.At(0))
+	require.Equal(t, 5.0, *db.At(1).(*float64))
+}
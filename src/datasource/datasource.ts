@@ -23,11 +23,22 @@ import {CHDataSourceOptions, CHQuery, DatasourceMode, DEFAULT_QUERY} from '../ty
 import {QueryEditor, QueryEditorVariable} from '../views/QueryEditor/QueryEditor';
 import { getAdhocFilters } from '../views/QueryEditor/helpers/getAdHocFilters';
 import { from, merge, Observable } from 'rxjs';
-import { adhocFilterVariable, conditionalTest, convertTimestamp, createContextAwareInterpolation } from './helpers';
+import {
+  adhocFilterVariable,
+  conditionalTest,
+  convertTimestamp,
+  createContextAwareInterpolation,
+  dedupeStreamRows,
+} from './helpers';
 import { ClickHouseResourceClient } from './resource_handler';
 import { generateQueryForTimestampBackward, generateQueryForTimestampForward } from './log-context-query';
 import { IndexedDBManager } from '../utils/indexedDBManager';
 
+// rows kept by panels of the tail streaming mode, older rows are dropped
+const TAIL_BUFFER_ROWS = 10000;
+// rows kept by panels of the delta streaming mode with streamingDiff, including re-queried rows of the lookback
+const STREAM_DIFF_BUFFER_ROWS = 100000;
+
 export class CHDataSource
   extends DataSourceWithBackend<CHQuery, CHDataSourceOptions>
   implements DataSourceWithLogsContextSupport<CHQuery>, DataSourceWithToggleableQueryFiltersSupport<CHQuery>
@@ -561,6 +572,9 @@ export class CHDataSource
           streamingInterval: target.streamingInterval || 5000,
           streamingMode: target.streamingMode || 'delta',
           streamingLookback: target.streamingLookback ?? 1,
+          streamingTailColumn: target.streamingTailColumn || '',
+          streamingUniqueColumn: target.streamingUniqueColumn || '',
+          streamingDiff: target.streamingDiff || false,
           timeRange: {
             from: options.range.from.toISOString(),
             to: options.range.to.toISOString(),
@@ -576,7 +590,9 @@ export class CHDataSource
           '\n  maxDataPoints:', options.maxDataPoints,
         );
 
-        const channelPath = `stream/${target.refId}/${this.simpleHash(`${streamData.streamingMode}-${streamData.streamingInterval}-${streamData.streamingLookback}-${streamData.timeRange.from}-${target.query}`)}`;
+        const channelPath = `stream/${target.refId}/${this.simpleHash(`${streamData.streamingMode}-${streamData.streamingInterval}-${streamData.streamingLookback}-${streamData.streamingTailColumn}-${streamData.streamingUniqueColumn}-${streamData.streamingDiff}-${streamData.timeRange.from}-${streamData.timeRange.to}-${target.query}`)}`;
+        // the delta mode with streamingDiff sends changed rows, they're appended and rows older than the range length are dropped
+        const diffStream = streamData.streamingMode === 'delta' && streamData.streamingDiff;
         const liveStream = getGrafanaLiveSrv().getDataStream({
           addr: {
             scope: LiveChannelScope.DataSource,
@@ -586,9 +602,17 @@ export class CHDataSource
             path: channelPath,
             data: streamData,
           } as any,
-          buffer: {
-            action: StreamingFrameAction.Replace,
-          },
+          // tail mode sends only new rows, other modes send the whole result
+          buffer:
+            streamData.streamingMode === 'tail'
+              ? { action: StreamingFrameAction.Append, maxLength: TAIL_BUFFER_ROWS }
+              : diffStream
+                ? {
+                    action: StreamingFrameAction.Append,
+                    maxDelta: options.range.to.valueOf() - options.range.from.valueOf(),
+                    maxLength: STREAM_DIFF_BUFFER_ROWS,
+                  }
+                : { action: StreamingFrameAction.Replace },
         });
 
         console.log(`[streaming] CREATING Observable wrapper for refId=${target.refId}`);
@@ -643,7 +667,8 @@ export class CHDataSource
                 });
               }
 
-              subscriber.next(response);
+              // re-queried rows of the lookback are appended again, the panel gets the last row of each time
+              subscriber.next(diffStream ? { ...response, data: frames.map(dedupeStreamRows) } : response);
               console.log(`[streaming] EVENT #${eventCount} forwarded to Grafana panel`);
             },
             error: (err: any) => {
@@ -1,4 +1,11 @@
-import { interpolateQueryExpr, interpolateQueryExprWithContext, createContextAwareInterpolation, conditionalTest } from './index';
+import { FieldType } from '@grafana/data';
+import {
+  interpolateQueryExpr,
+  interpolateQueryExprWithContext,
+  createContextAwareInterpolation,
+  conditionalTest,
+  dedupeStreamRows,
+} from './index';
 
 describe('Variable Interpolation', () => {
   describe('interpolateQueryExpr (original)', () => {
@@ -1144,3 +1151,29 @@ describe('conditionalTest', () => {
     });
   });
 });
+
+describe('dedupeStreamRows', () => {
+  it('should keep the last row of each time', () => {
+    const frame = {
+      refId: 'A',
+      fields: [
+        { name: 't', type: FieldType.time, config: {}, values: [1000, 2000, 2000, 3000] },
+        { name: 'v', type: FieldType.number, config: {}, values: [1, 2, 20, 3] },
+      ],
+      length: 4,
+    };
+    const result = dedupeStreamRows(frame);
+    expect(result.length).toBe(3);
+    expect(result.refId).toBe('A');
+    expect(result.fields[0].values).toEqual([1000, 2000, 3000]);
+    expect(result.fields[1].values).toEqual([1, 20, 3]);
+  });
+
+  it('should return frames without duplicates or time field as is', () => {
+    const frame = {
+      fields: [{ name: 'v', type: FieldType.number, config: {}, values: [1, 1] }],
+      length: 2,
+    };
+    expect(dedupeStreamRows(frame)).toBe(frame);
+  });
+});
@@ -1,5 +1,5 @@
 import { TemplateSrv } from '@grafana/runtime';
-import { dateMath, TypedVariableModel } from '@grafana/data';
+import { DataFrame, dateMath, FieldType, TypedVariableModel } from '@grafana/data';
 import { each, isString, map } from 'lodash';
 
 export const conditionalTest = (query: string, templateSrv: TemplateSrv) => {
@@ -580,3 +580,32 @@ export const convertTimestamp = (date: any) => {
 
   return Math.floor(date.valueOf() / 1000);
 };
+
+/**
+ * Keeps the last row of each time of the streamed frame, rows are sorted by time.
+ * Streams which send only changed rows send re-queried rows of the lookback again, they're appended after the old ones.
+ *
+ * @param frame - Frame of the append buffer of the stream
+ * @returns Frame without the replaced rows, the frame itself when it has no time field
+ */
+export const dedupeStreamRows = (frame: DataFrame): DataFrame => {
+  const timeField = frame.fields.find((field) => field.type === FieldType.time);
+  if (!timeField) {
+    return frame;
+  }
+  const lastRows = new Map<number, number>();
+  for (let i = 0; i < frame.length; i++) {
+    lastRows.set(timeField.values[i], i);
+  }
+  if (lastRows.size === frame.length) {
+    return frame;
+  }
+  const rows = Array.from(lastRows.values()).sort((a, b) => timeField.values[a] - timeField.values[b]);
+  return {
+    name: frame.name,
+    refId: frame.refId,
+    meta: frame.meta,
+    fields: frame.fields.map((field) => ({ ...field, values: rows.map((i) => field.values[i]) })),
+    length: rows.length,
+  };
+};
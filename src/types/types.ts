@@ -49,6 +49,17 @@ export interface CHQuery extends DataQuery {
   skip_comments?: boolean;
   add_metadata?: boolean;
   nullifySparse?: boolean;
+  // backend returns all time series in a single frame with shared time field
+  wideFormat?: boolean;
+  // overrides datasource precisionPolicy for backend queries
+  precisionPolicy?: 'auto' | 'float64' | 'string' | 'split';
+  // ClickHouse settings for backend queries, only names from datasource allowedQuerySettings are accepted
+  clickhouseSettings?: Record<string, string | number | boolean | null>;
+  // overrides datasource cacheTTL for backend queries up to its value, e.g. '30s', '0' disables the result cache
+  cacheTTL?: string;
+  cacheAlignTimeRange?: boolean;
+  // fetch only new time buckets on refresh, see datasource deltaCache
+  deltaCache?: boolean;
 
   round?: string;
   intervalFactor?: number;
@@ -62,8 +73,14 @@ export interface CHQuery extends DataQuery {
 
   streaming?: boolean;
   streamingInterval?: number;
-  streamingMode?: 'delta' | 'full';
+  streamingMode?: 'delta' | 'full' | 'tail';
   streamingLookback?: number;
+  // monotonically increasing column followed by the tail streaming mode
+  streamingTailColumn?: string;
+  // identifies rows of logs and tables with the same time in the delta streaming mode
+  streamingUniqueColumn?: string;
+  // the delta streaming mode sends only changed rows, the panel keeps rows of the dashboard range length
+  streamingDiff?: boolean;
 }
 
 /**
@@ -78,6 +95,23 @@ export interface CHDataSourceOptions extends DataSourceJsonData {
   defaultDatabase?: string;
   useCompression?: boolean;
   compressionType?: string;
+  responseFormat?: string;
+  precisionPolicy?: string;
+  clickhouseSettings?: Record<string, string | number | boolean>;
+  allowedQuerySettings?: string[];
+  endpoints?: string[];
+  loadBalancing?: 'first_healthy' | 'round_robin' | 'random' | 'least_latency';
+  maxRetries?: number;
+  retryErrorCodes?: number[];
+  cacheMaxBytes?: number;
+  cacheTTL?: string;
+  cacheAlignTimeRange?: boolean;
+  deltaCache?: boolean;
+  maxStreams?: number;
+  minStreamingInterval?: number;
+  streamingLatencyBudget?: number;
+  streamCheckpointDir?: string;
+  streamCheckpointMaxAge?: string;
   // @todo remove workaround after merge https://github.com/grafana/grafana/pull/80858, also remove from src/plugin.json
   dataSourceUrl?: string;
   useDefaultConfiguration?: boolean;
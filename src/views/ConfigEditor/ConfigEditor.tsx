@@ -6,7 +6,7 @@ import _ from 'lodash';
 import { DefaultValues } from './FormParts/DefaultValues/DefaultValues';
 import { LANGUAGE_ID } from '../QueryEditor/components/QueryTextEditor/editor/initiateEditor';
 import { MONACO_EDITOR_OPTIONS } from '../constants';
-import { COMPRESSION_TYPE_OPTIONS } from './constants';
+import { COMPRESSION_TYPE_OPTIONS, RESPONSE_FORMAT_OPTIONS, PRECISION_POLICY_OPTIONS } from './constants';
 import { DEFAULT_VALUES_QUERY } from '../../datasource/adhoc';
 
 export interface CHSecureJsonData {
@@ -217,6 +217,36 @@ export function ConfigEditor(props: Props) {
             options={COMPRESSION_TYPE_OPTIONS}
           />
         </InlineField>
+        <InlineField
+          label="Backend response format"
+          labelWidth={32}
+          tooltip="ClickHouse output format used by backend queries (alerts, public dashboards, streaming). Native transfers values in binary form instead of JSON text, Native and JSONCompactEachRowWithNamesAndTypes are decoded row by row without keeping the whole response in memory, ArrowStream values are copied into frames without text parsing and fall back to JSON for unsupported column types"
+        >
+          <Select
+            data-test-id="response-format-select"
+            id="responseFormat"
+            allowCustomValue={false}
+            width={24}
+            value={jsonData.responseFormat || 'JSON'}
+            onChange={(value) => onFieldChange(value, 'responseFormat')}
+            options={RESPONSE_FORMAT_OPTIONS}
+          />
+        </InlineField>
+        <InlineField
+          label="Wide numbers precision"
+          labelWidth={32}
+          tooltip="How backend queries return Int64, Int128, Int256 and Decimal columns: auto keeps float64 while all values fit float64 and switches to strings otherwise, float64 always returns numbers (precision of large values is lost), string always keeps all digits, split returns columns with large values as two float64 fields name_high and name_low, their sum is the exact value"
+        >
+          <Select
+            data-test-id="precision-policy-select"
+            id="precisionPolicy"
+            allowCustomValue={false}
+            width={24}
+            value={jsonData.precisionPolicy || 'auto'}
+            onChange={(value) => onFieldChange(value, 'precisionPolicy')}
+            options={PRECISION_POLICY_OPTIONS}
+          />
+        </InlineField>
         <InlineField
           label="Configure AdHoc Filters request"
           labelWidth={32}
@@ -4,3 +4,17 @@ export const COMPRESSION_TYPE_OPTIONS = [
   { label: 'deflate', value: 'deflate' },
   { label: 'zstd', value: 'zstd' },
 ];
+
+export const RESPONSE_FORMAT_OPTIONS = [
+  { label: 'JSON', value: 'JSON' },
+  { label: 'Native', value: 'Native' },
+  { label: 'JSONCompactEachRowWithNamesAndTypes', value: 'JSONCompactEachRowWithNamesAndTypes' },
+  { label: 'ArrowStream', value: 'ArrowStream' },
+];
+
+export const PRECISION_POLICY_OPTIONS = [
+  { label: 'auto', value: 'auto' },
+  { label: 'float64', value: 'float64' },
+  { label: 'string', value: 'string' },
+  { label: 'split', value: 'split' },
+];
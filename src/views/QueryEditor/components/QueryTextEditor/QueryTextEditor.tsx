@@ -16,9 +16,12 @@ import {
   NullifySparseSwitch,
   UseWindowFunctionSwitch,
   StreamingSwitch,
+  StreamingDiffSwitch,
   StreamingIntervalInput,
   StreamingModeSelect,
   StreamingLookbackSelect,
+  StreamingTailColumnInput,
+  StreamingUniqueColumnInput,
   FormatAsSelect,
   ContextWindowSizeSelect,
   ToolbarButtons,
@@ -117,6 +120,24 @@ export const QueryTextEditor: React.FC<QueryTextEditorProps> = ({
                   onChange={(e: any) => handlers.handleStreamingLookbackChange(e.value)}
                 />
               )}
+              {(query.streamingMode || 'delta') === 'delta' && (
+                <StreamingDiffSwitch
+                  query={query}
+                  onChange={() => handlers.handleToggleField('streamingDiff')}
+                />
+              )}
+              {(query.streamingMode || 'delta') === 'delta' && (query.format === 'logs' || query.format === 'table') && (
+                <StreamingUniqueColumnInput
+                  query={query}
+                  handleStreamingUniqueColumnChange={handlers.handleStreamingUniqueColumnChange}
+                />
+              )}
+              {query.streamingMode === 'tail' && (
+                <StreamingTailColumnInput
+                  query={query}
+                  handleStreamingTailColumnChange={handlers.handleStreamingTailColumnChange}
+                />
+              )}
             </>
           )}
         </InlineFieldRow>
@@ -3,6 +3,12 @@ import { SelectableValue } from '@grafana/data';
 import { InlineField, InlineLabel, Select } from '@grafana/ui';
 import { Query } from '../../types';
 
+// sub-second intervals are allowed only for the tail mode
+const TAIL_INTERVAL_OPTIONS: Array<SelectableValue<number>> = [
+  { label: '250ms', value: 250, description: 'Log tail' },
+  { label: '500ms', value: 500, description: 'Log tail' },
+];
+
 const INTERVAL_OPTIONS: Array<SelectableValue<number>> = [
   { label: '1s',  value: 1000,  description: 'Real-time monitoring' },
   { label: '2s',  value: 2000,  description: 'Near real-time' },
@@ -32,7 +38,7 @@ export const StreamingIntervalInput: React.FC<StreamingIntervalInputProps> = ({
       width={12}
       data-testid="streaming-interval-select"
       onChange={handleStreamingIntervalChange}
-      options={INTERVAL_OPTIONS}
+      options={query.streamingMode === 'tail' ? [...TAIL_INTERVAL_OPTIONS, ...INTERVAL_OPTIONS] : INTERVAL_OPTIONS}
       value={query.streamingInterval || 5000}
     />
   </InlineField>
@@ -0,0 +1,33 @@
+import React, { ChangeEvent } from 'react';
+import { InlineField, InlineLabel, Input } from '@grafana/ui';
+import { Query } from '../../types';
+
+export interface StreamingTailColumnInputProps {
+  query: Query;
+  handleStreamingTailColumnChange: (event: ChangeEvent<HTMLInputElement>) => void;
+}
+
+export const StreamingTailColumnInput: React.FC<StreamingTailColumnInputProps> = ({
+  query,
+  handleStreamingTailColumnChange,
+}) => (
+  <InlineField
+    label={
+      <InlineLabel
+        width={18}
+        tooltip="Monotonically increasing column of the query result, e.g. an offset or an insert time. Each poll fetches only rows with a greater value."
+      >
+        Tail column
+      </InlineLabel>
+    }
+    invalid={!query.streamingTailColumn}
+  >
+    <Input
+      width={16}
+      data-testid="streaming-tail-column-input"
+      placeholder="_offset"
+      onChange={handleStreamingTailColumnChange}
+      value={query.streamingTailColumn || ''}
+    />
+  </InlineField>
+);
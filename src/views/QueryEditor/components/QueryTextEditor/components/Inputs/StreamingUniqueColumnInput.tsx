@@ -0,0 +1,32 @@
+import React, { ChangeEvent } from 'react';
+import { InlineField, InlineLabel, Input } from '@grafana/ui';
+import { Query } from '../../types';
+
+export interface StreamingUniqueColumnInputProps {
+  query: Query;
+  handleStreamingUniqueColumnChange: (event: ChangeEvent<HTMLInputElement>) => void;
+}
+
+export const StreamingUniqueColumnInput: React.FC<StreamingUniqueColumnInputProps> = ({
+  query,
+  handleStreamingUniqueColumnChange,
+}) => (
+  <InlineField
+    label={
+      <InlineLabel
+        width={18}
+        tooltip="Column which identifies rows with the same time, e.g. a log id. New rows are appended, rows which are already shown are skipped. When it's empty, rows are compared by all columns."
+      >
+        Unique column
+      </InlineLabel>
+    }
+  >
+    <Input
+      width={16}
+      data-testid="streaming-unique-column-input"
+      placeholder="id"
+      onChange={handleStreamingUniqueColumnChange}
+      value={query.streamingUniqueColumn || ''}
+    />
+  </InlineField>
+);
@@ -14,6 +14,11 @@ const STREAMING_MODE_OPTIONS: Array<SelectableValue<string>> = [
     value: 'full',
     description: 'Re-runs the full query each poll. Use for lightweight queries without time macros.',
   },
+  {
+    label: 'Tail',
+    value: 'tail',
+    description: 'Pushes only new rows after the last value of the tail column. Use for logs and events.',
+  },
 ];
 
 export interface StreamingModeSelectProps {
@@ -41,7 +46,10 @@ export const StreamingModeSelect: React.FC<StreamingModeSelectProps> = ({ query,
               '\n\n' +
               'Full refresh: re-runs the entire query on every poll. ' +
               'Better for lightweight queries or queries without time filter macros. ' +
-              'Data is only sent to the panel when the result actually changes.'
+              'Data is only sent to the panel when the result actually changes.' +
+              '\n\n' +
+              'Tail: follows a monotonically increasing column like an offset or an insert time, ' +
+              'each poll fetches only rows with a greater value and the panel appends them.'
             }
           >
             Streaming mode
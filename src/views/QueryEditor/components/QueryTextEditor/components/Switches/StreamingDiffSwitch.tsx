@@ -0,0 +1,23 @@
+import React from 'react';
+import { InlineField, InlineLabel, InlineSwitch } from '@grafana/ui';
+import { SwitchProps } from '../../types';
+
+export const StreamingDiffSwitch: React.FC<SwitchProps> = ({ query, onChange }) => (
+  <InlineField
+    label={
+      <InlineLabel
+        width={18}
+        tooltip="Send only new and re-queried rows instead of the whole result on each poll, the panel keeps rows of the dashboard time range length"
+      >
+        Send changes only
+      </InlineLabel>
+    }
+  >
+    <InlineSwitch
+      transparent
+      data-testid="streaming-diff-switch"
+      value={query.streamingDiff}
+      onChange={onChange}
+    />
+  </InlineField>
+);
@@ -9,8 +9,11 @@ export * from './Switches/MetadataSwitch';
 export * from './Switches/NullifySparseSwitch';
 export * from './Switches/SkipCommentsSwitch';
 export * from './Switches/StreamingSwitch';
+export * from './Switches/StreamingDiffSwitch';
 export * from './Switches/UseWindowFunctionSwitch';
 export * from './Inputs/StreamingIntervalInput';
+export * from './Inputs/StreamingTailColumnInput';
+export * from './Inputs/StreamingUniqueColumnInput';
 export * from './Selects/StreamingModeSelect';
 export * from './Selects/StreamingLookbackSelect';
 export * from './Toolbar/ToolbarButtons';
@@ -38,6 +38,14 @@ export const useQueryHandlers = ({ onFieldChange, query }: UseQueryHandlersProps
     onFieldChange({ fieldName: 'streamingMode', value: value || 'delta' });
   };
 
+  const handleStreamingTailColumnChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onFieldChange({ fieldName: 'streamingTailColumn', value: event.target.value });
+  };
+
+  const handleStreamingUniqueColumnChange = (event: ChangeEvent<HTMLInputElement>) => {
+    onFieldChange({ fieldName: 'streamingUniqueColumn', value: event.target.value });
+  };
+
   const handleToggleField = (fieldName: string) => {
     onFieldChange({ fieldName, value: !query[fieldName] });
   };
@@ -51,6 +59,8 @@ export const useQueryHandlers = ({ onFieldChange, query }: UseQueryHandlersProps
     handleStreamingIntervalChange,
     handleStreamingModeChange,
     handleStreamingLookbackChange,
+    handleStreamingTailColumnChange,
+    handleStreamingUniqueColumnChange,
     handleToggleField,
   };
 };
@@ -17,8 +17,11 @@ export interface Query {
   datasourceMode?: DatasourceMode;
   streaming?: boolean;
   streamingInterval?: number;
-  streamingMode?: 'delta' | 'full';
+  streamingMode?: 'delta' | 'full' | 'tail';
   streamingLookback?: number;
+  streamingTailColumn?: string;
+  streamingUniqueColumn?: string;
+  streamingDiff?: boolean;
   query?: string;
 }
 